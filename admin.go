@@ -0,0 +1,400 @@
+package mongostore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SessionMeta describes a stored session without its data.
+type SessionMeta struct {
+	ID         string
+	UserID     string
+	IPAddress  string
+	CreatedAt  time.Time
+	ModifiedAt time.Time
+	ExpiresAt  time.Time
+}
+
+// metaProjection is the projection of the fields needed by SessionMeta.
+var metaProjection = bson.M{"data": 0, "values": 0}
+
+// sessionMeta returns the metadata of doc.
+func (s *MongoStore) sessionMeta(doc *Session) SessionMeta {
+	return SessionMeta{
+		ID:         s.sessionID(s.documentID(doc)),
+		UserID:     doc.UserID,
+		IPAddress:  doc.IPAddress,
+		CreatedAt:  doc.CreatedAt,
+		ModifiedAt: doc.ModifiedAt,
+		ExpiresAt:  doc.ExpiresAt,
+	}
+}
+
+// ListSessionsForUser returns the metadata of the sessions of the given user,
+// most recently modified first.
+func (s *MongoStore) ListSessionsForUser(ctx context.Context, userID string) ([]SessionMeta, error) {
+	if s.collection == nil {
+		return nil, ErrNoCollection
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "modifiedAt", Value: -1}}).
+		SetProjection(metaProjection).
+		SetCollation(s.indexCollation)
+	if s.userIDIndexHint {
+		opts.SetHint(UserIDIndexName)
+	}
+	return s.findMeta(ctx, bson.M{"userID": userID}, opts)
+}
+
+// SessionMetaPage is a page of sessions listed by ListSessionsMetadata.
+type SessionMetaPage struct {
+	Sessions []SessionMeta
+	// Next is the cursor of the next page.
+	Next string
+	// More reports whether there are sessions after this page.
+	More bool
+}
+
+// ListSessionsMetadata returns a page of at most pageSize (or 100 if pageSize
+// is not positive) session metadata, in key order, starting after cursor, the
+// Next cursor of the previous page, or at the first session if cursor is
+// empty. Pages are found with a range query on the ID index rather than by
+// skipping documents, so they are as fast to list at any depth, and stable
+// under insertions: sessions created while paginating are listed if their key
+// is after the cursor.
+//
+// Cursors are opaque. They record the BSON type of the key of the last
+// listed session, so that documents keyed by strings, such as legacy ones,
+// which sort before the ones keyed by ObjectIDs, are listed along with them.
+//
+// It returns an error wrapping ErrInvalidID if cursor is malformed.
+func (s *MongoStore) ListSessionsMetadata(ctx context.Context, cursor string, pageSize int) (SessionMetaPage, error) {
+	if s.collection == nil {
+		return SessionMetaPage{}, ErrNoCollection
+	}
+	if pageSize <= 0 {
+		pageSize = defaultBatchSize
+	}
+	filter := bson.M{"chunkOf": bson.M{"$exists": false}}
+	if cursor != "" {
+		after, err := s.afterCursor(cursor)
+		if err != nil {
+			return SessionMetaPage{}, err
+		}
+		filter = bson.M{"$and": bson.A{filter, after}}
+	}
+	// Fetch one more session to know whether there are more pages.
+	opts := options.Find().
+		SetSort(bson.D{{Key: s.keyField(), Value: 1}}).
+		SetLimit(int64(pageSize) + 1).
+		SetProjection(metaProjection)
+	metas, keys, err := s.findMetaKeys(ctx, filter, opts)
+	if err != nil {
+		return SessionMetaPage{}, err
+	}
+	page := SessionMetaPage{Sessions: metas, Next: cursor}
+	if len(metas) > pageSize {
+		page.Sessions, page.More = metas[:pageSize], true
+	}
+	if n := len(page.Sessions); n > 0 {
+		page.Next = pageCursor(keys[n-1])
+	}
+	return page, nil
+}
+
+// pageCursor returns the cursor of ListSessionsMetadata following the session
+// whose key field holds key: "o" and the hexadecimal string of ObjectID keys,
+// or "s" and string keys.
+func pageCursor(key bson.RawValue) string {
+	if id, ok := key.ObjectIDOK(); ok {
+		return "o" + id.Hex()
+	}
+	return "s" + key.StringValue()
+}
+
+// afterCursor returns the filter matching the sessions whose key sorts after
+// cursor, as returned by pageCursor. Since range queries only match keys of
+// the type of their bound, sessions keyed by ObjectIDs, which sort after
+// strings, are matched explicitly after a string key.
+func (s *MongoStore) afterCursor(cursor string) (bson.M, error) {
+	field := s.keyField()
+	switch key := cursor[1:]; {
+	case cursor[0] == 'o':
+		id, err := primitive.ObjectIDFromHex(key)
+		if err != nil {
+			return nil, fmt.Errorf("%w: cursor %q: %v", ErrInvalidID, cursor, err)
+		}
+		return bson.M{field: bson.M{"$gt": id}}, nil
+	case cursor[0] == 's' && key != "":
+		return bson.M{"$or": bson.A{
+			bson.M{field: bson.M{"$gt": key}},
+			bson.M{field: bson.M{"$type": "objectId"}},
+		}}, nil
+	}
+	return nil, fmt.Errorf("%w: cursor %q", ErrInvalidID, cursor)
+}
+
+// findMeta returns the metadata of the sessions matching filter.
+func (s *MongoStore) findMeta(ctx context.Context, filter interface{}, opts *options.FindOptions) ([]SessionMeta, error) {
+	metas, _, err := s.findMetaKeys(ctx, filter, opts)
+	return metas, err
+}
+
+// findMetaKeys is like findMeta, and also returns the key field of each
+// session.
+func (s *MongoStore) findMetaKeys(ctx context.Context, filter interface{}, opts *options.FindOptions) ([]SessionMeta, []bson.RawValue, error) {
+	cur, err := s.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer cur.Close(ctx)
+
+	var metas []SessionMeta
+	var keys []bson.RawValue
+	for cur.Next(ctx) {
+		var doc Session
+		if err := s.decodeDocument(cur.Current, &doc); err != nil {
+			return nil, nil, err
+		}
+		metas = append(metas, s.sessionMeta(&doc))
+		key := cur.Current.Lookup(s.keyField())
+		key.Value = append([]byte(nil), key.Value...)
+		keys = append(keys, key)
+	}
+	return metas, keys, cur.Err()
+}
+
+// DistinctNames returns the sorted names of the stored sessions, e.g. to
+// audit which kinds of sessions the collection holds. Documents without a
+// name, written by older versions of this package, are skipped, as are
+// chunks (see WithChunking).
+//
+// Names are compared exactly, regardless of the collation set with
+// WithIndexCollation: like cookie names, session names are case-sensitive,
+// and no index on name alone could serve the query anyway.
+func (s *MongoStore) DistinctNames(ctx context.Context) ([]string, error) {
+	if s.collection == nil {
+		return nil, ErrNoCollection
+	}
+	filter := bson.M{"name": bson.M{"$type": "string"}, "chunkOf": bson.M{"$exists": false}}
+	values, err := s.collection.Distinct(ctx, "name", filter)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(values))
+	for _, v := range values {
+		if name, ok := v.(string); ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// RawData returns the data stored for the session with the given ID, as
+// encoded by the store codecs, and its modification date. It is a debugging
+// affordance: the data is returned as stored, i.e. signed and possibly
+// encrypted, and is never decoded.
+//
+// It returns ErrSessionNotFound if there is no such session.
+func (s *MongoStore) RawData(ctx context.Context, id string) (data string, modifiedAt time.Time, err error) {
+	if s.collection == nil {
+		return "", time.Time{}, ErrNoCollection
+	}
+	objID, err := s.docID(id)
+	if err != nil {
+		return "", time.Time{}, ErrSessionNotFound
+	}
+	var doc Session
+	opts := options.FindOne().
+		SetProjection(bson.M{s.keyField(): 1, "data": 1, "modifiedAt": 1}).
+		SetSort(bson.D{{Key: "modifiedAt", Value: -1}})
+	if err := s.decodeResult(s.collection.FindOne(ctx, s.scopeFilter(ctx, s.idFilter(objID)), opts), &doc); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return "", time.Time{}, ErrSessionNotFound
+		}
+		return "", time.Time{}, err
+	}
+	return doc.Data, doc.ModifiedAt, nil
+}
+
+// DeleteSessionsForUser deletes the sessions of the given user, e.g. to log
+// them out everywhere, and returns the number of deleted sessions.
+func (s *MongoStore) DeleteSessionsForUser(ctx context.Context, userID string) (int64, error) {
+	if s.collection == nil {
+		return 0, ErrNoCollection
+	}
+	opts := options.Find().SetProjection(bson.M{s.keyField(): 1}).SetCollation(s.indexCollation)
+	if s.userIDIndexHint {
+		opts.SetHint(UserIDIndexName)
+	}
+	ids, err := s.findIDs(ctx, bson.M{"userID": userID}, opts)
+	if err != nil {
+		return 0, err
+	}
+	return s.deleteDocuments(ctx, ids)
+}
+
+// findIDs returns the document IDs of the sessions matching filter.
+func (s *MongoStore) findIDs(ctx context.Context, filter interface{}, opts *options.FindOptions) ([]interface{}, error) {
+	cur, err := s.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var ids []interface{}
+	for cur.Next(ctx) {
+		var doc Session
+		if err := s.decodeDocument(cur.Current, &doc); err != nil {
+			return nil, err
+		}
+		ids = append(ids, s.documentID(&doc))
+	}
+	return ids, cur.Err()
+}
+
+// deleteDocuments deletes the session documents with the given IDs, along
+// with their cache entries and chunks, and returns the number of deleted
+// documents.
+func (s *MongoStore) deleteDocuments(ctx context.Context, ids []interface{}) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	sessionIDs := make([]string, len(ids))
+	for i, id := range ids {
+		sessionIDs[i] = s.sessionID(id)
+	}
+	defer s.cacheInvalidate(ctx, sessionIDs...)
+	s.writeBuffer.discard(ids...)
+	res, err := s.collection.DeleteMany(ctx, s.idFilter(ids...))
+	if err != nil {
+		return 0, err
+	}
+	if s.chunkSize > 0 {
+		if _, err := s.collection.DeleteMany(ctx, bson.M{"chunkOf": bson.M{"$in": ids}}); err != nil {
+			s.log(ctx).Warnf("mongostore: could not delete chunks of deleted sessions: %v", err)
+		}
+	}
+	return res.DeletedCount, nil
+}
+
+// DeleteByIDs deletes the sessions with the given IDs, e.g. to revoke sessions
+// selected in an administration console, and returns the number of deleted
+// sessions, which is lower than the number of IDs if some sessions no longer
+// exist. Malformed IDs are skipped: the other sessions are deleted, and the
+// returned error wraps ErrInvalidID and lists them.
+func (s *MongoStore) DeleteByIDs(ctx context.Context, ids []string) (int64, error) {
+	if s.collection == nil {
+		return 0, ErrNoCollection
+	}
+	objIDs, invalidErr := s.docIDs(ids)
+	deleted, err := s.deleteDocuments(ctx, objIDs)
+	if err != nil {
+		return deleted, err
+	}
+	return deleted, invalidErr
+}
+
+// TouchMany extends the sessions with the given IDs as if they had been saved
+// unchanged, e.g. for a presence service keeping many sessions alive, and
+// returns the number of touched sessions. Sessions expire after the MaxAge
+// of their name (see WithPerNameMaxAge), or of the store. Unlike saves,
+// touches are unconditional, and expired sessions are not touched. Malformed
+// IDs are skipped, like with DeleteByIDs.
+//
+// The sessions are touched by a single update with an aggregation pipeline,
+// which requires MongoDB 4.2 or later.
+func (s *MongoStore) TouchMany(ctx context.Context, ids []string) (int64, error) {
+	if s.collection == nil {
+		return 0, ErrNoCollection
+	}
+	objIDs, invalidErr := s.docIDs(ids)
+	if len(objIDs) == 0 {
+		return 0, invalidErr
+	}
+	now := time.Now()
+	set := bson.M{"modifiedAt": s.timestamp(now)}
+	expiresAt := s.expiresAt(now, s.Options.MaxAge)
+	expiry := s.timestamp(expiresAt)
+	if expiresAt.IsZero() {
+		expiry = "$expiresAt"
+	}
+	if len(s.perNameMaxAge) > 0 {
+		var branches bson.A
+		for name, maxAge := range s.perNameMaxAge {
+			var then interface{} = "$expiresAt"
+			if nameExpiresAt := s.expiresAt(now, maxAge); !nameExpiresAt.IsZero() {
+				then = s.timestamp(nameExpiresAt)
+				if nameExpiresAt.After(expiresAt) {
+					expiresAt = nameExpiresAt
+				}
+			}
+			branches = append(branches, bson.M{"case": bson.M{"$eq": bson.A{"$name", name}}, "then": then})
+		}
+		expiry = bson.M{"$switch": bson.M{"branches": branches, "default": expiry}}
+	}
+	if !expiresAt.IsZero() {
+		set["expiresAt"] = expiry
+		if s.absoluteTimeout > 0 {
+			deadline := bson.M{"$add": bson.A{"$createdAt", s.absoluteTimeout.Milliseconds()}}
+			set["expiresAt"] = bson.M{"$min": bson.A{expiry, deadline}}
+		}
+	}
+	filter := s.idFilter(objIDs...)
+	filter["$or"] = bson.A{
+		bson.M{"expiresAt": bson.M{"$exists": false}},
+		bson.M{"expiresAt": bson.M{"$gte": s.timestamp(s.expiryCutoff(now))}},
+	}
+	res, err := s.collection.UpdateMany(ctx, filter, mongo.Pipeline{{{Key: "$set", Value: set}}})
+	s.cacheInvalidate(ctx, ids...)
+	if err != nil {
+		return 0, err
+	}
+	if s.chunkSize > 0 && !expiresAt.IsZero() {
+		// Chunks must not expire before their session: give them the latest
+		// expiry date.
+		if _, err := s.collection.UpdateMany(ctx, bson.M{"chunkOf": bson.M{"$in": objIDs}}, bson.M{"$set": bson.M{"expiresAt": s.timestamp(expiresAt)}}); err != nil {
+			s.log(ctx).Warnf("mongostore: could not touch chunks of sessions: %v", err)
+		}
+	}
+	return res.MatchedCount, invalidErr
+}
+
+// adminContext returns ctx bounded by the timeout set with
+// WithAdminOperationTimeout, unless ctx already has a deadline.
+func (s *MongoStore) adminContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok || s.adminTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, s.adminTimeout)
+}
+
+// docIDs returns the document IDs of the given session IDs. Malformed IDs are
+// skipped, and listed in the returned error, which wraps ErrInvalidID.
+func (s *MongoStore) docIDs(ids []string) ([]interface{}, error) {
+	var objIDs []interface{}
+	var invalid []string
+	for _, id := range ids {
+		objID, err := s.docID(id)
+		if err != nil {
+			invalid = append(invalid, strconv.Quote(id))
+			continue
+		}
+		objIDs = append(objIDs, objID)
+	}
+	if len(invalid) > 0 {
+		return objIDs, fmt.Errorf("%w: %s", ErrInvalidID, strings.Join(invalid, ", "))
+	}
+	return objIDs, nil
+}
@@ -0,0 +1,480 @@
+package mongostore
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func BenchmarkTouchMany(b *testing.B) {
+	const n = 100
+	ctx := context.Background()
+	s := newTestStore(b)
+	ids := make([]string, n)
+	docs := make([]interface{}, n)
+	for i := range ids {
+		id := primitive.NewObjectID()
+		ids[i] = id.Hex()
+		docs[i] = bson.M{
+			"_id":        id,
+			"name":       "test",
+			"data":       "",
+			"modifiedAt": time.Now(),
+			"expiresAt":  time.Now().Add(time.Hour),
+		}
+	}
+	if _, err := s.collection.InsertMany(ctx, docs); err != nil {
+		b.Fatal(err)
+	}
+	b.Run("TouchMany", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if touched, err := s.TouchMany(ctx, ids); err != nil || touched != n {
+				b.Fatalf("TouchMany() = %d, %v, want %d", touched, err, n)
+			}
+		}
+	})
+	b.Run("per ID", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for _, id := range ids {
+				if _, err := s.TouchMany(ctx, []string{id}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+}
+
+// commandHint returns the index hinted by a find or update command.
+func commandHint(cmd bson.Raw) string {
+	if updates, ok := cmd.Lookup("updates").ArrayOK(); ok {
+		cmd, _ = updates.Index(0).Value().DocumentOK()
+	}
+	hint, _ := cmd.Lookup("hint").StringValueOK()
+	return hint
+}
+
+func TestIndexHints(t *testing.T) {
+	ctx := context.Background()
+	load := func(s *MongoStore, value string) error {
+		_, err := s.DecodeSessionCookie(ctx, "test", value)
+		return err
+	}
+	save := func(s *MongoStore, value string) error {
+		session, err := s.DecodeSessionCookie(ctx, "test", value)
+		if err != nil {
+			return err
+		}
+		session.Values["seen"] = true
+		return s.Save(httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRecorder(), session)
+	}
+	list := func(s *MongoStore, value string) error {
+		_, err := s.ListSessionsForUser(ctx, "alice")
+		return err
+	}
+	deleteAll := func(s *MongoStore, value string) error {
+		_, err := s.DeleteSessionsForUser(ctx, "alice")
+		return err
+	}
+	tests := []struct {
+		name    string
+		opts    []Option
+		op      func(s *MongoStore, value string) error
+		command string
+		want    string
+	}{
+		{"load", nil, load, "find", ""},
+		{"load with ID hint", []Option{WithIDIndexHint(true)}, load, "find", IDIndexName},
+		{"save", nil, save, "update", ""},
+		{"save with ID hint", []Option{WithIDIndexHint(true)}, save, "update", IDIndexName},
+		{"ListSessionsForUser", nil, list, "find", ""},
+		{"ListSessionsForUser with user ID hint", []Option{WithUserIDIndexHint(true)}, list, "find", UserIDIndexName},
+		{"DeleteSessionsForUser with user ID hint", []Option{WithUserIDIndexHint(true)}, deleteAll, "find", UserIDIndexName},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := newCommandRecorder(tt.command)
+			s, err := NewMongoStoreWithOptions(newTestCollection(t, options.Client().SetMonitor(recorder.monitor())), nil, testKeyPairs, tt.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := s.EnsureIndexes(ctx); err != nil {
+				t.Fatal(err)
+			}
+			session := sessions.NewSession(s, "test")
+			session.Options = s.sessionOptions(session.Name())
+			session.Values[DefaultUserIDKey] = "alice"
+			value := saveSession(t, s, session)
+			recorder.reset()
+			if err := tt.op(s, value); err != nil {
+				t.Fatal(err)
+			}
+			var hints []string
+			for _, cmd := range recorder.commands() {
+				hints = append(hints, commandHint(cmd))
+			}
+			if len(hints) == 0 {
+				t.Fatalf("no %s command was sent", tt.command)
+			}
+			for _, hint := range hints {
+				if hint != tt.want {
+					t.Fatalf("%s commands hinted %q, want %q", tt.command, hints, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestDeleteByIDs(t *testing.T) {
+	missing := primitive.NewObjectID().Hex()
+	tests := []struct {
+		name        string
+		extra       []string
+		wantDeleted int64
+		wantInvalid bool
+	}{
+		{"all present", nil, 2, false},
+		{"some missing", []string{missing}, 2, false},
+		{"malformed ID", []string{"not an ID"}, 2, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := newTestStore(t)
+			var ids []string
+			for i := 0; i < 3; i++ {
+				session := sessions.NewSession(s, "test")
+				session.Options = s.sessionOptions(session.Name())
+				saveSession(t, s, session)
+				ids = append(ids, session.ID)
+			}
+			// The last session is kept.
+			deleted, err := s.DeleteByIDs(ctx, append(ids[:2:2], tt.extra...))
+			if invalid := errors.Is(err, ErrInvalidID); invalid != tt.wantInvalid || (err != nil && !invalid) {
+				t.Fatalf("DeleteByIDs() error = %v, want ErrInvalidID: %v", err, tt.wantInvalid)
+			}
+			if tt.wantInvalid && !strings.Contains(err.Error(), tt.extra[0]) {
+				t.Fatalf("DeleteByIDs() error = %v, want it to list %q", err, tt.extra[0])
+			}
+			if deleted != tt.wantDeleted {
+				t.Fatalf("DeleteByIDs() = %d, want %d", deleted, tt.wantDeleted)
+			}
+			n, err := s.collection.CountDocuments(ctx, bson.M{})
+			if err != nil || n != 1 {
+				t.Fatalf("%d documents left, %v, want 1", n, err)
+			}
+		})
+	}
+}
+
+func TestListSessionsMetadata(t *testing.T) {
+	const n = 5
+	ctx := context.Background()
+	s := newTestStore(t)
+	var ids []string
+	for i := 0; i < n; i++ {
+		session := sessions.NewSession(s, "test")
+		session.Options = s.sessionOptions(session.Name())
+		saveSession(t, s, session)
+		ids = append(ids, session.ID)
+	}
+	sort.Strings(ids)
+	tests := []struct {
+		name      string
+		pageSize  int
+		wantPages int
+	}{
+		{"one per page", 1, 5},
+		{"partial last page", 2, 3},
+		{"exact page", n, 1},
+		{"larger page", 10, 1},
+		{"default page size", 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			var cursor string
+			pages := 0
+			for more := true; more; pages++ {
+				page, err := s.ListSessionsMetadata(ctx, cursor, tt.pageSize)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if tt.pageSize > 0 && len(page.Sessions) > tt.pageSize {
+					t.Fatalf("page of %d sessions, want at most %d", len(page.Sessions), tt.pageSize)
+				}
+				for _, meta := range page.Sessions {
+					got = append(got, meta.ID)
+				}
+				cursor, more = page.Next, page.More
+			}
+			if pages != tt.wantPages {
+				t.Errorf("%d pages, want %d", pages, tt.wantPages)
+			}
+			if !reflect.DeepEqual(got, ids) {
+				t.Fatalf("listed %v, want %v", got, ids)
+			}
+			page, err := s.ListSessionsMetadata(ctx, cursor, tt.pageSize)
+			if err != nil || len(page.Sessions) != 0 || page.More || page.Next != cursor {
+				t.Fatalf("page after the last = %+v, %v, want an empty page", page, err)
+			}
+		})
+	}
+	if _, err := s.ListSessionsMetadata(ctx, "not an ID", 1); !errors.Is(err, ErrInvalidID) {
+		t.Fatalf("ListSessionsMetadata() with a malformed cursor = %v, want ErrInvalidID", err)
+	}
+}
+
+func TestListSessionsMetadataKeyTypes(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	var legacy, current []string
+	for i := 0; i < 3; i++ {
+		id := primitive.NewObjectID().Hex()
+		if _, err := s.collection.InsertOne(ctx, bson.M{"_id": id, "name": "test", "data": "", "modifiedAt": time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+		legacy = append(legacy, id)
+		session := sessions.NewSession(s, "test")
+		session.Options = s.sessionOptions(session.Name())
+		saveSession(t, s, session)
+		current = append(current, session.ID)
+	}
+	sort.Strings(legacy)
+	sort.Strings(current)
+	// String keys sort before ObjectIDs.
+	want := append(legacy, current...)
+	tests := []struct {
+		name     string
+		pageSize int
+	}{
+		{"one per page", 1},
+		{"page across key types", 2},
+		{"single page", 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			var cursor string
+			for more := true; more; {
+				page, err := s.ListSessionsMetadata(ctx, cursor, tt.pageSize)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, meta := range page.Sessions {
+					got = append(got, meta.ID)
+				}
+				cursor, more = page.Next, page.More
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("listed %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestPageCursor(t *testing.T) {
+	id := primitive.NewObjectID()
+	tests := []struct {
+		name    string
+		key     interface{}
+		want    string
+		wantErr bool
+	}{
+		{"ObjectID", id, "o" + id.Hex(), false},
+		{"string", "legacy", "slegacy", false},
+	}
+	s := newUnitStore(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, err := bson.Marshal(bson.M{"_id": tt.key})
+			if err != nil {
+				t.Fatal(err)
+			}
+			cursor := pageCursor(bson.Raw(raw).Lookup("_id"))
+			if cursor != tt.want {
+				t.Fatalf("pageCursor() = %q, want %q", cursor, tt.want)
+			}
+			if _, err := s.afterCursor(cursor); err != nil {
+				t.Fatalf("afterCursor(%q) = %v", cursor, err)
+			}
+		})
+	}
+	for _, cursor := range []string{"o", "s", "onot hex", id.Hex()} {
+		if _, err := s.afterCursor(cursor); !errors.Is(err, ErrInvalidID) {
+			t.Errorf("afterCursor(%q) = %v, want ErrInvalidID", cursor, err)
+		}
+	}
+}
+
+func TestAdminContext(t *testing.T) {
+	later, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	tests := []struct {
+		name         string
+		ctx          context.Context
+		timeout      time.Duration
+		wantDeadline time.Duration
+	}{
+		{"no timeout", context.Background(), 0, 0},
+		{"timeout", context.Background(), time.Minute, time.Minute},
+		{"context deadline later than the timeout", later, time.Minute, time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newUnitStore(t, WithAdminOperationTimeout(tt.timeout))
+			start := time.Now()
+			ctx, cancel := s.adminContext(tt.ctx)
+			defer cancel()
+			deadline, ok := ctx.Deadline()
+			if ok != (tt.wantDeadline > 0) {
+				t.Fatalf("deadline set: %v, want %v", ok, tt.wantDeadline > 0)
+			}
+			if ok && (deadline.Before(start.Add(tt.wantDeadline-time.Second)) || deadline.After(time.Now().Add(tt.wantDeadline))) {
+				t.Fatalf("deadline in %s, want %s", deadline.Sub(start), tt.wantDeadline)
+			}
+		})
+	}
+}
+
+func TestAdminOperationTimeout(t *testing.T) {
+	tests := []struct {
+		name string
+		op   func(ctx context.Context, s *MongoStore) error
+	}{
+		{"GarbageCollect", func(ctx context.Context, s *MongoStore) error {
+			_, err := s.GarbageCollect(ctx)
+			return err
+		}},
+		{"MigrateSerialization", func(ctx context.Context, s *MongoStore) error {
+			_, err := s.MigrateSerialization(ctx, 0)
+			return err
+		}},
+		{"Backfill", func(ctx context.Context, s *MongoStore) error {
+			_, err := s.Backfill(ctx, 0)
+			return err
+		}},
+		{"RepairIDs", func(ctx context.Context, s *MongoStore) error {
+			_, err := s.RepairIDs(ctx)
+			return err
+		}},
+		{"UpdateTTL", func(ctx context.Context, s *MongoStore) error {
+			return s.UpdateTTL(ctx, 60)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The client is connected to an address nothing listens on, so
+			// that operations wait for a server until the timeout fires.
+			client, err := mongo.Connect(context.Background(), options.Client().ApplyURI("mongodb://127.0.0.1:1"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Disconnect(context.Background())
+			s, err := NewMongoStoreWithOptions(client.Database("mongostore_test").Collection("sessions"), nil, testKeyPairs,
+				WithAdminOperationTimeout(50*time.Millisecond))
+			if err != nil {
+				t.Fatal(err)
+			}
+			start := time.Now()
+			if err := tt.op(context.Background(), s); err == nil {
+				t.Fatalf("%s() succeeded without a server", tt.name)
+			}
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Fatalf("%s() returned after %s, want the admin operation timeout to fire", tt.name, elapsed)
+			}
+		})
+	}
+}
+
+func TestDistinctNames(t *testing.T) {
+	tests := []struct {
+		name string
+		docs []bson.M
+		want []string
+	}{
+		{"empty collection", nil, []string{}},
+		{"several names", []bson.M{{"name": "auth"}, {"name": "csrf"}, {"name": "auth"}, {"name": "prefs"}}, []string{"auth", "csrf", "prefs"}},
+		{"documents without a name", []bson.M{{"name": "auth"}, {}, {"name": nil}}, []string{"auth"}},
+		{"chunks", []bson.M{{"name": "auth"}, {"name": "chunk", "chunkOf": primitive.NewObjectID()}}, []string{"auth"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := newTestStore(t)
+			for _, doc := range tt.docs {
+				doc["_id"] = primitive.NewObjectID()
+				doc["data"] = ""
+				if _, err := s.collection.InsertOne(ctx, doc); err != nil {
+					t.Fatal(err)
+				}
+			}
+			names, err := s.DistinctNames(ctx)
+			if err != nil || !reflect.DeepEqual(names, tt.want) {
+				t.Fatalf("DistinctNames() = %v, %v, want %v", names, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRawData(t *testing.T) {
+	tests := []struct {
+		name    string
+		id      func(id string) string
+		wantErr error
+	}{
+		{"present session", nil, nil},
+		{"missing session", func(string) string { return primitive.NewObjectID().Hex() }, ErrSessionNotFound},
+		{"malformed ID", func(string) string { return "not an ID" }, ErrSessionNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := newTestStore(t)
+			session := sessions.NewSession(s, "test")
+			session.Options = s.sessionOptions(session.Name())
+			session.Values["user"] = "alice"
+			before := time.Now().Truncate(time.Millisecond)
+			saveSession(t, s, session)
+			id := session.ID
+			if tt.id != nil {
+				id = tt.id(id)
+			}
+			data, modifiedAt, err := s.RawData(ctx, id)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("RawData() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			var doc Session
+			if err := s.collection.FindOne(ctx, bson.M{}).Decode(&doc); err != nil {
+				t.Fatal(err)
+			}
+			if data == "" || data != doc.Data {
+				t.Fatalf("RawData() = %q, want the stored data %q", data, doc.Data)
+			}
+			if modifiedAt.Before(before) || modifiedAt.After(time.Now()) {
+				t.Fatalf("RawData() modified at %v, want after %v", modifiedAt, before)
+			}
+			// The data is returned as encoded by the codecs.
+			values := make(map[interface{}]interface{})
+			if err := s.decodeMulti("test", data, &values); err != nil || values["user"] != "alice" {
+				t.Fatalf("decoding RawData() = %v, %v", values, err)
+			}
+		})
+	}
+}
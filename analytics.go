@@ -0,0 +1,109 @@
+package mongostore
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SessionsPerDay returns the number of sessions created each day between from
+// (inclusive) and to (exclusive), keyed by UTC date in the "2006-01-02"
+// format. Days without any session are omitted. Documents written before
+// creation dates were recorded are counted on the day of their last
+// modification.
+//
+// The query is read-only and honours the read preference set with
+// WithAnalyticsReadPreference.
+func (s *MongoStore) SessionsPerDay(ctx context.Context, from, to time.Time) (map[string]int64, error) {
+	if s.collection == nil {
+		return nil, ErrNoCollection
+	}
+	var date interface{} = "$_createdAt"
+	if s.unixMillis {
+		date = bson.M{"$toDate": "$_createdAt"}
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$addFields", Value: bson.M{"_createdAt": bson.M{"$ifNull": bson.A{"$createdAt", "$modifiedAt"}}}}},
+		{{Key: "$match", Value: bson.M{"_createdAt": bson.M{"$gte": s.timestamp(from), "$lt": s.timestamp(to)}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   bson.M{"$dateToString": bson.M{"format": "%Y-%m-%d", "date": date}},
+			"count": bson.M{"$sum": 1},
+		}}},
+	}
+	cur, err := s.analyticsCollection().Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	counts := make(map[string]int64)
+	for cur.Next(ctx) {
+		var day struct {
+			Day   string `bson:"_id"`
+			Count int64  `bson:"count"`
+		}
+		if err := cur.Decode(&day); err != nil {
+			return nil, err
+		}
+		counts[day.Day] = day.Count
+	}
+	return counts, cur.Err()
+}
+
+// UserSessionCount is the number of active sessions of a user.
+type UserSessionCount struct {
+	UserID string `bson:"_id"`
+	Count  int64  `bson:"count"`
+}
+
+// SessionCountsByUser returns the top users by number of unexpired sessions,
+// most sessions first, e.g. to spot abuse. Only sessions saved with a user ID
+// are counted (see WithUserIDKey). A non-positive top returns all users.
+//
+// The query is read-only and honours the read preference set with
+// WithAnalyticsReadPreference. User IDs are grouped with the collation set
+// with WithIndexCollation, if any.
+func (s *MongoStore) SessionCountsByUser(ctx context.Context, top int) ([]UserSessionCount, error) {
+	if s.collection == nil {
+		return nil, ErrNoCollection
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"userID": bson.M{"$type": "string"},
+			"$or": bson.A{
+				bson.M{"expiresAt": bson.M{"$exists": false}},
+				bson.M{"expiresAt": bson.M{"$gte": s.timestamp(s.expiryCutoff(time.Now()))}},
+			},
+		}}},
+		{{Key: "$group", Value: bson.M{"_id": "$userID", "count": bson.M{"$sum": 1}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}}},
+	}
+	if top > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$limit", Value: top}})
+	}
+	cur, err := s.analyticsCollection().Aggregate(ctx, pipeline, options.Aggregate().SetCollation(s.indexCollation))
+	if err != nil {
+		return nil, err
+	}
+	var counts []UserSessionCount
+	if err := cur.All(ctx, &counts); err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+// analyticsCollection returns the session collection, configured with the
+// analytics read preference if any.
+func (s *MongoStore) analyticsCollection() *mongo.Collection {
+	if s.analyticsReadPref == nil {
+		return s.collection
+	}
+	c, err := s.collection.Clone(options.Collection().SetReadPreference(s.analyticsReadPref))
+	if err != nil {
+		return s.collection
+	}
+	return c
+}
@@ -0,0 +1,115 @@
+package mongostore
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestSessionsPerDay(t *testing.T) {
+	ctx := context.Background()
+	day := time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		opts []Option
+	}{
+		{"dates", nil},
+		{"Unix milliseconds", []Option{WithTimestampAsUnixMillis(true)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore(t, tt.opts...)
+			seed := []struct {
+				createdAt, modifiedAt time.Time
+			}{
+				{day.Add(time.Hour), day.Add(time.Hour)},
+				{day.Add(23 * time.Hour), day.Add(48 * time.Hour)},
+				{day.Add(25 * time.Hour), day.Add(26 * time.Hour)},
+				{time.Time{}, day.Add(50 * time.Hour)},
+				{day.Add(-time.Hour), day},
+				{day.Add(72 * time.Hour), day.Add(72 * time.Hour)},
+			}
+			var docs []interface{}
+			for _, d := range seed {
+				doc := bson.M{"_id": primitive.NewObjectID(), "name": "test", "data": "", "modifiedAt": s.timestamp(d.modifiedAt)}
+				if !d.createdAt.IsZero() {
+					doc["createdAt"] = s.timestamp(d.createdAt)
+				}
+				docs = append(docs, doc)
+			}
+			if _, err := s.collection.InsertMany(ctx, docs); err != nil {
+				t.Fatal(err)
+			}
+			got, err := s.SessionsPerDay(ctx, day, day.Add(72*time.Hour))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := map[string]int64{"2020-03-01": 2, "2020-03-02": 1, "2020-03-03": 1}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("SessionsPerDay() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestSessionCountsByUser(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	now := time.Now()
+	seed := []struct {
+		userID    string
+		expiresAt time.Time
+	}{
+		{"alice", now.Add(time.Hour)},
+		{"alice", now.Add(time.Hour)},
+		{"alice", time.Time{}},
+		{"bob", now.Add(time.Hour)},
+		{"bob", now.Add(time.Hour)},
+		{"bob", now.Add(-time.Hour)},
+		{"bob", now.Add(-time.Hour)},
+		{"carol", now.Add(time.Hour)},
+		{"dave", now.Add(-time.Hour)},
+		{"", now.Add(time.Hour)},
+	}
+	var docs []interface{}
+	for _, d := range seed {
+		doc := bson.M{"_id": primitive.NewObjectID(), "name": "test", "data": "", "modifiedAt": s.timestamp(now)}
+		if d.userID != "" {
+			doc["userID"] = d.userID
+		}
+		if !d.expiresAt.IsZero() {
+			doc["expiresAt"] = s.timestamp(d.expiresAt)
+		}
+		docs = append(docs, doc)
+	}
+	if _, err := s.collection.InsertMany(ctx, docs); err != nil {
+		t.Fatal(err)
+	}
+	all := []UserSessionCount{{"alice", 3}, {"bob", 2}, {"carol", 1}}
+	tests := []struct {
+		name string
+		top  int
+		want []UserSessionCount
+	}{
+		{"all users", 0, all},
+		{"negative top", -1, all},
+		{"top user", 1, all[:1]},
+		{"top two users", 2, all[:2]},
+		{"more than the users", 10, all},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.SessionCountsByUser(ctx, tt.top)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("SessionCountsByUser(%d) = %v, want %v", tt.top, got, tt.want)
+			}
+		})
+	}
+}
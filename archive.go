@@ -0,0 +1,92 @@
+package mongostore
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Reasons recorded in the archiveReason field of archived sessions (see
+// WithArchiveCollection).
+const (
+	archiveErased  = "erased"
+	archiveExpired = "expired"
+)
+
+// archive copies the session documents docs to the archive collection,
+// recording reason and the archival date. Archiving is best-effort: failures
+// are logged and reported to the OnArchiveError hook.
+func (s *MongoStore) archive(ctx context.Context, reason string, docs ...bson.Raw) {
+	if len(docs) == 0 {
+		return
+	}
+	now := time.Now()
+	copies := make([]interface{}, 0, len(docs))
+	for _, raw := range docs {
+		elems, err := raw.Elements()
+		if err != nil {
+			s.archiveFailed(ctx, err)
+			return
+		}
+		d := make(bson.D, 0, len(elems)+2)
+		for _, e := range elems {
+			d = append(d, bson.E{Key: e.Key(), Value: e.Value()})
+		}
+		d = append(d, bson.E{Key: "archivedAt", Value: s.timestamp(now)}, bson.E{Key: "archiveReason", Value: reason})
+		copies = append(copies, d)
+	}
+	if _, err := s.archiveCollection.InsertMany(ctx, copies, options.InsertMany().SetOrdered(false)); err != nil {
+		s.archiveFailed(ctx, err)
+	}
+}
+
+// archiveFailed logs and reports a failure to archive sessions.
+func (s *MongoStore) archiveFailed(ctx context.Context, err error) {
+	s.log(ctx).Warnf("mongostore: could not archive sessions: %v", err)
+	if s.hooks.OnArchiveError != nil {
+		s.hooks.OnArchiveError(err)
+	}
+}
+
+// archiveExpired archives and deletes the documents matching filter, in
+// batches, and returns the number of deleted documents. Chunks are deleted
+// without being archived.
+func (s *MongoStore) archiveExpired(ctx context.Context, filter bson.M) (int64, error) {
+	opts := options.Find().SetLimit(defaultBatchSize)
+	var deleted int64
+	for {
+		cur, err := s.collection.Find(ctx, filter, opts)
+		if err != nil {
+			return deleted, err
+		}
+		var docs []bson.Raw
+		var ids bson.A
+		for cur.Next(ctx) {
+			raw := make(bson.Raw, len(cur.Current))
+			copy(raw, cur.Current)
+			ids = append(ids, raw.Lookup("_id"))
+			if _, err := raw.LookupErr("chunkOf"); err != nil {
+				docs = append(docs, raw)
+			}
+		}
+		err = cur.Err()
+		cur.Close(ctx)
+		if err != nil {
+			return deleted, err
+		}
+		if len(ids) == 0 {
+			return deleted, nil
+		}
+		s.archive(ctx, archiveExpired, docs...)
+		res, err := s.collection.DeleteMany(ctx, bson.M{"$and": bson.A{filter, bson.M{"_id": bson.M{"$in": ids}}}})
+		if err != nil {
+			return deleted, err
+		}
+		deleted += res.DeletedCount
+		if len(ids) < defaultBatchSize {
+			return deleted, nil
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package mongostore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestArchiveCollection(t *testing.T) {
+	tests := []struct {
+		name       string
+		delete     func(t *testing.T, s *MongoStore, session *sessions.Session)
+		wantReason string
+	}{
+		{"erased", func(t *testing.T, s *MongoStore, session *sessions.Session) {
+			session.Options.MaxAge = -1
+			saveSession(t, s, session)
+		}, archiveErased},
+		{"expired", func(t *testing.T, s *MongoStore, session *sessions.Session) {
+			docID, err := s.docID(session.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			update := bson.M{"$set": bson.M{"expiresAt": s.timestamp(time.Now().Add(-time.Hour))}}
+			if _, err := s.collection.UpdateOne(context.Background(), s.idFilter(docID), update); err != nil {
+				t.Fatal(err)
+			}
+			if n, err := s.GarbageCollect(context.Background()); err != nil || n != 1 {
+				t.Fatalf("GarbageCollect() = %d, %v, want 1", n, err)
+			}
+		}, archiveExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			c := newTestCollection(t)
+			archive := c.Database().Collection(c.Name() + "_archive")
+			t.Cleanup(func() { _ = archive.Drop(context.Background()) })
+			s, err := NewMongoStoreWithOptions(c, nil, testKeyPairs, WithArchiveCollection(archive))
+			if err != nil {
+				t.Fatal(err)
+			}
+			kept := sessions.NewSession(s, "test")
+			kept.Options = s.sessionOptions(kept.Name())
+			saveSession(t, s, kept)
+			session := sessions.NewSession(s, "test")
+			session.Options = s.sessionOptions(session.Name())
+			session.Values["user"] = "alice"
+			saveSession(t, s, session)
+			tt.delete(t, s, session)
+
+			docID, err := s.docID(session.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n, err := c.CountDocuments(ctx, s.idFilter(docID)); err != nil || n != 0 {
+				t.Fatalf("%d sessions left, %v, want 0", n, err)
+			}
+			var archived []bson.M
+			cur, err := archive.Find(ctx, bson.M{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := cur.All(ctx, &archived); err != nil {
+				t.Fatal(err)
+			}
+			if len(archived) != 1 {
+				t.Fatalf("%d archived sessions, want 1", len(archived))
+			}
+			doc := archived[0]
+			if doc["_id"] != docID || doc["archiveReason"] != tt.wantReason || doc["archivedAt"] == nil || doc["data"] == nil {
+				t.Fatalf("archived %v, want session %v archived as %q", doc, docID, tt.wantReason)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package mongostore
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Binary session data (see WithBinaryData) is stored with a user-defined BSON
+// binary subtype: binarySubtype, plus the Compression of the values, plus
+// binaryText if the data is the text encoded by the codecs rather than the
+// bytes of its base64 encoding.
+const (
+	binarySubtype   = 0x80
+	binaryText      = 0x10
+	compressionMask = 0x0f
+)
+
+// dataValue returns the value of the data field of a document holding data
+// encoded by the codecs, whose values were compressed with c. It is a string,
+// or a binary holding the decoded base64 data with WithBinaryData.
+func (s *MongoStore) dataValue(data string, c Compression) interface{} {
+	if !s.binaryData {
+		return data
+	}
+	if b, err := base64.URLEncoding.DecodeString(data); err == nil && base64.URLEncoding.EncodeToString(b) == data {
+		return primitive.Binary{Subtype: binarySubtype | byte(c), Data: b}
+	}
+	return primitive.Binary{Subtype: binarySubtype | binaryText | byte(c), Data: []byte(data)}
+}
+
+// decodeBinaryDocument decodes the session document raw, whose data field is
+// binary, into doc.
+func (s *MongoStore) decodeBinaryDocument(raw bson.Raw, doc *Session) error {
+	subtype, b := raw.Lookup("data").Binary()
+	if subtype&^(binaryText|compressionMask) != binarySubtype {
+		return fmt.Errorf("mongostore: session data has unknown binary subtype %#x", subtype)
+	}
+	raw, err := withoutField(raw, "data")
+	if err != nil {
+		return err
+	}
+	if err := s.decodeFields(raw, doc); err != nil {
+		return err
+	}
+	if subtype&binaryText != 0 {
+		doc.Data = string(b)
+	} else {
+		doc.Data = base64.URLEncoding.EncodeToString(b)
+	}
+	doc.DataCompression = Compression(subtype & compressionMask)
+	return nil
+}
+
+// hasBinaryData reports whether the session document raw has binary data.
+func hasBinaryData(raw bson.Raw) bool {
+	return raw.Lookup("data").Type == bsontype.Binary
+}
@@ -0,0 +1,70 @@
+package mongostore
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestBinaryData(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        []Option
+		size        int
+		wantBinary  bool
+		wantSubtype byte
+	}{
+		{"text data", nil, 1000, false, 0},
+		{"uncompressed binary data", []Option{WithBinaryData(NoCompression)}, 1000, true, binarySubtype | byte(NoCompression)},
+		{"gzip binary data", []Option{WithBinaryData(GzipCompression)}, 1000, true, binarySubtype | byte(GzipCompression)},
+		{"zstd binary data", []Option{WithBinaryData(ZstdCompression)}, 1000, true, binarySubtype | byte(ZstdCompression)},
+		{"binary data below the compression threshold", []Option{WithBinaryData(ZstdCompression)}, 10, true, binarySubtype | byte(NoCompression)},
+	}
+	// Every store must load what any other stored.
+	loaders := []struct {
+		name string
+		opts []Option
+	}{
+		{"text store", nil},
+		{"binary store", []Option{WithBinaryData(NoCompression)}},
+		{"zstd store", []Option{WithBinaryData(ZstdCompression)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newUnitStore(t, tt.opts...)
+			session := sessions.NewSession(s, "test")
+			session.Values["blob"] = strings.Repeat("a", tt.size)
+			data, c, err := s.encodeData(session, session.Values)
+			if err != nil {
+				t.Fatal(err)
+			}
+			value := s.dataValue(data, c)
+			bin, isBinary := value.(primitive.Binary)
+			if isBinary != tt.wantBinary || bin.Subtype != tt.wantSubtype {
+				t.Fatalf("data stored as %T with subtype %#x, want binary: %v with subtype %#x", value, bin.Subtype, tt.wantBinary, tt.wantSubtype)
+			}
+			raw, err := bson.Marshal(bson.M{"_id": primitive.NewObjectID(), "name": "test", "data": value, "modifiedAt": time.Now()})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, loader := range loaders {
+				l := newUnitStore(t, loader.opts...)
+				var doc Session
+				if err := l.decodeDocument(raw, &doc); err != nil {
+					t.Fatalf("%s: decodeDocument() = %v", loader.name, err)
+				}
+				loaded := sessions.NewSession(l, "test")
+				if err := l.decodeData(loaded, &doc); err != nil {
+					t.Fatalf("%s: decodeData() = %v", loader.name, err)
+				}
+				if loaded.Values["blob"] != session.Values["blob"] {
+					t.Fatalf("%s: decoded values differ from the stored ones", loader.name)
+				}
+			}
+		})
+	}
+}
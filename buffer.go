@@ -0,0 +1,167 @@
+package mongostore
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// defaultBufferSize is the number of buffered writes triggering a flush when
+// the one given to WithWriteBuffer is not positive.
+const defaultBufferSize = 100
+
+// writeBuffer holds the session writes waiting to be flushed (see
+// WithWriteBuffer).
+type writeBuffer struct {
+	maxOps   int
+	maxDelay time.Duration
+
+	mu      sync.Mutex
+	pending map[interface{}]*bufferedWrite
+	order   []interface{}
+	timer   *time.Timer
+
+	// flushMu serializes flushes, so that successive writes of a session
+	// are applied in order.
+	flushMu sync.Mutex
+}
+
+// bufferedWrite is a buffered upsert of a session document. Successive
+// writes of a session are coalesced into a single one.
+type bufferedWrite struct {
+	sessionID string
+	filter    bson.M
+	set       bson.M
+	unset     bson.M
+	insert    bson.M
+	saves     int
+}
+
+// has reports whether a write of the document with the given ID is buffered.
+func (b *writeBuffer) has(id interface{}) bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, ok := b.pending[id]
+	return ok
+}
+
+// discard drops the buffered writes of the documents with the given IDs,
+// e.g. because they are deleted.
+func (b *writeBuffer) discard(ids ...interface{}) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, id := range ids {
+		if _, ok := b.pending[id]; !ok {
+			continue
+		}
+		delete(b.pending, id)
+		for i, pending := range b.order {
+			if pending == id {
+				b.order = append(b.order[:i], b.order[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// take removes and returns the buffered writes, in order.
+func (b *writeBuffer) take() ([]interface{}, map[interface{}]*bufferedWrite) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	order, pending := b.order, b.pending
+	b.order, b.pending = nil, nil
+	return order, pending
+}
+
+// bufferWrite buffers the upsert of the document with the given ID, made of
+// the $set, $unset and $setOnInsert operations of write. The buffer is
+// flushed by the caller once it holds the maximum number of writes.
+func (s *MongoStore) bufferWrite(ctx context.Context, id interface{}, sessionID string, set, unset, insert bson.M) error {
+	b := s.writeBuffer
+	filter := s.scopeFilter(ctx, bson.M{s.keyField(): id})
+	b.mu.Lock()
+	if b.pending == nil {
+		b.pending = make(map[interface{}]*bufferedWrite)
+	}
+	if w, ok := b.pending[id]; ok {
+		w.filter, w.set, w.unset = filter, set, unset
+		w.saves++
+	} else {
+		b.pending[id] = &bufferedWrite{sessionID: sessionID, filter: filter, set: set, unset: unset, insert: insert, saves: 1}
+		b.order = append(b.order, id)
+	}
+	full := len(b.order) >= b.maxOps
+	if !full && b.timer == nil && b.maxDelay > 0 {
+		b.timer = time.AfterFunc(b.maxDelay, func() {
+			// Flush logs its own errors.
+			_ = s.Flush(context.Background())
+		})
+	}
+	b.mu.Unlock()
+	if full {
+		return s.Flush(ctx)
+	}
+	return nil
+}
+
+// Flush writes the session saves buffered by WithWriteBuffer with a single
+// bulk write. Saves that fail are logged and lost: they are not retried.
+// Flush does nothing without a write buffer.
+func (s *MongoStore) Flush(ctx context.Context) error {
+	b := s.writeBuffer
+	if b == nil {
+		return nil
+	}
+	if s.collection == nil {
+		return ErrNoCollection
+	}
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+	order, pending := b.take()
+	if len(order) == 0 {
+		return nil
+	}
+	models := make([]mongo.WriteModel, 0, len(order))
+	sessionIDs := make([]string, 0, len(order))
+	for _, id := range order {
+		w := pending[id]
+		update := bson.D{
+			{Key: "$set", Value: w.set},
+			{Key: "$inc", Value: bson.M{"version": w.saves}},
+			{Key: "$setOnInsert", Value: w.insert},
+		}
+		if len(w.unset) > 0 {
+			update = append(update, bson.E{Key: "$unset", Value: w.unset})
+		}
+		model := mongo.NewUpdateOneModel().
+			SetFilter(w.filter).
+			SetUpdate(update).
+			SetUpsert(true)
+		if s.idIndexHint {
+			model.SetHint(IDIndexName)
+		}
+		models = append(models, model)
+		sessionIDs = append(sessionIDs, w.sessionID)
+	}
+	defer s.cacheInvalidate(ctx, sessionIDs...)
+	if _, err := s.collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false)); err != nil {
+		s.log(ctx).Errorf("mongostore: could not flush %d buffered session writes: %v", len(models), err)
+		return err
+	}
+	s.log(ctx).Debugf("mongostore: flushed %d buffered session writes", len(models))
+	return nil
+}
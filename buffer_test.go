@@ -0,0 +1,39 @@
+package mongostore
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/sessions"
+)
+
+func BenchmarkSave(b *testing.B) {
+	tests := []struct {
+		name string
+		opts []Option
+	}{
+		{"direct", nil},
+		{"buffered", []Option{WithWriteBuffer(100, 0)}},
+	}
+	for _, tt := range tests {
+		b.Run(tt.name, func(b *testing.B) {
+			s := newTestStore(b, tt.opts...)
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				session := sessions.NewSession(s, "test")
+				session.Options = s.sessionOptions(session.Name())
+				session.Values["user"] = "alice"
+				if err := s.Save(r, httptest.NewRecorder(), session); err != nil {
+					b.Fatal(err)
+				}
+			}
+			if err := s.Flush(context.Background()); err != nil {
+				b.Fatal(err)
+			}
+		})
+	}
+}
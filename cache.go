@@ -0,0 +1,201 @@
+package mongostore
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrCacheMiss is returned by CacheStore.Get when there is no entry for the
+// requested ID.
+var ErrCacheMiss = errors.New("cache miss")
+
+// CacheStore is a cache of session documents shared by store instances, e.g.
+// backed by Redis. Entries are serialized documents keyed by session ID.
+type CacheStore interface {
+	// Get returns the entry for id, or ErrCacheMiss.
+	Get(ctx context.Context, id string) ([]byte, error)
+	// Set stores the entry for id, for at most ttl.
+	Set(ctx context.Context, id string, data []byte, ttl time.Duration) error
+	// Delete removes the entry for id, if any.
+	Delete(ctx context.Context, id string) error
+}
+
+// cacheEntry is the model of a cache entry: a session document, along with
+// the compression of its data, which is not stored as such.
+type cacheEntry struct {
+	Session         `bson:",inline"`
+	DataCompression Compression `bson:"dataCompression,omitempty"`
+}
+
+// cacheGet returns the cached document of the session with the given ID.
+// Cache errors are logged and reported as misses.
+func (s *MongoStore) cacheGet(ctx context.Context, id string) (*Session, bool) {
+	if s.cache == nil {
+		return nil, false
+	}
+	b, err := s.cache.Get(ctx, id)
+	if s.hooks.OnCacheLookup != nil {
+		s.hooks.OnCacheLookup(err == nil)
+	}
+	if err != nil {
+		if errors.Is(err, ErrCacheMiss) {
+			s.log(ctx).Debugf("mongostore: cache miss for session %s", id)
+		} else {
+			s.log(ctx).Warnf("mongostore: could not get session %s from cache: %v", id, err)
+		}
+		return nil, false
+	}
+	var entry cacheEntry
+	if err := bson.Unmarshal(b, &entry); err != nil {
+		s.log(ctx).Warnf("mongostore: could not decode cached session %s: %v", id, err)
+		return nil, false
+	}
+	doc := entry.Session
+	doc.DataCompression = entry.DataCompression
+	return &doc, true
+}
+
+// cacheSet caches doc. Cache errors are logged.
+func (s *MongoStore) cacheSet(ctx context.Context, doc *Session) {
+	if s.cache == nil {
+		return
+	}
+	b, err := bson.Marshal(cacheEntry{Session: *doc, DataCompression: doc.DataCompression})
+	if err == nil {
+		err = s.cache.Set(ctx, s.sessionID(s.documentID(doc)), b, s.cacheTTL)
+	}
+	if err != nil {
+		s.log(ctx).Warnf("mongostore: could not cache session %s: %v", s.sessionID(s.documentID(doc)), err)
+	}
+}
+
+// cacheInvalidate removes the cached documents of the sessions with the
+// given IDs. Cache errors are logged.
+func (s *MongoStore) cacheInvalidate(ctx context.Context, ids ...string) {
+	if s.cache == nil {
+		return
+	}
+	for _, id := range ids {
+		if err := s.cache.Delete(ctx, id); err != nil {
+			s.log(ctx).Warnf("mongostore: could not remove session %s from cache: %v", id, err)
+		}
+	}
+}
+
+// WarmCache loads the sessions with the given IDs and caches them (see
+// WithSharedCache), e.g. so that the first requests after a restart do not all
+// hit MongoDB. Invalid, missing and expired IDs are skipped. WarmCache is a
+// no-op without a cache.
+func (s *MongoStore) WarmCache(ctx context.Context, ids []string) error {
+	if s.cache == nil {
+		return nil
+	}
+	if s.collection == nil {
+		return ErrNoCollection
+	}
+	docs, err := s.loadMany(ctx, ids)
+	if err != nil {
+		return err
+	}
+	for i := range docs {
+		s.cacheSet(ctx, &docs[i])
+	}
+	return nil
+}
+
+// loadMany returns the unexpired session documents with the given IDs, with
+// their chunks assembled. Invalid and missing IDs are skipped.
+func (s *MongoStore) loadMany(ctx context.Context, ids []string) ([]Session, error) {
+	objIDs := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		if objID, err := s.docID(id); err == nil {
+			objIDs = append(objIDs, objID)
+		}
+	}
+	if len(objIDs) == 0 {
+		return nil, nil
+	}
+	// Of duplicate documents, the most recently modified one wins.
+	opts := options.Find().SetSort(bson.D{{Key: "modifiedAt", Value: -1}})
+	cur, err := s.collection.Find(ctx, s.idFilter(objIDs...), opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	cutoff := s.expiryCutoff(time.Now())
+	var docs []Session
+	seen := make(map[interface{}]bool, len(objIDs))
+	for cur.Next(ctx) {
+		var doc Session
+		if err := s.decodeDocument(cur.Current, &doc); err != nil {
+			return nil, err
+		}
+		id := s.documentID(&doc)
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		if !doc.ExpiresAt.IsZero() && doc.ExpiresAt.Before(cutoff) {
+			continue
+		}
+		if err := s.assembleChunks(ctx, &doc); err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+	return docs, cur.Err()
+}
+
+// MemoryCache is an in-process CacheStore, mostly useful for tests.
+type MemoryCache struct {
+	mu      sync.Mutex
+	entries map[string]memoryCacheEntry
+}
+
+type memoryCacheEntry struct {
+	data      []byte
+	expiresAt time.Time
+}
+
+// NewMemoryCache returns an empty MemoryCache.
+func NewMemoryCache() *MemoryCache {
+	return &MemoryCache{entries: make(map[string]memoryCacheEntry)}
+}
+
+// Get implements CacheStore.
+func (c *MemoryCache) Get(ctx context.Context, id string) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[id]
+	if !ok || (!e.expiresAt.IsZero() && time.Now().After(e.expiresAt)) {
+		delete(c.entries, id)
+		return nil, ErrCacheMiss
+	}
+	return e.data, nil
+}
+
+// Set implements CacheStore.
+func (c *MemoryCache) Set(ctx context.Context, id string, data []byte, ttl time.Duration) error {
+	e := memoryCacheEntry{data: data}
+	if ttl > 0 {
+		e.expiresAt = time.Now().Add(ttl)
+	}
+	c.mu.Lock()
+	c.entries[id] = e
+	c.mu.Unlock()
+	return nil
+}
+
+// Delete implements CacheStore.
+func (c *MemoryCache) Delete(ctx context.Context, id string) error {
+	c.mu.Lock()
+	delete(c.entries, id)
+	c.mu.Unlock()
+	return nil
+}
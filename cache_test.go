@@ -0,0 +1,190 @@
+package mongostore
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestSharedCacheLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		cached  bool
+		ttl     time.Duration
+		wait    time.Duration
+		wantHit bool
+	}{
+		{"cached session", true, time.Minute, 0, true},
+		{"uncached session", false, time.Minute, 0, false},
+		{"expired cache entry", true, 20 * time.Millisecond, 50 * time.Millisecond, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			var hits, lookups int
+			hooks := Hooks{OnCacheLookup: func(hit bool) {
+				lookups++
+				if hit {
+					hits++
+				}
+			}}
+			// The unit store fails any query, so only cached sessions load.
+			s := newUnitStore(t, WithSharedCache(NewMemoryCache(), tt.ttl), WithHooks(hooks))
+			session := sessions.NewSession(s, "test")
+			session.ID = primitive.NewObjectID().Hex()
+			session.Values["user"] = "alice"
+			if tt.cached {
+				data, _, err := s.encodeData(session, session.Values)
+				if err != nil {
+					t.Fatal(err)
+				}
+				id, _ := primitive.ObjectIDFromHex(session.ID)
+				s.cacheSet(ctx, &Session{ID: id, Name: "test", Data: data, ModifiedAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour)})
+			}
+			time.Sleep(tt.wait)
+			loaded := sessions.NewSession(s, "test")
+			loaded.ID = session.ID
+			err := s.load(ctx, loaded)
+			if (err == nil) != tt.wantHit || (hits == 1) != tt.wantHit || lookups != 1 {
+				t.Fatalf("load() = %v with %d hits in %d lookups, want hit: %v", err, hits, lookups, tt.wantHit)
+			}
+			if tt.wantHit && loaded.Values["user"] != "alice" {
+				t.Fatalf("loaded values %v", loaded.Values)
+			}
+		})
+	}
+}
+
+func TestSharedCacheInvalidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		op      func(s *MongoStore, session *sessions.Session) error
+		deleted bool
+	}{
+		{"save", func(s *MongoStore, session *sessions.Session) error {
+			session.Values["user"] = "bob"
+			return s.Save(httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRecorder(), session)
+		}, false},
+		{"erase", func(s *MongoStore, session *sessions.Session) error {
+			return s.eraseID(context.Background(), session.ID)
+		}, true},
+		{"DeleteByIDs", func(s *MongoStore, session *sessions.Session) error {
+			_, err := s.DeleteByIDs(context.Background(), []string{session.ID})
+			return err
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			cache := NewMemoryCache()
+			s := newTestStore(t, WithSharedCache(cache, time.Minute))
+			session := sessions.NewSession(s, "test")
+			session.Options = s.sessionOptions(session.Name())
+			session.Values["user"] = "alice"
+			value := saveSession(t, s, session)
+			loaded := loadSession(t, s, "test", value)
+			if _, err := cache.Get(ctx, session.ID); err != nil {
+				t.Fatalf("loaded session not cached: %v", err)
+			}
+			if err := tt.op(s, loaded); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := cache.Get(ctx, session.ID); !errors.Is(err, ErrCacheMiss) {
+				t.Fatalf("cache Get() after %s = %v, want ErrCacheMiss", tt.name, err)
+			}
+			again := loadSession(t, s, "test", value)
+			if again.IsNew != tt.deleted || (!tt.deleted && again.Values["user"] != "bob") {
+				t.Fatalf("reloaded session (new: %v) with %v", again.IsNew, again.Values)
+			}
+		})
+	}
+}
+
+func TestCacheDataCompression(t *testing.T) {
+	tests := []struct {
+		name        string
+		compression Compression
+	}{
+		{"uncompressed", NoCompression},
+		{"gzip", GzipCompression},
+		{"zstd", ZstdCompression},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := newUnitStore(t, WithSharedCache(NewMemoryCache(), time.Minute))
+			doc := &Session{ID: primitive.NewObjectID(), Data: "data", ModifiedAt: time.Now(), DataCompression: tt.compression}
+			// The compression is derived from stored binary data.
+			stored, err := bson.Marshal(doc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := bson.Raw(stored).LookupErr("dataCompression"); err == nil {
+				t.Fatal("document stored with its data compression")
+			}
+			s.cacheSet(ctx, doc)
+			cached, ok := s.cacheGet(ctx, doc.ID.Hex())
+			if !ok {
+				t.Fatal("document not cached")
+			}
+			if cached.DataCompression != tt.compression || cached.Data != doc.Data {
+				t.Fatalf("cached document with data %q compressed with %d, want %q compressed with %d", cached.Data, cached.DataCompression, doc.Data, tt.compression)
+			}
+		})
+	}
+}
+
+func TestWarmCache(t *testing.T) {
+	missing := primitive.NewObjectID().Hex()
+	tests := []struct {
+		name  string
+		extra []string
+	}{
+		{"saved sessions", nil},
+		{"missing session", []string{missing}},
+		{"malformed ID", []string{"not an ID"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			cache := NewMemoryCache()
+			s := newTestStore(t, WithSharedCache(cache, time.Minute))
+			var ids []string
+			for _, user := range []string{"alice", "bob"} {
+				session := sessions.NewSession(s, "test")
+				session.Options = s.sessionOptions(session.Name())
+				session.Values["user"] = user
+				saveSession(t, s, session)
+				ids = append(ids, session.ID)
+			}
+			if err := s.WarmCache(ctx, append(ids, tt.extra...)); err != nil {
+				t.Fatal(err)
+			}
+			for _, id := range ids {
+				if _, err := cache.Get(ctx, id); err != nil {
+					t.Fatalf("session %s not cached: %v", id, err)
+				}
+			}
+			for _, id := range tt.extra {
+				if _, err := cache.Get(ctx, id); !errors.Is(err, ErrCacheMiss) {
+					t.Fatalf("cache Get(%q) = %v, want ErrCacheMiss", id, err)
+				}
+			}
+		})
+	}
+}
+
+func TestWarmCacheWithoutCache(t *testing.T) {
+	// The unit store fails any query: the call must not reach it.
+	s := newUnitStore(t)
+	if err := s.WarmCache(context.Background(), []string{primitive.NewObjectID().Hex()}); err != nil {
+		t.Fatalf("WarmCache() = %v, want nil", err)
+	}
+}
@@ -0,0 +1,67 @@
+package mongostore
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+)
+
+// CausalContext returns a context carrying a causally consistent MongoDB
+// session when the store was configured with WithCausalConsistency(true),
+// along with the function ending that session. Store operations run with the
+// returned context, or a context derived from it, read their own writes, even
+// from secondaries: a session loaded after being saved is never stale.
+//
+// The MongoDB session must be threaded through contexts: typically, a
+// middleware calls CausalContext and serves the request with
+// r.WithContext(ctx), so that both Get and Save use it. Without the option,
+// CausalContext returns ctx unchanged.
+func (s *MongoStore) CausalContext(ctx context.Context) (context.Context, func(), error) {
+	if !s.causalConsistency || mongo.SessionFromContext(ctx) != nil {
+		return ctx, func() {}, nil
+	}
+	if s.collection == nil {
+		return ctx, func() {}, ErrNoCollection
+	}
+	sess, err := s.collection.Database().Client().StartSession(options.Session().SetCausalConsistency(true))
+	if err != nil {
+		return ctx, func() {}, err
+	}
+	return mongo.NewSessionContext(ctx, sess), func() { sess.EndSession(context.Background()) }, nil
+}
+
+// WithRequestSession returns a context carrying a causally consistent MongoDB
+// session with the read concern set with WithRequestReadConcern (majority by
+// default), along with the function ending that session, so that loads run
+// with the returned context, or a context derived from it, see monotonic
+// reads: a session loaded twice during a request is never older the second
+// time, even if the loads are served by different replica set members. A
+// middleware typically serves the request with r.WithContext(ctx).
+//
+// Unlike CausalContext, which only starts a session with
+// WithCausalConsistency(true), for saves to be read back, WithRequestSession
+// always starts one, and is concerned with successive loads. Neither pins
+// reads to a server: the driver still selects one per operation, and delays
+// reads until the selected member caught up. Sessions served from the cache
+// (see WithSharedCache) are not read from MongoDB at all.
+//
+// If ctx already carries a MongoDB session, it is returned unchanged. If no
+// session can be started, the error is logged and ctx is returned unchanged.
+func (s *MongoStore) WithRequestSession(ctx context.Context) (context.Context, func()) {
+	if s.collection == nil || mongo.SessionFromContext(ctx) != nil {
+		return ctx, func() {}
+	}
+	rc := s.requestConcern
+	if rc == nil {
+		rc = readconcern.Majority()
+	}
+	opts := options.Session().SetCausalConsistency(true).SetDefaultReadConcern(rc)
+	sess, err := s.collection.Database().Client().StartSession(opts)
+	if err != nil {
+		s.log(ctx).Warnf("mongostore: could not start request session: %v", err)
+		return ctx, func() {}
+	}
+	return mongo.NewSessionContext(ctx, sess), func() { sess.EndSession(context.Background()) }
+}
@@ -0,0 +1,147 @@
+package mongostore
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// TestCausalConsistency reads sessions from secondaries right after saving
+// them, and is skipped unless the deployment named by testURIEnv is a
+// replica set.
+func TestCausalConsistency(t *testing.T) {
+	c := newTestCollection(t)
+	skipUnlessReplicaSet(t, c)
+	secondary, err := c.Clone(options.Collection().SetReadPreference(readpref.SecondaryPreferred()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewMongoStoreWithOptions(secondary, nil, testKeyPairs, WithCausalConsistency(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		saves int
+	}{
+		{"new session", 1},
+		{"updated session", 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, end, err := s.CausalContext(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer end()
+			r := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+			session := sessions.NewSession(s, "test")
+			session.Options = s.sessionOptions(session.Name())
+			var cookie string
+			for i := 0; i < tt.saves; i++ {
+				session.Values["count"] = i
+				w := httptest.NewRecorder()
+				if err := s.Save(r, w, session); err != nil {
+					t.Fatal(err)
+				}
+				if value := cookieValue(w, "test"); value != "" {
+					cookie = value
+				}
+				loaded, err := s.DecodeSessionCookie(ctx, "test", cookie)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if loaded.IsNew || loaded.Values["count"] != i {
+					t.Fatalf("save %d read back as %v (new: %v)", i, loaded.Values["count"], loaded.IsNew)
+				}
+			}
+		})
+	}
+}
+
+// TestRequestSession loads a session twice in a request session, and is
+// skipped unless the deployment named by testURIEnv is a replica set.
+func TestRequestSession(t *testing.T) {
+	finds := newCommandRecorder("find")
+	c := newTestCollection(t, options.Client().SetMonitor(finds.monitor()))
+	skipUnlessReplicaSet(t, c)
+	tests := []struct {
+		name      string
+		opts      []Option
+		wantLevel string
+	}{
+		{"default read concern", nil, "majority"},
+		{"local read concern", []Option{WithRequestReadConcern(readconcern.Local())}, "local"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewMongoStoreWithOptions(c, nil, testKeyPairs, tt.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			session := sessions.NewSession(s, "test")
+			session.Options = s.sessionOptions(session.Name())
+			session.Values["user"] = "alice"
+			value := saveSession(t, s, session)
+
+			ctx, end := s.WithRequestSession(context.Background())
+			defer end()
+			if mongo.SessionFromContext(ctx) == nil {
+				t.Fatal("WithRequestSession() returned a context without MongoDB session")
+			}
+			if again, _ := s.WithRequestSession(ctx); again != ctx {
+				t.Fatal("WithRequestSession() started a second session")
+			}
+			finds.reset()
+			for i := 0; i < 2; i++ {
+				loaded, err := s.DecodeSessionCookie(ctx, "test", value)
+				if err != nil || loaded.IsNew || loaded.Values["user"] != "alice" {
+					t.Fatalf("load %d: %v (new: %v), %v", i+1, loaded.Values, loaded.IsNew, err)
+				}
+			}
+			cmds := finds.commands()
+			if len(cmds) != 2 {
+				t.Fatalf("%d find commands, want 2", len(cmds))
+			}
+			lsid := cmds[0].Lookup("lsid")
+			for i, cmd := range cmds {
+				if !cmd.Lookup("lsid").Equal(lsid) {
+					t.Fatalf("load %d ran in session %v, want %v", i+1, cmd.Lookup("lsid"), lsid)
+				}
+				if level, _ := cmd.Lookup("readConcern", "level").StringValueOK(); level != tt.wantLevel {
+					t.Fatalf("load %d read concern %q, want %q", i+1, level, tt.wantLevel)
+				}
+			}
+			if _, err := cmds[1].LookupErr("readConcern", "afterClusterTime"); err != nil {
+				t.Fatal("second load does not wait for the cluster time of the first")
+			}
+		})
+	}
+}
+
+func TestRequestSessionUnchanged(t *testing.T) {
+	tests := []struct {
+		name  string
+		store func(t *testing.T) *MongoStore
+	}{
+		{"no collection", func(t *testing.T) *MongoStore { return &MongoStore{} }},
+		{"session cannot be started", func(t *testing.T) *MongoStore { return newUnitStore(t) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			got, end := tt.store(t).WithRequestSession(ctx)
+			defer end()
+			if got != ctx {
+				t.Fatal("WithRequestSession() changed the context")
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package mongostore
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ChunkIndexName is the name of the index on the chunks of a session document
+// created by EnsureIndexes with WithChunking.
+const ChunkIndexName = "chunkOf_1_gen_1_n_1"
+
+// chunk is the model of a document holding part of the data of a chunked
+// session (see WithChunking). Each save writes a new generation Gen of
+// chunks, numbered from 0 by N.
+type chunk struct {
+	ID        primitive.ObjectID `bson:"_id"`
+	ChunkOf   interface{}        `bson:"chunkOf"`
+	Gen       primitive.ObjectID `bson:"gen"`
+	N         int                `bson:"n"`
+	Data      string             `bson:"data"`
+	ExpiresAt interface{}        `bson:"expiresAt,omitempty"`
+}
+
+// writeChunks stores data as the generation gen of chunks of at most
+// s.chunkSize bytes belonging to the session document parent. The chunks of
+// other generations are left untouched, so that the document can be loaded
+// from its current chunks until it references gen. Chunks share the expiry
+// date of their parent, so that the TTL index deletes them along with it.
+func (s *MongoStore) writeChunks(ctx context.Context, parent interface{}, gen primitive.ObjectID, data string, expiresAt time.Time) error {
+	var docs []interface{}
+	for start := 0; start < len(data); start += s.chunkSize {
+		end := start + s.chunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		c := chunk{
+			ID:      primitive.NewObjectID(),
+			ChunkOf: parent,
+			Gen:     gen,
+			N:       len(docs),
+			Data:    data[start:end],
+		}
+		if !expiresAt.IsZero() {
+			c.ExpiresAt = s.timestamp(expiresAt)
+		}
+		docs = append(docs, c)
+	}
+	_, err := s.collection.InsertMany(ctx, docs)
+	return err
+}
+
+// deleteChunks deletes the chunks of the session document parent.
+func (s *MongoStore) deleteChunks(ctx context.Context, parent interface{}) error {
+	_, err := s.collection.DeleteMany(ctx, bson.M{"chunkOf": parent})
+	return err
+}
+
+// deleteChunkGen deletes the generation gen of chunks of the session
+// document parent.
+func (s *MongoStore) deleteChunkGen(ctx context.Context, parent interface{}, gen primitive.ObjectID) error {
+	_, err := s.collection.DeleteMany(ctx, bson.M{"chunkOf": parent, "gen": gen})
+	return err
+}
+
+// deleteStaleChunks deletes the chunks of the session document parent but the
+// generation gen it references, i.e. all of them if gen is zero.
+func (s *MongoStore) deleteStaleChunks(ctx context.Context, parent interface{}, gen primitive.ObjectID) error {
+	_, err := s.collection.DeleteMany(ctx, bson.M{"chunkOf": parent, "gen": bson.M{"$ne": gen}})
+	return err
+}
+
+// assembleChunks sets the data of the chunked session document doc from its
+// chunks. doc keeps its chunk count, so that the document, possibly cached,
+// is still known to be chunked when loaded.
+func (s *MongoStore) assembleChunks(ctx context.Context, doc *Session) error {
+	if doc.Chunks == 0 {
+		return nil
+	}
+	parent := s.documentID(doc)
+	opts := options.Find().SetSort(bson.M{"n": 1})
+	cur, err := s.collection.Find(ctx, bson.M{"chunkOf": parent, "gen": doc.ChunkGen}, opts)
+	if err != nil {
+		return err
+	}
+	var chunks []chunk
+	if err := cur.All(ctx, &chunks); err != nil {
+		return err
+	}
+	var data strings.Builder
+	for i, c := range chunks {
+		if c.N != i {
+			return fmt.Errorf("mongostore: chunk %d of session %s is missing", i, s.sessionID(parent))
+		}
+		data.WriteString(c.Data)
+	}
+	if len(chunks) != doc.Chunks {
+		return fmt.Errorf("mongostore: session %s has %d chunks, want %d", s.sessionID(parent), len(chunks), doc.Chunks)
+	}
+	doc.Data = data.String()
+	return nil
+}
@@ -0,0 +1,252 @@
+package mongostore
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestChunkResave(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name          string
+		first, second int
+		reload        bool
+	}{
+		{"fewer chunks", 2000, 500, true},
+		{"fewer chunks without loading", 2000, 500, false},
+		{"more chunks", 500, 2000, true},
+		{"no longer chunked", 2000, 10, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore(t, WithChunking(256))
+			if err := s.EnsureIndexes(ctx); err != nil {
+				t.Fatal(err)
+			}
+			session := sessions.NewSession(s, "test")
+			session.Options = s.sessionOptions(session.Name())
+			session.Values["blob"] = strings.Repeat("a", tt.first)
+			value := saveSession(t, s, session)
+
+			next := sessions.NewSession(s, "test")
+			next.Options = s.sessionOptions(next.Name())
+			next.ID = session.ID
+			if tt.reload {
+				next = loadSession(t, s, "test", value)
+			}
+			next.Values["blob"] = strings.Repeat("b", tt.second)
+			saveSession(t, s, next)
+
+			objID, err := s.docID(session.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var doc Session
+			if err := s.decodeResult(s.collection.FindOne(ctx, bson.M{"_id": objID}), &doc); err != nil {
+				t.Fatal(err)
+			}
+			n, err := s.collection.CountDocuments(ctx, bson.M{"chunkOf": objID})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != int64(doc.Chunks) {
+				t.Fatalf("%d chunk documents for %d chunks", n, doc.Chunks)
+			}
+			loaded := loadSession(t, s, "test", value)
+			if loaded.Values["blob"] != strings.Repeat("b", tt.second) {
+				t.Fatalf("loaded blob is not the one of the second save")
+			}
+		})
+	}
+}
+
+func TestChunkGenerations(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name    string
+		op      func(s *MongoStore, session *sessions.Session) error
+		wantErr error
+	}{
+		{"unreferenced generation", func(s *MongoStore, session *sessions.Session) error {
+			objID, err := s.docID(session.ID)
+			if err != nil {
+				return err
+			}
+			return s.writeChunks(ctx, objID, primitive.NewObjectID(), strings.Repeat("x", 2000), time.Time{})
+		}, nil},
+		{"failed conditional save", func(s *MongoStore, session *sessions.Session) error {
+			return s.SaveIfVersion(ctx, session, s.Version(session)+1)
+		}, ErrVersionMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore(t, WithChunking(256))
+			if err := s.EnsureIndexes(ctx); err != nil {
+				t.Fatal(err)
+			}
+			session := sessions.NewSession(s, "test")
+			session.Options = s.sessionOptions(session.Name())
+			session.Values["blob"] = strings.Repeat("a", 2000)
+			value := saveSession(t, s, session)
+
+			loaded := loadSession(t, s, "test", value)
+			loaded.Values["blob"] = strings.Repeat("b", 3000)
+			if err := tt.op(s, loaded); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if reloaded := loadSession(t, s, "test", value); reloaded.IsNew || reloaded.Values["blob"] != session.Values["blob"] {
+				t.Fatalf("stored session (new: %v) does not hold the first blob", reloaded.IsNew)
+			}
+		})
+	}
+}
+
+func TestChunkedTouch(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name  string
+		cache bool
+	}{
+		{"from MongoDB", false},
+		{"from the shared cache", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := []Option{WithChunking(256)}
+			if tt.cache {
+				opts = append(opts, WithSharedCache(NewMemoryCache(), time.Minute))
+			}
+			s := newTestStore(t, opts...)
+			session := sessions.NewSession(s, "test")
+			session.Options = s.sessionOptions(session.Name())
+			session.Values["blob"] = strings.Repeat("a", 2000)
+			value := saveSession(t, s, session)
+			loaded := loadSession(t, s, "test", value)
+			if tt.cache {
+				// Served from the cache filled by the first load.
+				loaded = loadSession(t, s, "test", value)
+			}
+			time.Sleep(time.Second)
+			saveSession(t, s, loaded)
+
+			objID, err := s.docID(session.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var doc Session
+			if err := s.decodeResult(s.collection.FindOne(ctx, bson.M{"_id": objID}), &doc); err != nil {
+				t.Fatal(err)
+			}
+			cur, err := s.collection.Find(ctx, bson.M{"chunkOf": objID})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var chunks []chunk
+			if err := cur.All(ctx, &chunks); err != nil {
+				t.Fatal(err)
+			}
+			if len(chunks) != doc.Chunks {
+				t.Fatalf("%d chunk documents for %d chunks", len(chunks), doc.Chunks)
+			}
+			for _, c := range chunks {
+				if expiresAt, ok := c.ExpiresAt.(primitive.DateTime); !ok || !expiresAt.Time().Equal(doc.ExpiresAt) {
+					t.Fatalf("chunk %d expires at %v, want %v", c.N, c.ExpiresAt, doc.ExpiresAt)
+				}
+			}
+		})
+	}
+}
+
+func TestChunkedState(t *testing.T) {
+	tests := []struct {
+		name      string
+		chunks    int
+		unchanged bool
+	}{
+		{"not chunked", 0, true},
+		{"chunked", 2, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newUnitStore(t, WithChunking(16))
+			session := sessions.NewSession(s, "test")
+			session.Values["user"] = "alice"
+			data, _, err := s.encodeData(session, session.Values)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Assembled documents, e.g. cached ones, keep their chunk count.
+			doc := &Session{ModifiedAt: time.Now(), DataModifiedAt: time.Now(), Data: data, Chunks: tt.chunks, Serializer: serializerName(s.serializer())}
+			if err := s.loadDocument(context.Background(), session, doc); err != nil {
+				t.Fatal(err)
+			}
+			if got := s.valuesUnchanged(session, stateOf(session), session.Values, time.Now()); got != tt.unchanged {
+				t.Fatalf("valuesUnchanged() = %v, want %v", got, tt.unchanged)
+			}
+		})
+	}
+}
+
+func TestChunking(t *testing.T) {
+	ctx := context.Background()
+	expire := func(s *MongoStore, session *sessions.Session) error {
+		session.Options.MaxAge = -1
+		return s.Save(httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRecorder(), session)
+	}
+	deleteByIDs := func(s *MongoStore, session *sessions.Session) error {
+		_, err := s.DeleteByIDs(ctx, []string{session.ID})
+		return err
+	}
+	tests := []struct {
+		name        string
+		size        int
+		wantChunked bool
+		erase       func(s *MongoStore, session *sessions.Session) error
+	}{
+		{"below the chunk size", 10, false, expire},
+		{"several chunks", 2000, true, expire},
+		{"several chunks deleted by ID", 2000, true, deleteByIDs},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore(t, WithChunking(256))
+			if err := s.EnsureIndexes(ctx); err != nil {
+				t.Fatal(err)
+			}
+			session := sessions.NewSession(s, "test")
+			session.Options = s.sessionOptions(session.Name())
+			session.Values["blob"] = strings.Repeat("a", tt.size)
+			value := saveSession(t, s, session)
+			objID, err := s.docID(session.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			n, err := s.collection.CountDocuments(ctx, bson.M{"chunkOf": objID})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if chunked := n > 1; chunked != tt.wantChunked {
+				t.Fatalf("%d chunk documents, want chunked: %v", n, tt.wantChunked)
+			}
+			loaded := loadSession(t, s, "test", value)
+			if loaded.IsNew || loaded.Values["blob"] != session.Values["blob"] {
+				t.Fatalf("loaded session (new: %v) does not hold the saved blob", loaded.IsNew)
+			}
+			if err := tt.erase(s, loaded); err != nil {
+				t.Fatal(err)
+			}
+			if n, err := s.collection.CountDocuments(ctx, bson.M{}); err != nil || n != 0 {
+				t.Fatalf("%d documents left after deletion, %v", n, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package mongostore
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strconv"
+
+	"github.com/gorilla/securecookie"
+)
+
+// insecureCodec is a securecookie.Codec that serializes values without
+// signing nor encrypting them. It is installed by WithInsecureNoKeys.
+type insecureCodec struct{}
+
+func (insecureCodec) Encode(name string, value interface{}) (string, error) {
+	b, err := securecookie.GobEncoder{}.Serialize(value)
+	if err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(b), nil
+}
+
+func (insecureCodec) Decode(name, value string, dst interface{}) error {
+	b, err := base64.URLEncoding.DecodeString(value)
+	if err != nil {
+		return err
+	}
+	return securecookie.GobEncoder{}.Deserialize(b, dst)
+}
+
+// expiredTimestampError is the securecookie.Error returned when a value was
+// signed longer than the codec maximum age ago, according to the time
+// function set with WithCodecTimeFunc. It reads like the error of
+// securecookie, so that IsExpired reports it.
+type expiredTimestampError struct{}
+
+var _ securecookie.Error = expiredTimestampError{}
+
+func (expiredTimestampError) Error() string    { return expiredTimestampMessage }
+func (expiredTimestampError) IsUsage() bool    { return false }
+func (expiredTimestampError) IsDecode() bool   { return true }
+func (expiredTimestampError) IsInternal() bool { return false }
+func (expiredTimestampError) Cause() error     { return nil }
+
+// codecAge returns the maximum age, in seconds, of the timestamps signed
+// by the codecs: the one set with WithCodecMaxAge, or the store MaxAge.
+func (s *MongoStore) codecAge() int {
+	if s.codecMaxAge > 0 {
+		return s.codecMaxAge
+	}
+	return s.Options.MaxAge
+}
+
+// applyCodecMaxAge sets the maximum age of the securecookie codecs. With a
+// time function set with WithCodecTimeFunc, the codecs do not check it:
+// decodeMulti does.
+func (s *MongoStore) applyCodecMaxAge() {
+	age := s.codecAge()
+	if s.codecNow != nil {
+		age = 0
+	}
+	for _, codec := range s.decodeCodecs() {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			sc.MaxAge(age)
+		}
+	}
+}
+
+// decodeMulti decodes value with the codecs, like securecookie.DecodeMulti,
+// checking the signed timestamp against the time function set with
+// WithCodecTimeFunc, if any.
+func (s *MongoStore) decodeMulti(name, value string, dst interface{}) error {
+	err := securecookie.DecodeMulti(name, value, dst, s.decodeCodecs()...)
+	if err != nil || s.codecNow == nil {
+		return err
+	}
+	age := int64(s.codecAge())
+	if age <= 0 {
+		return nil
+	}
+	// Authenticated values are encoded as base64("date|value|mac"). Values
+	// decoded by other codecs have no timestamp to check.
+	b, err := base64.URLEncoding.DecodeString(value)
+	if err != nil {
+		return nil
+	}
+	parts := bytes.SplitN(b, []byte("|"), 3)
+	if len(parts) != 3 {
+		return nil
+	}
+	signed, err := strconv.ParseInt(string(parts[0]), 10, 64)
+	if err != nil {
+		return nil
+	}
+	if signed < s.codecNow()-age {
+		return expiredTimestampError{}
+	}
+	return nil
+}
@@ -0,0 +1,228 @@
+package mongostore
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/securecookie"
+)
+
+func TestCodecTimeFunc(t *testing.T) {
+	recoveryKey := []byte("fedcba9876543210fedcba9876543210")
+	tests := []struct {
+		name        string
+		recovery    bool
+		advance     time.Duration
+		wantExpired bool
+	}{
+		{"just signed", false, 0, false},
+		{"within the maximum age", false, 50 * time.Second, false},
+		{"past the maximum age", false, 61 * time.Second, true},
+		{"recovery codec within the maximum age", true, 50 * time.Second, false},
+		{"recovery codec past the maximum age", true, 61 * time.Second, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var now int64
+			s := newUnitStore(t,
+				WithCodecMaxAge(60),
+				WithCodecTimeFunc(func() int64 { return now }),
+				WithRecoveryCodecs(recoveryKey))
+			codecs := s.Codecs
+			if tt.recovery {
+				codecs = securecookie.CodecsFromPairs(recoveryKey)
+			}
+			encoded, err := securecookie.EncodeMulti("test", "value", codecs...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			now = time.Now().Add(tt.advance).Unix()
+			var decoded string
+			err = s.decodeMulti("test", encoded, &decoded)
+			if got := IsExpired(err); got != tt.wantExpired {
+				t.Fatalf("IsExpired(%v) = %v, want %v", err, got, tt.wantExpired)
+			}
+			if !tt.wantExpired && (err != nil || decoded != "value") {
+				t.Fatalf("decodeMulti() = %q, %v", decoded, err)
+			}
+		})
+	}
+}
+
+func TestCodecTimeFuncMaxAge(t *testing.T) {
+	var now int64
+	s := newUnitStore(t, WithCodecTimeFunc(func() int64 { return now }))
+	s.MaxAge(60)
+	encoded, err := securecookie.EncodeMulti("test", "value", s.Codecs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name        string
+		advance     time.Duration
+		wantExpired bool
+	}{
+		{"within the store MaxAge", 30 * time.Second, false},
+		{"past the store MaxAge", 2 * time.Minute, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now = time.Now().Add(tt.advance).Unix()
+			var decoded string
+			if got := IsExpired(s.decodeMulti("test", encoded, &decoded)); got != tt.wantExpired {
+				t.Fatalf("IsExpired() = %v, want %v", got, tt.wantExpired)
+			}
+		})
+	}
+}
+
+func TestNoKeyPairs(t *testing.T) {
+	tests := []struct {
+		name         string
+		keyPairs     [][]byte
+		opts         []Option
+		wantErr      error
+		wantInsecure bool
+	}{
+		{"no key pair", nil, nil, ErrNoKeyPairs, false},
+		{"no key pair, insecure", nil, []Option{WithInsecureNoKeys()}, nil, true},
+		{"key pairs", testKeyPairs, nil, nil, false},
+		{"key pairs, insecure", testKeyPairs, []Option{WithInsecureNoKeys()}, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewMongoStoreWithOptions(newUnitStore(t).collection, nil, tt.keyPairs, tt.opts...)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("NewMongoStoreWithOptions() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			_, insecure := s.Codecs[0].(insecureCodec)
+			if insecure != tt.wantInsecure {
+				t.Fatalf("codec %T, want insecure: %v", s.Codecs[0], tt.wantInsecure)
+			}
+			encoded, err := securecookie.EncodeMulti("test", "value", s.Codecs...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var decoded string
+			if err := s.decodeMulti("test", encoded, &decoded); err != nil || decoded != "value" {
+				t.Fatalf("decodeMulti() = %q, %v", decoded, err)
+			}
+		})
+	}
+}
+
+func TestCodecMaxAge(t *testing.T) {
+	tests := []struct {
+		name        string
+		codecMaxAge int
+		advance     time.Duration
+		wantExpired bool
+	}{
+		{"codec max age from MaxAge", 0, 2 * time.Minute, true},
+		{"longer codec max age", 3600, 2 * time.Minute, false},
+		{"past the codec max age", 3600, 2 * time.Hour, true},
+		{"shorter codec max age", 30, 45 * time.Second, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var now int64
+			opts := []Option{WithCodecTimeFunc(func() int64 { return now })}
+			if tt.codecMaxAge > 0 {
+				opts = append(opts, WithCodecMaxAge(tt.codecMaxAge))
+			}
+			s := newUnitStore(t, opts...)
+			s.MaxAge(60)
+			if got := s.sessionOptions("test").MaxAge; got != 60 {
+				t.Fatalf("cookie MaxAge = %d, want 60", got)
+			}
+			encoded, err := securecookie.EncodeMulti("test", "value", s.Codecs...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			now = time.Now().Add(tt.advance).Unix()
+			var decoded string
+			err = s.decodeMulti("test", encoded, &decoded)
+			if got := IsExpired(err); got != tt.wantExpired {
+				t.Fatalf("IsExpired(%v) = %v, want %v", err, got, tt.wantExpired)
+			}
+		})
+	}
+}
+
+func TestRecoveryCodecs(t *testing.T) {
+	recoveryKey := []byte("fedcba9876543210fedcba9876543210")
+	unknownKey := []byte("00001111222233334444555566667777")
+	tests := []struct {
+		name         string
+		opts         []Option
+		signingKey   []byte
+		serializer   securecookie.Serializer
+		wantTampered bool
+	}{
+		{"store key", []Option{WithRecoveryCodecs(recoveryKey)}, testKeyPairs[0], nil, false},
+		{"recovery key", []Option{WithRecoveryCodecs(recoveryKey)}, recoveryKey, nil, false},
+		{"recovery key with a JSON serializer", []Option{WithRecoveryCodecs(recoveryKey), WithSerializer(JSONSerializer{})}, recoveryKey, JSONSerializer{}, false},
+		{"recovery key without recovery codecs", nil, recoveryKey, nil, true},
+		{"unknown key", []Option{WithRecoveryCodecs(recoveryKey)}, unknownKey, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newUnitStore(t, tt.opts...)
+			if len(s.Codecs) != len(testKeyPairs) {
+				t.Fatalf("%d store codecs, want %d: recovery codecs must not encode", len(s.Codecs), len(testKeyPairs))
+			}
+			codec := securecookie.New(tt.signingKey, nil)
+			if tt.serializer != nil {
+				codec.SetSerializer(tt.serializer)
+			}
+			encoded, err := securecookie.EncodeMulti("test", "value", codec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var decoded string
+			err = s.decodeMulti("test", encoded, &decoded)
+			if got := IsTampered(err); got != tt.wantTampered {
+				t.Fatalf("IsTampered(%v) = %v, want %v", err, got, tt.wantTampered)
+			}
+			if !tt.wantTampered && decoded != "value" {
+				t.Fatalf("decoded %q, want %q", decoded, "value")
+			}
+		})
+	}
+}
+
+func TestNewMongoStoreWithoutKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		keyPairs [][]byte
+		wantErr  error
+	}{
+		{"no key pair", nil, ErrNoKeyPairs},
+		{"key pairs", testKeyPairs, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The client is not connected: saves of stores with keys fail
+			// when writing the document.
+			s := NewMongoStore(newUnitStore(t).collection, nil, tt.keyPairs...)
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			session, err := s.New(r, "test")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("New() error = %v, want %v", err, tt.wantErr)
+			}
+			if session == nil || !session.IsNew {
+				t.Fatalf("New() returned %v, want a new session", session)
+			}
+			err = s.Save(r, httptest.NewRecorder(), session)
+			if got := errors.Is(err, ErrNoKeyPairs); got != (tt.wantErr != nil) {
+				t.Fatalf("Save() error = %v, want ErrNoKeyPairs: %v", err, tt.wantErr != nil)
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package mongostore
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// Stats describes the current activity of a store.
+type Stats struct {
+	// InFlight is the number of session loads, saves and erases in
+	// progress.
+	InFlight int
+	// Limit is the maximum number of concurrent session operations set
+	// with WithConcurrencyLimit, or 0 if unlimited.
+	Limit int
+}
+
+// Stats returns the current activity of the store, e.g. to export it as
+// metrics.
+func (s *MongoStore) Stats() Stats {
+	return Stats{InFlight: int(atomic.LoadInt32(&s.inFlight)), Limit: cap(s.slots)}
+}
+
+// acquire waits until a session operation can be started, or until ctx is
+// done, in which case it returns ctx.Err(). On success, the returned function
+// must be called when the operation is done.
+func (s *MongoStore) acquire(ctx context.Context) (func(), error) {
+	if s.slots != nil {
+		select {
+		case s.slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	atomic.AddInt32(&s.inFlight, 1)
+	return func() {
+		atomic.AddInt32(&s.inFlight, -1)
+		if s.slots != nil {
+			<-s.slots
+		}
+	}, nil
+}
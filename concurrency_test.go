@@ -0,0 +1,107 @@
+package mongostore
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+func TestConcurrencyLimit(t *testing.T) {
+	const workers = 20
+	tests := []struct {
+		name  string
+		limit int
+	}{
+		{"no limit", 0},
+		{"limit of one", 1},
+		{"limit of three", 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newUnitStore(t, WithConcurrencyLimit(tt.limit))
+			if got := s.Stats().Limit; got != tt.limit {
+				t.Fatalf("Stats().Limit = %d, want %d", got, tt.limit)
+			}
+			var mu sync.Mutex
+			var running, peak int
+			var wg sync.WaitGroup
+			for i := 0; i < workers; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					release, err := s.acquire(context.Background())
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					mu.Lock()
+					running++
+					if running > peak {
+						peak = running
+					}
+					if inFlight := s.Stats().InFlight; tt.limit > 0 && inFlight > tt.limit {
+						t.Errorf("Stats().InFlight = %d, want at most %d", inFlight, tt.limit)
+					}
+					mu.Unlock()
+					time.Sleep(time.Millisecond)
+					mu.Lock()
+					running--
+					mu.Unlock()
+					release()
+				}()
+			}
+			wg.Wait()
+			if tt.limit > 0 && peak > tt.limit {
+				t.Fatalf("%d operations ran concurrently, want at most %d", peak, tt.limit)
+			}
+			if inFlight := s.Stats().InFlight; inFlight != 0 {
+				t.Fatalf("Stats().InFlight = %d after the operations, want 0", inFlight)
+			}
+		})
+	}
+}
+
+func TestConcurrencyLimitContext(t *testing.T) {
+	tests := []struct {
+		name string
+		op   func(ctx context.Context, s *MongoStore) error
+	}{
+		{"acquire", func(ctx context.Context, s *MongoStore) error {
+			_, err := s.acquire(ctx)
+			return err
+		}},
+		{"save", func(ctx context.Context, s *MongoStore) error {
+			session := sessions.NewSession(s, "test")
+			session.Options = s.sessionOptions(session.Name())
+			r := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+			return s.Save(r, httptest.NewRecorder(), session)
+		}},
+		{"erase", func(ctx context.Context, s *MongoStore) error {
+			return s.eraseID(ctx, "0123456789abcdef01234567")
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newUnitStore(t, WithConcurrencyLimit(1))
+			release, err := s.acquire(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer release()
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+			if err := tt.op(ctx, s); !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("%s at the limit = %v, want context.DeadlineExceeded", tt.name, err)
+			}
+			if inFlight := s.Stats().InFlight; inFlight != 1 {
+				t.Fatalf("Stats().InFlight = %d, want 1", inFlight)
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package mongostore
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gorilla/sessions"
+)
+
+// SessionContextKey is the context key under which NewWithContext stores the
+// session it resolves. The value is a *sessions.Session.
+type SessionContextKey struct{}
+
+// NewWithContext is like New, but also returns a shallow copy of r whose
+// context carries the session, so that handlers down a middleware chain not
+// using the gorilla registry can get it with FromContext instead of loading
+// it again. If the context of r already carries a session of this store with
+// the given name, it is returned along with r as is.
+//
+// On error, the returned request carries the new session returned by New.
+func (s *MongoStore) NewWithContext(r *http.Request, name string) (*http.Request, *sessions.Session, error) {
+	if session, ok := FromContext(r.Context()); ok && session.Store() == s && session.Name() == name {
+		return r, session, nil
+	}
+	session, err := s.New(r, name)
+	return r.WithContext(context.WithValue(r.Context(), SessionContextKey{}, session)), session, err
+}
+
+// FromContext returns the session stored in ctx by NewWithContext, if any.
+func FromContext(ctx context.Context) (*sessions.Session, bool) {
+	session, ok := ctx.Value(SessionContextKey{}).(*sessions.Session)
+	return session, ok
+}
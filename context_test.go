@@ -0,0 +1,57 @@
+package mongostore
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewWithContext(t *testing.T) {
+	s := newUnitStore(t)
+	other := newUnitStore(t)
+	tests := []struct {
+		name     string
+		carried  func(r *http.Request) *http.Request
+		wantSame bool
+	}{
+		{"bare context", func(r *http.Request) *http.Request { return r }, false},
+		{"session carried", func(r *http.Request) *http.Request {
+			r, _, _ = s.NewWithContext(r, "test")
+			return r
+		}, true},
+		{"session of another name carried", func(r *http.Request) *http.Request {
+			r, _, _ = s.NewWithContext(r, "other")
+			return r
+		}, false},
+		{"session of another store carried", func(r *http.Request) *http.Request {
+			r, _, _ = other.NewWithContext(r, "test")
+			return r
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := tt.carried(httptest.NewRequest(http.MethodGet, "/", nil))
+			carried, _ := FromContext(r.Context())
+			got, session, err := s.NewWithContext(r, "test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if session.Store() != s || session.Name() != "test" {
+				t.Fatalf("NewWithContext() = session %q of %v, want session %q of %v", session.Name(), session.Store(), "test", s)
+			}
+			if same := session == carried; same != tt.wantSame {
+				t.Fatalf("NewWithContext() returned the carried session: %v, want %v", same, tt.wantSame)
+			}
+			if tt.wantSame && got != r {
+				t.Fatal("NewWithContext() returned a copy of a request already carrying the session")
+			}
+			if fromCtx, ok := FromContext(got.Context()); !ok || fromCtx != session {
+				t.Fatalf("FromContext() = %v, %v, want the returned session", fromCtx, ok)
+			}
+		})
+	}
+	if session, ok := FromContext(context.WithValue(context.Background(), SessionContextKey{}, "not a session")); ok || session != nil {
+		t.Fatalf("FromContext() = %v, %v, want no session", session, ok)
+	}
+}
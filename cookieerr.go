@@ -0,0 +1,64 @@
+package mongostore
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/gorilla/securecookie"
+)
+
+// Messages of securecookie errors that have no exported value.
+const (
+	expiredTimestampMessage = "securecookie: expired timestamp"
+	base64FailureMessage    = "securecookie: base64 decode failed"
+	valueTooLongMessage     = "securecookie: the value is too long"
+)
+
+// IsExpired reports whether err, as returned by New, Get or
+// DecodeSessionCookie, was caused by an authentic session cookie or session
+// data older than the maximum age of the codecs. Such sessions can usually be
+// logged out quietly.
+func IsExpired(err error) bool {
+	for _, e := range cookieErrors(err) {
+		if e.Error() == expiredTimestampMessage {
+			return true
+		}
+	}
+	return false
+}
+
+// IsTampered reports whether err, as returned by New, Get or
+// DecodeSessionCookie, was caused by a session cookie or session data that
+// none of the codecs could authenticate: it was forged or corrupted, or
+// signed with keys the store does not have.
+func IsTampered(err error) bool {
+	errs := cookieErrors(err)
+	for _, e := range errs {
+		if !e.IsDecode() || !(e == securecookie.ErrMacInvalid ||
+			strings.HasPrefix(e.Error(), base64FailureMessage) ||
+			e.Error() == valueTooLongMessage) {
+			return false
+		}
+	}
+	return len(errs) > 0
+}
+
+// cookieErrors returns the securecookie errors err wraps, one per codec for an
+// error returned by securecookie.DecodeMulti.
+func cookieErrors(err error) []securecookie.Error {
+	var multi securecookie.MultiError
+	if errors.As(err, &multi) {
+		var errs []securecookie.Error
+		for _, e := range multi {
+			if scErr, ok := e.(securecookie.Error); ok {
+				errs = append(errs, scErr)
+			}
+		}
+		return errs
+	}
+	var scErr securecookie.Error
+	if errors.As(err, &scErr) {
+		return []securecookie.Error{scErr}
+	}
+	return nil
+}
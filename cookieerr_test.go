@@ -0,0 +1,59 @@
+package mongostore
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gorilla/securecookie"
+)
+
+func TestCookieErrors(t *testing.T) {
+	var now int64
+	s := newUnitStore(t, WithCodecMaxAge(60), WithCodecTimeFunc(func() int64 { return now }))
+	signed := func(codecs ...securecookie.Codec) string {
+		encoded, err := securecookie.EncodeMulti("test", "value", codecs...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return encoded
+	}
+	valid := signed(s.Codecs...)
+	forged := signed(securecookie.CodecsFromPairs([]byte("fedcba9876543210fedcba9876543210"))...)
+	tests := []struct {
+		name         string
+		value        string
+		advance      time.Duration
+		wantExpired  bool
+		wantTampered bool
+	}{
+		{"valid", valid, 0, false, false},
+		{"expired", valid, 2 * time.Minute, true, false},
+		{"signed with another key", forged, 0, false, true},
+		{"altered", valid[:len(valid)-4] + "AAAA", 0, false, true},
+		{"not base64", "!not base64!", 0, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now = time.Now().Add(tt.advance).Unix()
+			var decoded string
+			err := s.decodeMulti("test", tt.value, &decoded)
+			if got := IsExpired(err); got != tt.wantExpired {
+				t.Errorf("IsExpired(%v) = %v, want %v", err, got, tt.wantExpired)
+			}
+			if got := IsTampered(err); got != tt.wantTampered {
+				t.Errorf("IsTampered(%v) = %v, want %v", err, got, tt.wantTampered)
+			}
+			wrapped := fmt.Errorf("loading session: %w", err)
+			if IsExpired(wrapped) != tt.wantExpired || IsTampered(wrapped) != tt.wantTampered {
+				t.Errorf("wrapped %v: IsExpired() = %v, IsTampered() = %v", err, IsExpired(wrapped), IsTampered(wrapped))
+			}
+		})
+	}
+	for _, err := range []error{nil, errors.New("other"), ErrNoCollection} {
+		if IsExpired(err) || IsTampered(err) {
+			t.Errorf("IsExpired(%v) = %v, IsTampered(%v) = %v, want false", err, IsExpired(err), err, IsTampered(err))
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package mongostore
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/klauspost/compress/zstd"
+)
+
+// DefaultCompressionThreshold is the default size, in bytes, of serialized
+// session values below which compression is skipped.
+const DefaultCompressionThreshold = 512
+
+// Compression is a compression algorithm of session values (see
+// WithBinaryData).
+type Compression byte
+
+const (
+	// NoCompression leaves session values uncompressed.
+	NoCompression Compression = iota
+	// GzipCompression compresses session values with gzip.
+	GzipCompression
+	// ZstdCompression compresses session values with Zstandard.
+	ZstdCompression
+)
+
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+	zstdErr     error
+)
+
+// zstdCodec returns the shared Zstandard encoder and decoder.
+func zstdCodec() (*zstd.Encoder, *zstd.Decoder, error) {
+	zstdOnce.Do(func() {
+		if zstdEncoder, zstdErr = zstd.NewWriter(nil); zstdErr == nil {
+			zstdDecoder, zstdErr = zstd.NewReader(nil)
+		}
+	})
+	return zstdEncoder, zstdDecoder, zstdErr
+}
+
+// compress compresses b with the algorithm c.
+func compress(c Compression, b []byte) ([]byte, error) {
+	switch c {
+	case GzipCompression:
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(b); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case ZstdCompression:
+		enc, _, err := zstdCodec()
+		if err != nil {
+			return nil, err
+		}
+		return enc.EncodeAll(b, nil), nil
+	}
+	return b, nil
+}
+
+// decompress decompresses b, compressed with the algorithm c.
+func decompress(c Compression, b []byte) ([]byte, error) {
+	switch c {
+	case GzipCompression:
+		zr, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.ReadAll(zr)
+	case ZstdCompression:
+		_, dec, err := zstdCodec()
+		if err != nil {
+			return nil, err
+		}
+		return dec.DecodeAll(b, nil)
+	case NoCompression:
+		return b, nil
+	}
+	return nil, fmt.Errorf("mongostore: unknown compression %d", c)
+}
+
+// dataCompression returns the compression algorithm of session values.
+func (s *MongoStore) dataCompression() Compression {
+	switch {
+	case s.binaryData:
+		return s.binaryCompression
+	case s.compression:
+		return GzipCompression
+	}
+	return NoCompression
+}
+
+// encodeData encodes the values of session into the data stored in its
+// document, and returns the compression applied to the values: when
+// compression is enabled, values whose serialized form reaches the
+// compression threshold are serialized and compressed before being encoded by
+// the codecs.
+//
+// It returns an error wrapping ErrSessionTooLarge if the serialized, and
+// possibly compressed, values exceed the size set with WithMaxSessionSize.
+func (s *MongoStore) encodeData(session *sessions.Session, values map[interface{}]interface{}) (data string, c Compression, err error) {
+	c = s.dataCompression()
+	if c == NoCompression && s.maxSessionSize <= 0 {
+		data, err = securecookie.EncodeMulti(session.Name(), values, s.Codecs...)
+		return data, NoCompression, err
+	}
+	b, err := s.serializer().Serialize(values)
+	if err != nil {
+		return "", NoCompression, err
+	}
+	if c == NoCompression || len(b) < s.compressionThreshold {
+		if err := s.checkSessionSize(session, len(b)); err != nil {
+			return "", NoCompression, err
+		}
+		data, err = s.encodeSerialized(session.Name(), values, b)
+		return data, NoCompression, err
+	}
+	if b, err = compress(c, b); err != nil {
+		return "", NoCompression, err
+	}
+	if err := s.checkSessionSize(session, len(b)); err != nil {
+		return "", NoCompression, err
+	}
+	data, err = securecookie.EncodeMulti(session.Name(), b, s.Codecs...)
+	return data, c, err
+}
+
+// encodeSerialized encodes values, serialized as b by the store serializer,
+// with the codecs, like securecookie.EncodeMulti but without serializing them
+// again: securecookie codecs, which serialize values with the store
+// serializer (see installSerializer), encode b as is. Other codecs encode
+// values.
+func (s *MongoStore) encodeSerialized(name string, values map[interface{}]interface{}, b []byte) (string, error) {
+	if len(s.Codecs) == 0 {
+		return securecookie.EncodeMulti(name, values)
+	}
+	var errs securecookie.MultiError
+	for _, codec := range s.Codecs {
+		var encoded string
+		var err error
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			raw := *sc
+			encoded, err = raw.SetSerializer(securecookie.NopEncoder{}).Encode(name, b)
+		} else {
+			encoded, err = codec.Encode(name, values)
+		}
+		if err == nil {
+			return encoded, nil
+		}
+		errs = append(errs, err)
+	}
+	return "", errs
+}
+
+// checkSessionSize returns an error wrapping ErrSessionTooLarge if size
+// exceeds the size set with WithMaxSessionSize.
+func (s *MongoStore) checkSessionSize(session *sessions.Session, size int) error {
+	if s.maxSessionSize > 0 && size > s.maxSessionSize {
+		return fmt.Errorf("%w: session %s has %d bytes of serialized values, the maximum is %d", ErrSessionTooLarge, session.ID, size, s.maxSessionSize)
+	}
+	return nil
+}
+
+// decodeData decodes the data of the session document doc into the values of
+// session.
+func (s *MongoStore) decodeData(session *sessions.Session, doc *Session) error {
+	c := doc.DataCompression
+	if doc.Compressed {
+		c = GzipCompression
+	}
+	if c == NoCompression {
+		return s.decodeMulti(session.Name(), doc.Data, &session.Values)
+	}
+	var b []byte
+	if err := s.decodeMulti(session.Name(), doc.Data, &b); err != nil {
+		return err
+	}
+	b, err := decompress(c, b)
+	if err != nil {
+		return err
+	}
+	return s.serializer().Deserialize(b, &session.Values)
+}
+
+// serializer returns the serializer of session values.
+func (s *MongoStore) serializer() securecookie.Serializer {
+	if s.valueSerializer == nil {
+		return securecookie.GobEncoder{}
+	}
+	return s.valueSerializer
+}
@@ -0,0 +1,135 @@
+package mongostore
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// countingSerializer is a JSONSerializer counting the values it serializes.
+type countingSerializer struct {
+	JSONSerializer
+	n *int
+}
+
+func (c countingSerializer) Serialize(src interface{}) ([]byte, error) {
+	if _, ok := src.(map[interface{}]interface{}); ok {
+		*c.n++
+	}
+	return c.JSONSerializer.Serialize(src)
+}
+
+func TestSerializeOnce(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+		size int
+	}{
+		{"without size limit", nil, 100},
+		{"with a size limit", []Option{WithMaxSessionSize(3000)}, 100},
+		{"below the compression threshold", []Option{WithMaxSessionSize(3000), WithCompression(true)}, 100},
+		{"compressed", []Option{WithCompression(true)}, 2000},
+		{"without keys", []Option{WithInsecureNoKeys(), WithMaxSessionSize(3000)}, 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var n int
+			s := newUnitStore(t, append([]Option{WithSerializer(countingSerializer{n: &n})}, tt.opts...)...)
+			session := sessions.NewSession(s, "test")
+			session.Values["payload"] = strings.Repeat("a", tt.size)
+			data, c, err := s.encodeData(session, session.Values)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != 1 {
+				t.Fatalf("values serialized %d times, want once", n)
+			}
+			loaded := sessions.NewSession(s, "test")
+			if err := s.decodeData(loaded, &Session{Data: data, DataCompression: c, Compressed: c == GzipCompression}); err != nil {
+				t.Fatal(err)
+			}
+			if loaded.Values["payload"] != session.Values["payload"] {
+				t.Fatalf("decoded values %v, want %v", loaded.Values, session.Values)
+			}
+		})
+	}
+}
+
+func TestCompressionThreshold(t *testing.T) {
+	tests := []struct {
+		name           string
+		opts           []Option
+		size           int
+		wantCompressed bool
+	}{
+		{"without compression", nil, 2048, false},
+		{"below the default threshold", []Option{WithCompression(true)}, 16, false},
+		{"above the default threshold", []Option{WithCompression(true)}, 2048, true},
+		{"below a custom threshold", []Option{WithCompression(true), WithCompressionThreshold(8192)}, 2048, false},
+		{"above a custom threshold", []Option{WithCompression(true), WithCompressionThreshold(64)}, 128, true},
+		{"zero threshold", []Option{WithCompression(true), WithCompressionThreshold(0)}, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newUnitStore(t, tt.opts...)
+			session := sessions.NewSession(s, "test")
+			session.Values["payload"] = strings.Repeat("a", tt.size)
+			data, c, err := s.encodeData(session, session.Values)
+			if err != nil {
+				t.Fatal(err)
+			}
+			doc := &Session{Data: data, Compressed: c == GzipCompression}
+			if doc.Compressed != tt.wantCompressed {
+				t.Fatalf("compressed = %v, want %v", doc.Compressed, tt.wantCompressed)
+			}
+			// The stored form is read from the marker, whatever the
+			// configuration of the loading store.
+			for _, loader := range []*MongoStore{s, newUnitStore(t)} {
+				loaded := sessions.NewSession(loader, "test")
+				if err := loader.decodeData(loaded, doc); err != nil {
+					t.Fatal(err)
+				}
+				if loaded.Values["payload"] != session.Values["payload"] {
+					t.Fatalf("decoded a payload of %d bytes, want %d", len(loaded.Values["payload"].(string)), tt.size)
+				}
+			}
+		})
+	}
+}
+
+func TestMaxSessionSize(t *testing.T) {
+	tests := []struct {
+		name         string
+		opts         []Option
+		size         int
+		wantTooLarge bool
+	}{
+		{"no limit", nil, 4000, false},
+		{"within the limit", []Option{WithMaxSessionSize(3000)}, 100, false},
+		{"over the limit", []Option{WithMaxSessionSize(3000)}, 4000, true},
+		{"over the limit before compression", []Option{WithMaxSessionSize(3000), WithCompression(true)}, 10000, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Values are encoded before the document is written, so that the
+			// unit store is never reached by oversized sessions.
+			s := newUnitStore(t, tt.opts...)
+			session := sessions.NewSession(s, "test")
+			session.Options = s.sessionOptions(session.Name())
+			session.ID = primitive.NewObjectID().Hex()
+			session.Values["blob"] = strings.Repeat("a", tt.size)
+			err := s.Save(httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRecorder(), session)
+			if tooLarge := errors.Is(err, ErrSessionTooLarge); tooLarge != tt.wantTooLarge {
+				t.Fatalf("Save() = %v, want ErrSessionTooLarge: %v", err, tt.wantTooLarge)
+			}
+			if tt.wantTooLarge && !strings.Contains(err.Error(), session.ID) {
+				t.Fatalf("Save() = %v, want the error to name session %s", err, session.ID)
+			}
+		})
+	}
+}
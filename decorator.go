@@ -0,0 +1,82 @@
+package mongostore
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// reservedFields are the session document fields managed by the store, which
+// document decorators cannot set.
+var reservedFields = map[string]bool{
+	"_id":            true,
+	"name":           true,
+	"data":           true,
+	"compressed":     true,
+	"serializer":     true,
+	"chunks":         true,
+	"chunkGen":       true,
+	"chunkOf":        true,
+	"createdAt":      true,
+	"modifiedAt":     true,
+	"dataModifiedAt": true,
+	"expiresAt":      true,
+	"ipAddress":      true,
+	"ip":             true,
+	"userID":         true,
+	"lockedUntil":    true,
+	"lockToken":      true,
+	"version":        true,
+	"values":         true,
+}
+
+// isReservedField reports whether the document field key, possibly a dotted
+// path, is or belongs to a field managed by the store.
+func isReservedField(key string) bool {
+	if strings.HasPrefix(key, "$") {
+		return true
+	}
+	if i := strings.IndexByte(key, '.'); i >= 0 {
+		key = key[:i]
+	}
+	return reservedFields[key]
+}
+
+// addCreationMetadata merges the fields returned by the creation metadata
+// function into insert, the fields set when save creates a document, except
+// reserved fields and fields that set or unset already update.
+func (s *MongoStore) addCreationMetadata(ctx context.Context, r *http.Request, set, unset, insert bson.M) {
+	for k, v := range s.creationMetadata(r) {
+		_, updated := set[k]
+		if _, ok := unset[k]; ok {
+			updated = true
+		}
+		if isReservedField(k) || k == s.keyField() || updated {
+			s.log(ctx).Warnf("mongostore: creation metadata cannot set field %q", k)
+			continue
+		}
+		insert[k] = v
+	}
+}
+
+// decorateDocument runs the document decorator on a copy of the fields set
+// by save, and merges back the fields it added, except reserved ones.
+func (s *MongoStore) decorateDocument(ctx context.Context, r *http.Request, session *sessions.Session, set bson.M) {
+	doc := make(bson.M, len(set))
+	for k, v := range set {
+		doc[k] = v
+	}
+	s.decorate(r, session, doc)
+	for k, v := range doc {
+		if isReservedField(k) || k == s.keyField() {
+			if _, ok := set[k]; !ok {
+				s.log(ctx).Warnf("mongostore: document decorator cannot set reserved field %q", k)
+			}
+			continue
+		}
+		set[k] = v
+	}
+}
@@ -0,0 +1,121 @@
+package mongostore
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestDecorateDocument(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []Option
+		decorate bson.M
+		want     bson.M
+	}{
+		{"application field", nil, bson.M{"region": "eu"}, bson.M{"name": "test", "region": "eu"}},
+		{"reserved field set by the store", nil, bson.M{"name": "forged"}, bson.M{"name": "test"}},
+		{"reserved field not set by the store", nil, bson.M{"expiresAt": "never"}, bson.M{"name": "test"}},
+		{"dotted reserved field", nil, bson.M{"values.admin": true}, bson.M{"name": "test"}},
+		{"operator", nil, bson.M{"$where": "1"}, bson.M{"name": "test"}},
+		{"identifier", nil, bson.M{"_id": "forged"}, bson.M{"name": "test"}},
+		{"primary key field", []Option{WithPrimaryKeyField("sid")}, bson.M{"sid": "forged"}, bson.M{"name": "test"}},
+		{"fields removed by the decorator", nil, bson.M{"name": nil}, bson.M{"name": "test"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newUnitStore(t, append([]Option{WithDocumentDecorator(func(r *http.Request, session *sessions.Session, doc bson.M) {
+				for k, v := range tt.decorate {
+					if v == nil {
+						delete(doc, k)
+						continue
+					}
+					doc[k] = v
+				}
+			})}, tt.opts...)...)
+			set := bson.M{"name": "test"}
+			s.decorateDocument(context.Background(), httptest.NewRequest(http.MethodGet, "/", nil), sessions.NewSession(s, "test"), set)
+			if !reflect.DeepEqual(set, tt.want) {
+				t.Fatalf("decorated fields %v, want %v", set, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddCreationMetadata(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []Option
+		metadata bson.M
+		want     bson.M
+	}{
+		{"application field", nil, bson.M{"campaign": "spring"}, bson.M{"createdAt": "now", "campaign": "spring"}},
+		{"reserved field", nil, bson.M{"createdAt": "forged", "userID": "mallory"}, bson.M{"createdAt": "now"}},
+		{"dotted reserved field", nil, bson.M{"values.admin": true}, bson.M{"createdAt": "now"}},
+		{"operator", nil, bson.M{"$where": "1"}, bson.M{"createdAt": "now"}},
+		{"primary key field", []Option{WithPrimaryKeyField("sid")}, bson.M{"sid": "forged"}, bson.M{"createdAt": "now"}},
+		{"field set by the update", nil, bson.M{"region": "us"}, bson.M{"createdAt": "now"}},
+		{"field unset by the update", nil, bson.M{"legacy": true}, bson.M{"createdAt": "now"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newUnitStore(t, append([]Option{WithCreationMetadata(func(r *http.Request) bson.M {
+				return tt.metadata
+			})}, tt.opts...)...)
+			set, unset, insert := bson.M{"region": "eu"}, bson.M{"legacy": ""}, bson.M{"createdAt": "now"}
+			s.addCreationMetadata(context.Background(), httptest.NewRequest(http.MethodGet, "/", nil), set, unset, insert)
+			if !reflect.DeepEqual(insert, tt.want) {
+				t.Fatalf("inserted fields %v, want %v", insert, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreationMetadata(t *testing.T) {
+	tests := []struct {
+		name  string
+		saves int
+	}{
+		{"first save", 1},
+		{"later saves", 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			campaign := "spring"
+			s := newTestStore(t, WithCreationMetadata(func(r *http.Request) bson.M {
+				return bson.M{"campaign": campaign, "origin": r.Header.Get("X-Origin")}
+			}))
+			session := sessions.NewSession(s, "test")
+			session.Options = s.sessionOptions(session.Name())
+			for i := 0; i < tt.saves; i++ {
+				r := httptest.NewRequest(http.MethodGet, "/", nil)
+				r.Header.Set("X-Origin", "signup")
+				if i > 0 {
+					// Later requests must not update the metadata.
+					campaign = "summer"
+					r.Header.Set("X-Origin", "billing")
+				}
+				session.Values["page"] = i
+				if err := s.Save(r, httptest.NewRecorder(), session); err != nil {
+					t.Fatal(err)
+				}
+			}
+			docID, err := s.docID(session.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var doc bson.M
+			if err := s.collection.FindOne(context.Background(), s.idFilter(docID)).Decode(&doc); err != nil {
+				t.Fatal(err)
+			}
+			if doc["campaign"] != "spring" || doc["origin"] != "signup" {
+				t.Fatalf("metadata campaign %v, origin %v, want spring, signup", doc["campaign"], doc["origin"])
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package mongostore
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Severity is the severity of a diagnostic Finding.
+type Severity int
+
+// Severities of findings, from the least to the most severe.
+const (
+	SeverityInfo Severity = iota
+	SeverityWarning
+	SeverityError
+)
+
+// String returns the name of the severity.
+func (sev Severity) String() string {
+	switch sev {
+	case SeverityInfo:
+		return "info"
+	case SeverityWarning:
+		return "warning"
+	case SeverityError:
+		return "error"
+	}
+	return fmt.Sprintf("Severity(%d)", int(sev))
+}
+
+// Finding is an observation about the session collection made by Diagnose.
+type Finding struct {
+	Severity Severity
+	Message  string
+}
+
+// DiagnosticReport lists the findings of Diagnose.
+type DiagnosticReport struct {
+	Findings []Finding
+}
+
+// Healthy reports whether the report has no error finding.
+func (r DiagnosticReport) Healthy() bool {
+	for _, f := range r.Findings {
+		if f.Severity == SeverityError {
+			return false
+		}
+	}
+	return true
+}
+
+// add appends a finding to the report.
+func (r *DiagnosticReport) add(sev Severity, format string, args ...interface{}) {
+	r.Findings = append(r.Findings, Finding{Severity: sev, Message: fmt.Sprintf(format, args...)})
+}
+
+// collectionIndex is an index as listed by MongoDB.
+type collectionIndex struct {
+	Name               string   `bson:"name"`
+	Keys               bson.D   `bson:"key"`
+	Unique             bool     `bson:"unique"`
+	ExpireAfterSeconds *float64 `bson:"expireAfterSeconds"`
+}
+
+// on reports whether the index is on the given fields, in order.
+func (index collectionIndex) on(fields ...string) bool {
+	if len(index.Keys) != len(fields) {
+		return false
+	}
+	for i, field := range fields {
+		if index.Keys[i].Key != field {
+			return false
+		}
+	}
+	return true
+}
+
+// Diagnose checks that the session collection is set up as the store
+// configuration expects, e.g. in monitoring, to surface drift before it
+// causes incidents: the indexes created by EnsureIndexes must exist, and
+// session IDs must all be stored as ObjectIDs. It returns an error only if
+// the checks themselves fail; problems are reported as findings.
+func (s *MongoStore) Diagnose(ctx context.Context) (DiagnosticReport, error) {
+	var report DiagnosticReport
+	if s.collection == nil {
+		return report, ErrNoCollection
+	}
+	cur, err := s.collection.Indexes().List(ctx)
+	if err != nil {
+		return report, err
+	}
+	var indexes []collectionIndex
+	if err := cur.All(ctx, &indexes); err != nil {
+		return report, err
+	}
+	find := func(fields ...string) *collectionIndex {
+		for i := range indexes {
+			if indexes[i].on(fields...) {
+				return &indexes[i]
+			}
+		}
+		return nil
+	}
+
+	switch ttl := find("expiresAt"); {
+	case s.unixMillis:
+		report.add(SeverityInfo, "timestamps are stored as Unix milliseconds: TTL indexes do not apply, run GarbageCollect")
+	case ttl == nil:
+		report.add(SeverityError, "no index on expiresAt: expired sessions are never deleted, run EnsureIndexes")
+	case ttl.ExpireAfterSeconds == nil:
+		report.add(SeverityError, "index %s on expiresAt is not a TTL index: expired sessions are never deleted", ttl.Name)
+	case *ttl.ExpireAfterSeconds != 0:
+		report.add(SeverityWarning, "TTL index %s on expiresAt has expireAfterSeconds %v instead of 0: sessions are deleted late", ttl.Name, *ttl.ExpireAfterSeconds)
+	}
+	if legacy := find("modifiedAt"); legacy != nil && legacy.ExpireAfterSeconds != nil &&
+		s.Options.MaxAge > 0 && *legacy.ExpireAfterSeconds != float64(s.Options.MaxAge) {
+		report.add(SeverityWarning, "TTL index %s on modifiedAt expires sessions after %v seconds instead of MaxAge %d, run UpdateTTL",
+			legacy.Name, *legacy.ExpireAfterSeconds, s.Options.MaxAge)
+	}
+	if user := find("userID"); user == nil {
+		sev := SeverityWarning
+		if s.userIDIndexHint {
+			// Hinted queries fail without the index.
+			sev = SeverityError
+		}
+		report.add(sev, "no index on userID: per-user queries scan the collection, run EnsureIndexes")
+	}
+	if s.uniquePerUserAndName {
+		if index := find("userID", "name"); index == nil || !index.Unique {
+			report.add(SeverityError, "no unique index on userID and name: sessions are not unique per user and name, run EnsureIndexes")
+		}
+	}
+	if field := s.keyField(); field != "_id" {
+		if index := find(field); index == nil || !index.Unique {
+			report.add(SeverityError, "no unique index on the primary key field %s, run EnsureIndexes", field)
+		}
+	}
+
+	if s.stringIDs {
+		return report, nil
+	}
+	filter := bson.M{s.keyField(): bson.M{"$not": bson.M{"$type": "objectId"}}, "chunkOf": bson.M{"$exists": false}}
+	n, err := s.collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return report, err
+	}
+	if n > 0 {
+		report.add(SeverityWarning, "%d sessions have a %s that is not an ObjectID, run RepairIDs", n, s.keyField())
+	}
+	return report, nil
+}
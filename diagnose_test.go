@@ -0,0 +1,96 @@
+package mongostore
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestDiagnosticReport(t *testing.T) {
+	tests := []struct {
+		name        string
+		findings    []Finding
+		wantHealthy bool
+	}{
+		{"no finding", nil, true},
+		{"info and warning", []Finding{{SeverityInfo, "info"}, {SeverityWarning, "warning"}}, true},
+		{"error", []Finding{{SeverityWarning, "warning"}, {SeverityError, "error"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := (DiagnosticReport{Findings: tt.findings}).Healthy(); got != tt.wantHealthy {
+				t.Fatalf("Healthy() = %v, want %v", got, tt.wantHealthy)
+			}
+		})
+	}
+	for sev, want := range map[Severity]string{SeverityInfo: "info", SeverityWarning: "warning", SeverityError: "error", 7: "Severity(7)"} {
+		if got := sev.String(); got != want {
+			t.Errorf("Severity(%d).String() = %q, want %q", int(sev), got, want)
+		}
+	}
+}
+
+func TestDiagnose(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(t *testing.T, s *MongoStore)
+		// want are substrings of the expected findings, by severity.
+		want map[Severity][]string
+	}{
+		{"indexes ensured", func(t *testing.T, s *MongoStore) {}, nil},
+		{"missing TTL index", func(t *testing.T, s *MongoStore) {
+			if _, err := s.collection.Indexes().DropOne(context.Background(), ExpiresAtIndexName); err != nil {
+				t.Fatal(err)
+			}
+		}, map[Severity][]string{SeverityError: {"no index on expiresAt"}}},
+		{"missing user index", func(t *testing.T, s *MongoStore) {
+			if _, err := s.collection.Indexes().DropOne(context.Background(), UserIDIndexName); err != nil {
+				t.Fatal(err)
+			}
+		}, map[Severity][]string{SeverityWarning: {"no index on userID"}}},
+		{"inconsistent _id type", func(t *testing.T, s *MongoStore) {
+			docs := []interface{}{
+				bson.M{"_id": primitive.NewObjectID(), "name": "test", "data": ""},
+				bson.M{"_id": primitive.NewObjectID().Hex(), "name": "test", "data": ""},
+				bson.M{"_id": 42, "name": "test", "data": ""},
+			}
+			if _, err := s.collection.InsertMany(context.Background(), docs); err != nil {
+				t.Fatal(err)
+			}
+		}, map[Severity][]string{SeverityWarning: {"2 sessions have a _id that is not an ObjectID"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := newTestStore(t)
+			if err := s.EnsureIndexes(ctx); err != nil {
+				t.Fatal(err)
+			}
+			tt.setup(t, s)
+			report, err := s.Diagnose(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make(map[Severity][]string)
+			for _, f := range report.Findings {
+				got[f.Severity] = append(got[f.Severity], f.Message)
+			}
+			for _, sev := range []Severity{SeverityInfo, SeverityWarning, SeverityError} {
+				if len(got[sev]) != len(tt.want[sev]) {
+					t.Fatalf("%s findings %q, want %q", sev, got[sev], tt.want[sev])
+				}
+				for i, want := range tt.want[sev] {
+					if !strings.Contains(got[sev][i], want) {
+						t.Fatalf("%s finding %q, want it to contain %q", sev, got[sev][i], want)
+					}
+				}
+			}
+			if healthy := len(tt.want[SeverityError]) == 0; report.Healthy() != healthy {
+				t.Fatalf("Healthy() = %v, want %v", report.Healthy(), healthy)
+			}
+		})
+	}
+}
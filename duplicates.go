@@ -0,0 +1,37 @@
+package mongostore
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// handleDuplicates counts, or deletes if the store cleans up duplicates, the
+// documents matching filter other than the loaded one, whose _id is winner,
+// and reports them to the OnDuplicateSession hook. It is best-effort: errors
+// are only logged.
+func (s *MongoStore) handleDuplicates(ctx context.Context, id string, filter bson.M, winner bson.RawValue) {
+	stale := bson.M{"$and": bson.A{filter, bson.M{"_id": bson.M{"$ne": winner}}}}
+	var n int64
+	if s.cleanDuplicates {
+		res, err := s.collection.DeleteMany(ctx, stale)
+		if err != nil {
+			s.log(ctx).Warnf("mongostore: could not delete duplicates of session %s: %v", id, err)
+			return
+		}
+		n = res.DeletedCount
+	} else {
+		var err error
+		if n, err = s.collection.CountDocuments(ctx, stale); err != nil {
+			s.log(ctx).Warnf("mongostore: could not count duplicates of session %s: %v", id, err)
+			return
+		}
+	}
+	if n == 0 {
+		return
+	}
+	s.log(ctx).Warnf("mongostore: session %s has %d stale duplicates", id, n)
+	if s.hooks.OnDuplicateSession != nil {
+		s.hooks.OnDuplicateSession(id, n)
+	}
+}
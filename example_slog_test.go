@@ -0,0 +1,60 @@
+//go:build go1.21
+// +build go1.21
+
+// log/slog needs Go 1.21 or later, while the module supports Go 1.15: this
+// file is left out of builds with older toolchains.
+
+package mongostore_test
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/SpecialFlocon/mongostore"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// slogLogger adapts a *slog.Logger to mongostore.Logger.
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+func (l slogLogger) Debugf(format string, args ...interface{}) {
+	l.logger.Debug(fmt.Sprintf(format, args...))
+}
+
+func (l slogLogger) Warnf(format string, args ...interface{}) {
+	l.logger.Warn(fmt.Sprintf(format, args...))
+}
+
+func (l slogLogger) Errorf(format string, args ...interface{}) {
+	l.logger.Error(fmt.Sprintf(format, args...))
+}
+
+func ExampleWithLogger_slog() {
+	handler := slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+		// Drop timestamps, for the output to be reproducible.
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	})
+	client, err := mongo.NewClient(options.Client().ApplyURI("mongodb://localhost:27017"))
+	if err != nil {
+		panic(err)
+	}
+	store, err := mongostore.NewMongoStoreWithOptions(client.Database("app").Collection("sessions"), nil,
+		[][]byte{[]byte("0123456789abcdef0123456789abcdef")},
+		mongostore.WithLogger(slogLogger{logger: slog.New(handler)}))
+	if err != nil {
+		panic(err)
+	}
+	store.DecodeSessionCookie(context.Background(), "session", "forged")
+	// Output:
+	// level=WARN msg="mongostore: could not decode cookie for session \"session\": securecookie: base64 decode failed - caused by: illegal base64 data at input byte 4"
+}
@@ -0,0 +1,65 @@
+package mongostore
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var (
+	jitterMu   sync.Mutex
+	jitterRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// expiresAt returns the expiry date of a session saved at now with the given
+// max age in seconds, or the zero time if the session does not expire. When
+// the store has an expiry jitter, the lifetime is randomized within
+// ±jitter, but never below the configured floor.
+func (s *MongoStore) expiresAt(now time.Time, maxAge int) time.Time {
+	if maxAge <= 0 {
+		return time.Time{}
+	}
+	lifetime := time.Duration(maxAge) * time.Second
+	if s.expiryJitter > 0 {
+		jitterMu.Lock()
+		offset := time.Duration(jitterRand.Int63n(int64(2*s.expiryJitter)+1)) - s.expiryJitter
+		jitterMu.Unlock()
+		lifetime += offset
+		if lifetime < s.expiryFloor {
+			lifetime = s.expiryFloor
+		}
+	}
+	return now.Add(lifetime)
+}
+
+// timestamp returns t as stored in session documents: as a BSON date, or as
+// int64 Unix milliseconds with WithTimestampAsUnixMillis.
+func (s *MongoStore) timestamp(t time.Time) interface{} {
+	if s.unixMillis {
+		return t.UnixNano() / int64(time.Millisecond)
+	}
+	return t
+}
+
+// timestampType returns the BSON type alias of the timestamps stored in
+// session documents.
+func (s *MongoStore) timestampType() string {
+	if s.unixMillis {
+		return "long"
+	}
+	return "date"
+}
+
+// rawTimestamp returns the time stored in v, a BSON date or int64 Unix
+// milliseconds, and whether v holds one.
+func rawTimestamp(v bson.RawValue) (time.Time, bool) {
+	if t, ok := v.TimeOK(); ok {
+		return t, true
+	}
+	if ms, ok := v.Int64OK(); ok {
+		return time.Unix(ms/1000, ms%1000*int64(time.Millisecond)), true
+	}
+	return time.Time{}, false
+}
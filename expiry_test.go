@@ -0,0 +1,230 @@
+package mongostore
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestExpiryJitter(t *testing.T) {
+	const samples = 2000
+	const maxAge = 3600
+	tests := []struct {
+		name     string
+		opts     []Option
+		min, max time.Duration
+		spread   bool
+	}{
+		{"no jitter", nil, time.Hour, time.Hour, false},
+		{"jitter", []Option{WithExpiryJitter(10 * time.Minute)}, 50 * time.Minute, 70 * time.Minute, true},
+		{"jitter with floor", []Option{WithExpiryJitter(30 * time.Minute), WithExpiryFloor(50 * time.Minute)}, 50 * time.Minute, 90 * time.Minute, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newUnitStore(t, tt.opts...)
+			now := time.Now()
+			var below, above int
+			lo, hi := tt.max, tt.min
+			for i := 0; i < samples; i++ {
+				lifetime := s.expiresAt(now, maxAge).Sub(now)
+				if lifetime < tt.min || lifetime > tt.max {
+					t.Fatalf("lifetime %s outside [%s, %s]", lifetime, tt.min, tt.max)
+				}
+				if lifetime < lo {
+					lo = lifetime
+				}
+				if lifetime > hi {
+					hi = lifetime
+				}
+				switch {
+				case lifetime < time.Hour:
+					below++
+				case lifetime > time.Hour:
+					above++
+				}
+			}
+			if !tt.spread {
+				return
+			}
+			// Lifetimes are uniform around an hour: both sides get a
+			// fair share, and the extremes are close to the bounds.
+			if below < samples/4 || above < samples/4 {
+				t.Fatalf("%d lifetimes below an hour and %d above, out of %d", below, above, samples)
+			}
+			if width := tt.max - tt.min; hi-lo < width*9/10 {
+				t.Fatalf("lifetimes spread over [%s, %s], want most of [%s, %s]", lo, hi, tt.min, tt.max)
+			}
+		})
+	}
+	if s := newUnitStore(t); !s.expiresAt(time.Now(), 0).IsZero() {
+		t.Fatal("sessions without MaxAge expire")
+	}
+}
+
+func TestPerNameMaxAge(t *testing.T) {
+	ages := map[string]int{"csrf": 600, "auth": 86400}
+	tests := []struct {
+		name       string
+		wantMaxAge int
+	}{
+		{"csrf", 600},
+		{"auth", 86400},
+		{"unlisted", 3600},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore(t, WithPerNameMaxAge(ages), WithCodecMaxAge(86400))
+			s.MaxAge(3600)
+			ages["csrf"] = 1
+			defer func() { ages["csrf"] = 600 }()
+			session, err := s.New(httptest.NewRequest(http.MethodGet, "/", nil), tt.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if session.Options.MaxAge != tt.wantMaxAge {
+				t.Fatalf("MaxAge = %d, want %d", session.Options.MaxAge, tt.wantMaxAge)
+			}
+			before := time.Now()
+			saveSession(t, s, session)
+			docID, err := s.docID(session.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var doc Session
+			if err := s.collection.FindOne(context.Background(), s.idFilter(docID)).Decode(&doc); err != nil {
+				t.Fatal(err)
+			}
+			maxAge := time.Duration(tt.wantMaxAge) * time.Second
+			if doc.ExpiresAt.Before(before.Add(maxAge).Truncate(time.Millisecond)) || doc.ExpiresAt.After(time.Now().Add(maxAge)) {
+				t.Fatalf("expiresAt = %v, want %s after %v", doc.ExpiresAt, maxAge, before)
+			}
+		})
+	}
+}
+
+func TestExpiryGrace(t *testing.T) {
+	tests := []struct {
+		name         string
+		grace        time.Duration
+		expiredAgo   time.Duration
+		wantLoaded   bool
+		wantExpiring bool
+	}{
+		{"live session", time.Minute, -time.Hour, true, false},
+		{"expired without grace", 0, time.Second, false, false},
+		{"within the grace period", time.Minute, 30 * time.Second, true, true},
+		{"beyond the grace period", time.Minute, 2 * time.Minute, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newUnitStore(t, WithExpiryGrace(tt.grace))
+			now := time.Now()
+			session := sessions.NewSession(s, "test")
+			session.Values["user"] = "alice"
+			data, _, err := s.encodeData(session, session.Values)
+			if err != nil {
+				t.Fatal(err)
+			}
+			loaded := sessions.NewSession(s, "test")
+			doc := &Session{Data: data, ModifiedAt: now, ExpiresAt: now.Add(-tt.expiredAgo)}
+			err = s.loadDocument(context.Background(), loaded, doc)
+			if tt.wantLoaded && (err != nil || loaded.Values["user"] != "alice") {
+				t.Fatalf("loadDocument() = %v, values %v, want the session loaded", err, loaded.Values)
+			}
+			if !tt.wantLoaded && !errors.Is(err, mongo.ErrNoDocuments) {
+				t.Fatalf("loadDocument() = %v, want mongo.ErrNoDocuments", err)
+			}
+			if got := IsExpiring(loaded); got != tt.wantExpiring {
+				t.Fatalf("IsExpiring() = %v, want %v", got, tt.wantExpiring)
+			}
+		})
+	}
+}
+
+func TestRawTimestamp(t *testing.T) {
+	at := time.Date(2020, 3, 1, 12, 30, 0, 250*int(time.Millisecond), time.UTC)
+	tests := []struct {
+		name   string
+		value  interface{}
+		want   time.Time
+		wantOK bool
+	}{
+		{"date", at, at, true},
+		{"Unix milliseconds", at.UnixNano() / int64(time.Millisecond), at, true},
+		{"string", at.Format(time.RFC3339), time.Time{}, false},
+		{"32-bit integer", int32(42), time.Time{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, err := bson.Marshal(bson.M{"t": tt.value})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, ok := rawTimestamp(bson.Raw(raw).Lookup("t"))
+			if ok != tt.wantOK || !got.Equal(tt.want) {
+				t.Fatalf("rawTimestamp() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestTimestampAsUnixMillis(t *testing.T) {
+	tests := []struct {
+		name     string
+		enabled  bool
+		wantType bsontype.Type
+	}{
+		{"dates", false, bsontype.DateTime},
+		{"Unix milliseconds", true, bsontype.Int64},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := newTestStore(t, WithTimestampAsUnixMillis(tt.enabled))
+			session := sessions.NewSession(s, "test")
+			session.Options = s.sessionOptions(session.Name())
+			session.Values["user"] = "alice"
+			before := time.Now().Truncate(time.Millisecond)
+			value := saveSession(t, s, session)
+			docID, err := s.docID(session.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			raw, err := s.collection.FindOne(ctx, s.idFilter(docID)).DecodeBytes()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, field := range []string{"createdAt", "modifiedAt", "expiresAt"} {
+				v := raw.Lookup(field)
+				if v.Type != tt.wantType {
+					t.Fatalf("%s stored as %s, want %s", field, v.Type, tt.wantType)
+				}
+				if at, ok := rawTimestamp(v); !ok || at.Before(before) || at.After(time.Now().Add(time.Hour+time.Second)) {
+					t.Fatalf("%s = %v, want a date after %v", field, at, before)
+				}
+			}
+			if loaded := loadSession(t, s, "test", value); loaded.IsNew || loaded.Values["user"] != "alice" {
+				t.Fatalf("loaded session new: %v, values %v", loaded.IsNew, loaded.Values)
+			}
+
+			update := bson.M{"$set": bson.M{"expiresAt": s.timestamp(time.Now().Add(-time.Minute))}}
+			if _, err := s.collection.UpdateOne(ctx, s.idFilter(docID), update); err != nil {
+				t.Fatal(err)
+			}
+			if loaded := loadSession(t, s, "test", value); !loaded.IsNew {
+				t.Fatal("expired session loaded")
+			}
+			if n, err := s.GarbageCollect(ctx); err != nil || n != 1 {
+				t.Fatalf("GarbageCollect() = %d, %v, want 1", n, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package mongostore
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+)
+
+// fingerprintLength is the number of hexadecimal digits of key pair
+// fingerprints.
+const fingerprintLength = 16
+
+// keyFingerprints returns the fingerprints of keyPairs, grouped in hash and
+// block key pairs like securecookie.CodecsFromPairs does.
+func keyFingerprints(keyPairs [][]byte) []string {
+	var fingerprints []string
+	for i := 0; i < len(keyPairs); i += 2 {
+		pair := keyPairs[i:]
+		if len(pair) > 2 {
+			pair = pair[:2]
+		}
+		h := sha256.New()
+		for _, key := range pair {
+			// Length-prefix keys, so that pairs are not ambiguous.
+			var n [8]byte
+			binary.BigEndian.PutUint64(n[:], uint64(len(key)))
+			h.Write(n[:])
+			h.Write(key)
+		}
+		fingerprints = append(fingerprints, hex.EncodeToString(h.Sum(nil))[:fingerprintLength])
+	}
+	return fingerprints
+}
+
+// CodecFingerprints returns a fingerprint of each key pair the store was
+// created with, in order: the first 16 hexadecimal digits of a SHA-256 hash
+// of the pair. Fingerprints identify keys without revealing them, e.g. to
+// check which keys a deployed instance uses during a key rotation. Identical
+// key pairs have identical fingerprints.
+func (s *MongoStore) CodecFingerprints() []string {
+	fingerprints := make([]string, len(s.fingerprints))
+	copy(fingerprints, s.fingerprints)
+	return fingerprints
+}
@@ -0,0 +1,57 @@
+package mongostore
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestCodecFingerprints(t *testing.T) {
+	hashA := []byte("0123456789abcdef0123456789abcdef")
+	hashB := []byte("fedcba9876543210fedcba9876543210")
+	block := []byte("0123456789abcdef")
+	tests := []struct {
+		name      string
+		a, b      [][]byte
+		wantEqual bool
+	}{
+		{"identical keys", [][]byte{hashA}, [][]byte{hashA}, true},
+		{"identical key pairs", [][]byte{hashA, block}, [][]byte{hashA, block}, true},
+		{"different hash keys", [][]byte{hashA}, [][]byte{hashB}, false},
+		{"with and without block key", [][]byte{hashA, block}, [][]byte{hashA}, false},
+		{"keys split differently", [][]byte{[]byte("ab"), []byte("c")}, [][]byte{[]byte("a"), []byte("bc")}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewMongoStore(nil, nil, tt.a...).CodecFingerprints()
+			b := NewMongoStore(nil, nil, tt.b...).CodecFingerprints()
+			if len(a) != 1 || len(b) != 1 {
+				t.Fatalf("fingerprints %v and %v, want one each", a, b)
+			}
+			if (a[0] == b[0]) != tt.wantEqual {
+				t.Fatalf("fingerprints %s and %s, want equal: %v", a[0], b[0], tt.wantEqual)
+			}
+			if len(a[0]) != fingerprintLength {
+				t.Fatalf("fingerprint %s, want %d hexadecimal digits", a[0], fingerprintLength)
+			}
+			for _, key := range tt.a {
+				if len(key) >= fingerprintLength && strings.Contains(a[0], hex.EncodeToString(key)[:fingerprintLength/2]) {
+					t.Fatalf("fingerprint %s reveals key %q", a[0], key)
+				}
+			}
+		})
+	}
+}
+
+func TestCodecFingerprintsRotation(t *testing.T) {
+	oldKey := []byte("0123456789abcdef0123456789abcdef")
+	newKey := []byte("fedcba9876543210fedcba9876543210")
+	before := NewMongoStore(nil, nil, oldKey, nil).CodecFingerprints()
+	during := NewMongoStore(nil, nil, newKey, nil, oldKey, nil).CodecFingerprints()
+	if len(before) != 1 || len(during) != 2 {
+		t.Fatalf("fingerprints %v and %v, want one and two", before, during)
+	}
+	if during[1] != before[0] || during[0] == before[0] {
+		t.Fatalf("fingerprints during the rotation %v, want the new key first and then %s", during, before[0])
+	}
+}
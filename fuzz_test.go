@@ -0,0 +1,58 @@
+//go:build go1.18
+// +build go1.18
+
+// Fuzzing needs Go 1.18 or later, while the module supports Go 1.15: this
+// file is left out of builds with older toolchains.
+
+package mongostore
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func FuzzDecodeCookie(f *testing.F) {
+	codecs := securecookie.CodecsFromPairs(testKeyPairs...)
+	for _, id := range []string{primitive.NewObjectID().Hex(), "", "not an ID", strings.Repeat("f", 25)} {
+		encoded, err := securecookie.EncodeMulti("test", id, codecs...)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(encoded)
+	}
+	f.Add("")
+	f.Add("garbage")
+	f.Add(strings.Repeat("A", 4096))
+	var loads int
+	s := newUnitStore(f, WithHooks(Hooks{
+		OnOperation: func(op string, duration time.Duration, err error) {
+			if op == OpLoad {
+				loads++
+			}
+		},
+	}))
+	f.Fuzz(func(t *testing.T, value string) {
+		loads = 0
+		session, err := s.DecodeSessionCookie(context.Background(), "test", value)
+		if session == nil {
+			t.Fatal("DecodeSessionCookie() returned no session")
+		}
+		if !session.IsNew {
+			t.Fatalf("session %q loaded from a disconnected store", session.ID)
+		}
+		if errors.Is(err, ErrInvalidID) {
+			if loads != 0 {
+				t.Fatalf("malformed ID %q was looked up", session.ID)
+			}
+			if session.ID != "" {
+				t.Fatalf("malformed ID %q kept", session.ID)
+			}
+		}
+	})
+}
@@ -0,0 +1,179 @@
+package mongostore
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// GarbageCollect deletes the expired sessions, and returns the number of
+// deleted sessions. Sessions expire at their stored expiry date, or, for
+// documents saved without one, MaxAge seconds after their last modification.
+//
+// With WithArchiveCollection, expired sessions are archived before being
+// deleted.
+//
+// It is an alternative to the TTL index created by EnsureIndexes. The cutoff
+// is computed from the application clock and padded by the clock skew
+// allowance (see WithClockSkewAllowance).
+func (s *MongoStore) GarbageCollect(ctx context.Context) (int64, error) {
+	if s.collection == nil {
+		return 0, ErrNoCollection
+	}
+	ctx, cancel := s.adminContext(ctx)
+	defer cancel()
+	filter := s.expiredFilter(time.Now())
+	var deleted int64
+	var err error
+	if s.archiveCollection != nil {
+		deleted, err = s.archiveExpired(ctx, filter)
+	} else {
+		var res *mongo.DeleteResult
+		if res, err = s.collection.DeleteMany(ctx, filter); err == nil {
+			deleted = res.DeletedCount
+		}
+	}
+	if err != nil {
+		s.log(ctx).Errorf("mongostore: garbage collection failed: %v", err)
+		return deleted, err
+	}
+	s.log(ctx).Debugf("mongostore: garbage collection deleted %d sessions", deleted)
+	return deleted, nil
+}
+
+// expiredFilter returns the filter matching the documents expired at now.
+func (s *MongoStore) expiredFilter(now time.Time) bson.M {
+	cutoff := s.expiryCutoff(now)
+	filter := bson.M{"expiresAt": bson.M{"$lt": s.timestamp(cutoff)}}
+	if s.Options.MaxAge > 0 {
+		maxAge := time.Duration(s.Options.MaxAge) * time.Second
+		filter = bson.M{"$or": bson.A{
+			filter,
+			bson.M{"expiresAt": bson.M{"$exists": false}, "modifiedAt": bson.M{"$lt": s.timestamp(cutoff.Add(-maxAge))}},
+		}}
+	}
+	return filter
+}
+
+// SessionCursor iterates over session documents. It must be closed after
+// use.
+type SessionCursor struct {
+	store *MongoStore
+	cur   *mongo.Cursor
+	doc   Session
+	err   error
+}
+
+// Next advances the cursor to the next session, and reports whether there is
+// one. It returns false at the end of the documents, or on error (see Err).
+func (c *SessionCursor) Next(ctx context.Context) bool {
+	if c.err != nil || !c.cur.Next(ctx) {
+		return false
+	}
+	c.doc = Session{}
+	if c.err = c.store.decodeDocument(c.cur.Current, &c.doc); c.err != nil {
+		return false
+	}
+	return true
+}
+
+// Session returns the current session document. Its data is left as stored:
+// chunked data (see WithChunking) is not assembled.
+func (c *SessionCursor) Session() *Session {
+	return &c.doc
+}
+
+// Meta returns the metadata of the current session.
+func (c *SessionCursor) Meta() SessionMeta {
+	return c.store.sessionMeta(&c.doc)
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (c *SessionCursor) Err() error {
+	if c.err != nil {
+		return c.err
+	}
+	return c.cur.Err()
+}
+
+// Close closes the cursor.
+func (c *SessionCursor) Close(ctx context.Context) error {
+	return c.cur.Close(ctx)
+}
+
+// IterateExpired returns a cursor over the expired sessions, i.e. those that
+// GarbageCollect would delete, e.g. to emit "session ended" events before
+// calling it. It does not delete anything, but the TTL index created by
+// EnsureIndexes may delete expired sessions before they are iterated.
+func (s *MongoStore) IterateExpired(ctx context.Context) (*SessionCursor, error) {
+	if s.collection == nil {
+		return nil, ErrNoCollection
+	}
+	filter := s.expiredFilter(time.Now())
+	filter = bson.M{"$and": bson.A{filter, bson.M{"chunkOf": bson.M{"$exists": false}}}}
+	cur, err := s.collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	return &SessionCursor{store: s, cur: cur}, nil
+}
+
+// expiryCutoff returns the date before which sessions are considered expired
+// at now. It lags behind now by the clock skew allowance, so that a clock
+// running ahead of the database clock keeps sessions slightly longer rather
+// than expiring them early.
+func (s *MongoStore) expiryCutoff(now time.Time) time.Time {
+	return now.Add(-s.clockSkew)
+}
+
+// StartGC starts a goroutine running GarbageCollect every interval, until ctx
+// is done or the store is closed. Results are logged with the store Logger.
+// A collection is skipped if the previous one, possibly started by another
+// StartGC goroutine, is still running.
+//
+// It returns an error if interval is not positive, and ErrStoreClosed if the
+// store is closed.
+func (s *MongoStore) StartGC(ctx context.Context, interval time.Duration) error {
+	if s.collection == nil {
+		return ErrNoCollection
+	}
+	if interval <= 0 {
+		return fmt.Errorf("mongostore: garbage collection interval must be positive, got %s", interval)
+	}
+	untrack, ok := s.lifecycle.track()
+	if !ok {
+		return ErrStoreClosed
+	}
+	done := s.lifecycle.doneChan()
+	go func() {
+		defer untrack()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-done:
+				return
+			case <-ticker.C:
+				s.runGC(ctx)
+			}
+		}
+	}()
+	return nil
+}
+
+// runGC runs GarbageCollect unless a collection is already running.
+func (s *MongoStore) runGC(ctx context.Context) {
+	if !atomic.CompareAndSwapInt32(&s.gcRunning, 0, 1) {
+		s.log(ctx).Debugf("mongostore: skipping garbage collection, previous one still running")
+		return
+	}
+	defer atomic.StoreInt32(&s.gcRunning, 0)
+	// GarbageCollect logs its own results.
+	_, _ = s.GarbageCollect(ctx)
+}
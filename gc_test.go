@@ -0,0 +1,140 @@
+package mongostore
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestStartGCInterval(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval time.Duration
+		closed   bool
+		wantErr  bool
+	}{
+		{"positive interval", time.Hour, false, false},
+		{"zero interval", 0, false, true},
+		{"negative interval", -time.Second, false, true},
+		{"closed store", time.Hour, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newUnitStore(t)
+			if tt.closed {
+				if err := s.Close(context.Background()); err != nil {
+					t.Fatal(err)
+				}
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if err := s.StartGC(ctx, tt.interval); (err != nil) != tt.wantErr {
+				t.Fatalf("StartGC(%s) = %v, want error: %v", tt.interval, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestClockSkewAllowance(t *testing.T) {
+	tests := []struct {
+		name        string
+		skew        time.Duration
+		expiredAgo  time.Duration
+		wantExpired bool
+	}{
+		{"no allowance", 0, time.Second, true},
+		{"expired within the allowance", time.Minute, 30 * time.Second, false},
+		{"expired past the allowance", time.Minute, 2 * time.Minute, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newUnitStore(t, WithClockSkewAllowance(tt.skew))
+			s.Options.MaxAge = 0
+			now := time.Now()
+			filter := s.expiredFilter(now)
+			if got, _ := filter["expiresAt"].(bson.M)["$lt"].(time.Time); !got.Equal(now.Add(-tt.skew)) {
+				t.Fatalf("garbage collection cutoff = %v, want %v", got, now.Add(-tt.skew))
+			}
+			session := sessions.NewSession(s, "test")
+			data, _, err := s.encodeData(session, session.Values)
+			if err != nil {
+				t.Fatal(err)
+			}
+			doc := &Session{Data: data, ModifiedAt: now, ExpiresAt: now.Add(-tt.expiredAgo)}
+			err = s.loadDocument(context.Background(), session, doc)
+			if expired := errors.Is(err, mongo.ErrNoDocuments); expired != tt.wantExpired {
+				t.Fatalf("loadDocument() = %v, want expired: %v", err, tt.wantExpired)
+			}
+		})
+	}
+}
+
+func TestIterateExpired(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   []Option
+		maxAge int
+	}{
+		{"MaxAge", nil, 3600},
+		{"no MaxAge", nil, 0},
+		{"Unix milliseconds", []Option{WithTimestampAsUnixMillis(true)}, 3600},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := newTestStore(t, tt.opts...)
+			s.Options.MaxAge = tt.maxAge
+			now := time.Now()
+			expired, live := primitive.NewObjectID(), primitive.NewObjectID()
+			stale, recent := primitive.NewObjectID(), primitive.NewObjectID()
+			docs := []interface{}{
+				bson.M{"_id": expired, "name": "test", "data": "", "modifiedAt": s.timestamp(now.Add(-2 * time.Hour)), "expiresAt": s.timestamp(now.Add(-time.Hour))},
+				bson.M{"_id": live, "name": "test", "data": "", "modifiedAt": s.timestamp(now.Add(-2 * time.Hour)), "expiresAt": s.timestamp(now.Add(time.Hour))},
+				// Documents without an expiry date expire MaxAge after
+				// their modification.
+				bson.M{"_id": stale, "name": "test", "data": "", "modifiedAt": s.timestamp(now.Add(-2 * time.Hour))},
+				bson.M{"_id": recent, "name": "test", "data": "", "modifiedAt": s.timestamp(now)},
+				bson.M{"_id": primitive.NewObjectID(), "chunkOf": expired, "n": 0, "data": "", "expiresAt": s.timestamp(now.Add(-time.Hour))},
+			}
+			if _, err := s.collection.InsertMany(ctx, docs); err != nil {
+				t.Fatal(err)
+			}
+			want := map[string]bool{expired.Hex(): true}
+			if tt.maxAge > 0 {
+				want[stale.Hex()] = true
+			}
+
+			cur, err := s.IterateExpired(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make(map[string]bool)
+			for cur.Next(ctx) {
+				if id := cur.Meta().ID; got[id] {
+					t.Fatalf("session %s yielded twice", id)
+				} else {
+					got[id] = true
+				}
+			}
+			if err := cur.Err(); err != nil {
+				t.Fatal(err)
+			}
+			if err := cur.Close(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("IterateExpired() yielded %v, want %v", got, want)
+			}
+			if n, err := s.collection.CountDocuments(ctx, bson.M{}); err != nil || n != int64(len(docs)) {
+				t.Fatalf("%d documents left, %v, want %d", n, err, len(docs))
+			}
+		})
+	}
+}
@@ -5,5 +5,6 @@ go 1.15
 require (
 	github.com/gorilla/securecookie v1.1.1
 	github.com/gorilla/sessions v1.2.1
+	github.com/klauspost/compress v1.9.5
 	go.mongodb.org/mongo-driver v1.4.2
 )
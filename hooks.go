@@ -0,0 +1,83 @@
+package mongostore
+
+import (
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Operations reported to Hooks.OnOperation.
+const (
+	OpLoad  = "load"
+	OpSave  = "save"
+	OpErase = "erase"
+)
+
+// Session lifecycle events reported to Hooks.OnSessionEvent.
+const (
+	EventCreated   = "created"
+	EventLoaded    = "loaded"
+	EventDestroyed = "destroyed"
+)
+
+// Hooks are functions called by the store on notable events. A nil hook is
+// ignored. Hooks are set with WithHooks.
+type Hooks struct {
+	// OnUndecodableSession is called with the ID of a stored session whose
+	// data cannot be decoded by the store codecs, e.g. because it was
+	// encoded with a key only known to another deployed version of the
+	// application. When it is set, such sessions are replaced by a new
+	// session instead of making New return an error, which logs the user
+	// out. The stored document is left untouched until it expires.
+	OnUndecodableSession func(id string)
+
+	// OnDuplicateSession is called when a session is loaded while several
+	// documents exist for its ID, as older versions of this package could
+	// leave behind, with the number of stale duplicates. The most recently
+	// modified document is always the one loaded. With
+	// WithDuplicateCleanup, the hook is only called once the duplicates are
+	// deleted.
+	OnDuplicateSession func(id string, duplicates int64)
+
+	// OnOperation is called after each load, save and erase of a session
+	// (see OpLoad, OpSave and OpErase) with its duration and error. Loading
+	// a session that does not exist is not an error. It is meant for
+	// metrics, and must not block.
+	OnOperation func(op string, duration time.Duration, err error)
+
+	// OnCacheLookup is called after each lookup in the shared cache (see
+	// WithSharedCache), reporting whether the session was found.
+	OnCacheLookup func(hit bool)
+
+	// OnArchiveError is called when sessions cannot be copied to the
+	// archive collection (see WithArchiveCollection). They are deleted
+	// anyway.
+	OnArchiveError func(err error)
+
+	// OnSessionEvent is called when a save creates a session document, when
+	// a session is loaded from its cookie, and when a session is erased
+	// (see EventCreated, EventLoaded and EventDestroyed), e.g. to count
+	// sessions. It must not block.
+	OnSessionEvent func(event string)
+}
+
+// event reports a session lifecycle event to the OnSessionEvent hook.
+func (s *MongoStore) event(event string) {
+	if s.hooks.OnSessionEvent != nil {
+		s.hooks.OnSessionEvent(event)
+	}
+}
+
+// observe reports the operation op, started at start, to the OnOperation
+// hook. It is meant to be deferred.
+func (s *MongoStore) observe(op string, start time.Time, errp *error) {
+	if s.hooks.OnOperation == nil {
+		return
+	}
+	err := *errp
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		err = nil
+	}
+	s.hooks.OnOperation(op, time.Since(start), err)
+}
@@ -0,0 +1,56 @@
+package mongostore
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+func TestUndecodableSession(t *testing.T) {
+	keyA := []byte("0123456789abcdef0123456789abcdef")
+	keyB := []byte("fedcba9876543210fedcba9876543210")
+	tests := []struct {
+		name            string
+		keyPairs        [][]byte
+		hook            bool
+		wantErr         bool
+		wantUndecodable bool
+	}{
+		{"same keys", [][]byte{keyA}, true, false, false},
+		{"overlapping keys", [][]byte{keyB, nil, keyA, nil}, true, false, false},
+		{"other keys", [][]byte{keyB}, false, true, false},
+		{"other keys with the hook", [][]byte{keyB}, true, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The session data was encoded by a version of the
+			// application only knowing keyA.
+			data, err := securecookie.EncodeMulti("test", map[interface{}]interface{}{"user": "alice"}, securecookie.CodecsFromPairs(keyA)...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var hooks Hooks
+			if tt.hook {
+				hooks.OnUndecodableSession = func(string) {}
+			}
+			s, err := NewMongoStoreWithOptions(newUnitStore(t).collection, nil, tt.keyPairs, WithHooks(hooks))
+			if err != nil {
+				t.Fatal(err)
+			}
+			session := sessions.NewSession(s, "test")
+			err = s.loadData(context.Background(), session, &Session{Data: data})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadData() = %v, want error: %v", err, tt.wantErr)
+			}
+			if got := errors.Is(err, errUndecodableSession); got != tt.wantUndecodable {
+				t.Fatalf("loadData() = %v, want undecodable: %v", err, tt.wantUndecodable)
+			}
+			if err == nil && session.Values["user"] != "alice" {
+				t.Fatalf("decoded values %v", session.Values)
+			}
+		})
+	}
+}
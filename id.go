@@ -0,0 +1,183 @@
+package mongostore
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// IDEncoding is the encoding of session IDs, i.e. of document ObjectIDs, in
+// session cookies (see WithIDEncoding).
+type IDEncoding int
+
+const (
+	// HexEncoding encodes session IDs as 24 hexadecimal digits. It is the
+	// default.
+	HexEncoding IDEncoding = iota
+	// Base64Encoding encodes session IDs as 16 characters of unpadded
+	// URL-safe base64.
+	Base64Encoding
+)
+
+// idEntropy is the size in bits of session IDs, i.e. of ObjectIDs.
+const idEntropy = 96
+
+// stringIDEntropy is the size in bits of the session IDs generated for
+// stores configured with WithStringIDs.
+const stringIDEntropy = 128
+
+// entropy returns the size in bits of the session IDs generated by the store.
+func (s *MongoStore) entropy() int {
+	if s.stringIDs {
+		return stringIDEntropy
+	}
+	return idEntropy
+}
+
+// RandomObjectID returns an ObjectID made of 96 random bits from
+// crypto/rand, to be used with WithIDGenerator. Unlike the ObjectIDs
+// generated by default, which are made of a timestamp, a per-process random
+// value and a counter, its bits are all unpredictable.
+func RandomObjectID() (primitive.ObjectID, error) {
+	var id primitive.ObjectID
+	if _, err := rand.Read(id[:]); err != nil {
+		return primitive.NilObjectID, err
+	}
+	return id, nil
+}
+
+// newSessionID returns the session ID of a new document ID, drawn from the
+// generator set with WithIDGenerator if any. Generated IDs must not be
+// NilObjectID. With WithStringIDs, it returns 32 random hexadecimal digits.
+func (s *MongoStore) newSessionID() (string, error) {
+	if s.stringIDs {
+		b := make([]byte, stringIDEntropy/8)
+		if _, err := rand.Read(b); err != nil {
+			return "", fmt.Errorf("mongostore: could not generate session ID: %w", err)
+		}
+		return hex.EncodeToString(b), nil
+	}
+	if s.idGenerator == nil {
+		return s.sessionID(primitive.NewObjectID()), nil
+	}
+	id, err := s.idGenerator()
+	if err != nil {
+		return "", fmt.Errorf("mongostore: could not generate session ID: %w", err)
+	}
+	if id.IsZero() {
+		return "", fmt.Errorf("%w: generated session ID is zero", ErrWeakSessionID)
+	}
+	return s.sessionID(id), nil
+}
+
+// errInvalidIDLength is returned when decoding a base64 session ID that is
+// not 12 bytes long.
+var errInvalidIDLength = errors.New("invalid session ID length")
+
+// sessionID returns the session ID of the document ID id, as returned by
+// docID.
+func (s *MongoStore) sessionID(id interface{}) string {
+	switch id := id.(type) {
+	case primitive.ObjectID:
+		if s.idEncoding == Base64Encoding {
+			return base64.RawURLEncoding.EncodeToString(id[:])
+		}
+		return id.Hex()
+	case string:
+		return id
+	}
+	return fmt.Sprint(id)
+}
+
+// documentID returns the ID of the session document doc, as returned by
+// docID.
+func (s *MongoStore) documentID(doc *Session) interface{} {
+	if s.stringIDs {
+		return doc.Key
+	}
+	return doc.ID
+}
+
+// idFilter returns the filter matching the documents of the sessions with the
+// given document IDs, as returned by docID. Documents written by older
+// versions may be keyed by the hexadecimal string of an ObjectID, and
+// documents written before WithStringIDs was set by the ObjectID of a
+// hexadecimal string ID, so both forms match. Upserts must match the ID
+// alone, so that the documents they insert are keyed by it.
+func (s *MongoStore) idFilter(ids ...interface{}) bson.M {
+	keys := make(bson.A, 0, 2*len(ids))
+	for _, id := range ids {
+		keys = append(keys, id)
+		switch id := id.(type) {
+		case primitive.ObjectID:
+			keys = append(keys, id.Hex())
+		case string:
+			if objID, err := primitive.ObjectIDFromHex(id); err == nil {
+				keys = append(keys, objID)
+			}
+		}
+	}
+	return bson.M{s.keyField(): bson.M{"$in": keys}}
+}
+
+// parseID is like docID, but checks the length of id before decoding it,
+// and returns an error wrapping ErrInvalidID, so that session IDs from
+// untrusted input are rejected early and never reach the database.
+func (s *MongoStore) parseID(id string) (interface{}, error) {
+	if s.stringIDs {
+		if id == "" {
+			return nil, fmt.Errorf("%w: empty", ErrInvalidID)
+		}
+		return id, nil
+	}
+	length := 24
+	if s.idEncoding == Base64Encoding {
+		length = 16
+	}
+	if len(id) != length {
+		return nil, fmt.Errorf("%w: %d characters long", ErrInvalidID, len(id))
+	}
+	objID, err := s.docID(id)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidID, err)
+	}
+	return objID, nil
+}
+
+// docID returns the document _id matching a session ID.
+//
+// Session IDs are an encoding of the ObjectID stored as the document _id:
+// they must be converted back before querying the collection, or no document
+// would ever match. With WithStringIDs, documents are keyed by the session
+// ID itself, which must not be empty.
+func (s *MongoStore) docID(id string) (interface{}, error) {
+	if s.stringIDs {
+		if id == "" {
+			return nil, errors.New("empty session ID")
+		}
+		return id, nil
+	}
+	return s.objectID(id)
+}
+
+// objectID returns the ObjectID encoded by a session ID.
+func (s *MongoStore) objectID(id string) (primitive.ObjectID, error) {
+	if s.idEncoding != Base64Encoding {
+		return primitive.ObjectIDFromHex(id)
+	}
+	var objID primitive.ObjectID
+	b, err := base64.RawURLEncoding.DecodeString(id)
+	if err != nil {
+		return objID, err
+	}
+	if len(b) != len(objID) {
+		return objID, errInvalidIDLength
+	}
+	copy(objID[:], b)
+	return objID, nil
+}
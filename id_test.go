@@ -0,0 +1,411 @@
+package mongostore
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestIDFilter(t *testing.T) {
+	a, b := primitive.NewObjectID(), primitive.NewObjectID()
+	token := "0123456789abcdef0123456789abcdef"
+	tests := []struct {
+		name string
+		opts []Option
+		ids  []interface{}
+		want bson.M
+	}{
+		{"no ID", nil, nil, bson.M{"_id": bson.M{"$in": bson.A{}}}},
+		{"one ID", nil, []interface{}{a}, bson.M{"_id": bson.M{"$in": bson.A{a, a.Hex()}}}},
+		{"several IDs", nil, []interface{}{a, b}, bson.M{"_id": bson.M{"$in": bson.A{a, a.Hex(), b, b.Hex()}}}},
+		{"primary key field", []Option{WithPrimaryKeyField("sid")}, []interface{}{a}, bson.M{"sid": bson.M{"$in": bson.A{a, a.Hex()}}}},
+		{"string ID", []Option{WithStringIDs(true)}, []interface{}{token}, bson.M{"_id": bson.M{"$in": bson.A{token}}}},
+		{"hexadecimal ObjectID string ID", []Option{WithStringIDs(true)}, []interface{}{a.Hex()}, bson.M{"_id": bson.M{"$in": bson.A{a.Hex(), a}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newUnitStore(t, tt.opts...)
+			if got := s.idFilter(tt.ids...); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("idFilter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLegacyIDs(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name    string
+		op      func(s *MongoStore, id string) error
+		deleted bool
+	}{
+		{"eraseID", func(s *MongoStore, id string) error {
+			return s.eraseID(ctx, id)
+		}, true},
+		{"DeleteByIDs", func(s *MongoStore, id string) error {
+			_, err := s.DeleteByIDs(ctx, []string{id})
+			return err
+		}, true},
+		{"DeleteSessionsForUser", func(s *MongoStore, id string) error {
+			_, err := s.DeleteSessionsForUser(ctx, "alice")
+			return err
+		}, true},
+		{"TouchMany", func(s *MongoStore, id string) error {
+			_, err := s.TouchMany(ctx, []string{id})
+			return err
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore(t)
+			id := primitive.NewObjectID()
+			modifiedAt := time.Now().Add(-time.Hour).Truncate(time.Millisecond)
+			_, err := s.collection.InsertOne(ctx, bson.M{
+				"_id":        id.Hex(),
+				"name":       "test",
+				"data":       "",
+				"userID":     "alice",
+				"modifiedAt": modifiedAt,
+				"expiresAt":  time.Now().Add(time.Hour),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := tt.op(s, id.Hex()); err != nil {
+				t.Fatal(err)
+			}
+			var doc Session
+			err = s.decodeResult(s.collection.FindOne(ctx, bson.M{"_id": id.Hex()}), &doc)
+			if tt.deleted {
+				if err == nil {
+					t.Fatalf("legacy document was not deleted")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !doc.ModifiedAt.After(modifiedAt) {
+				t.Fatalf("legacy document was not touched: modifiedAt = %s", doc.ModifiedAt)
+			}
+		})
+	}
+}
+
+func TestParseID(t *testing.T) {
+	id := primitive.NewObjectID()
+	tests := []struct {
+		name    string
+		opts    []Option
+		id      string
+		want    interface{}
+		wantErr bool
+	}{
+		{"hexadecimal", nil, id.Hex(), id, false},
+		{"base64", []Option{WithIDEncoding(Base64Encoding)}, base64.RawURLEncoding.EncodeToString(id[:]), id, false},
+		{"empty", nil, "", nil, true},
+		{"too long", nil, id.Hex() + "00", nil, true},
+		{"not hexadecimal", nil, "zzzzzzzzzzzzzzzzzzzzzzzz", nil, true},
+		{"32-character token", nil, "0123456789abcdef0123456789abcdef", nil, true},
+		{"string ID token", []Option{WithStringIDs(true)}, "0123456789abcdef0123456789abcdef", "0123456789abcdef0123456789abcdef", false},
+		{"string ID of any form", []Option{WithStringIDs(true)}, "not/an:id", "not/an:id", false},
+		{"empty string ID", []Option{WithStringIDs(true)}, "", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newUnitStore(t, tt.opts...)
+			got, err := s.parseID(tt.id)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidID) {
+					t.Fatalf("parseID(%q) error = %v, want ErrInvalidID", tt.id, err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("parseID(%q) = %v, %v, want %v", tt.id, got, err, tt.want)
+			}
+			if back := s.sessionID(got); back != tt.id {
+				t.Fatalf("sessionID(%v) = %q, want %q", got, back, tt.id)
+			}
+		})
+	}
+}
+
+func TestNewSessionID(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   []Option
+		length int
+	}{
+		{"ObjectID", nil, 24},
+		{"base64 ObjectID", []Option{WithIDEncoding(Base64Encoding)}, 16},
+		{"string ID", []Option{WithStringIDs(true)}, 32},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newUnitStore(t, tt.opts...)
+			id, err := s.newSessionID()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(id) != tt.length {
+				t.Fatalf("newSessionID() = %q, want %d characters", id, tt.length)
+			}
+			if _, err := s.parseID(id); err != nil {
+				t.Fatalf("parseID(%q) = %v", id, err)
+			}
+		})
+	}
+}
+
+func TestMinIDEntropy(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []Option
+		wantErr bool
+	}{
+		{"no minimum", nil, false},
+		{"96 bits", []Option{WithMinIDEntropy(96)}, false},
+		{"96 random bits", []Option{WithMinIDEntropy(96), WithIDGenerator(RandomObjectID)}, false},
+		{"more than 96 bits", []Option{WithMinIDEntropy(97)}, true},
+		{"more than 96 random bits", []Option{WithMinIDEntropy(128), WithIDGenerator(RandomObjectID)}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewMongoStoreWithOptions(newUnitStore(t).collection, nil, testKeyPairs, tt.opts...)
+			if (err != nil) != tt.wantErr || (err != nil && !errors.Is(err, ErrWeakSessionID)) {
+				t.Fatalf("NewMongoStoreWithOptions() = %v, want ErrWeakSessionID: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestIDGenerator(t *testing.T) {
+	generated := primitive.NewObjectID()
+	errGenerator := errors.New("entropy source failed")
+	tests := []struct {
+		name    string
+		gen     func() (primitive.ObjectID, error)
+		want    string
+		wantErr error
+	}{
+		{"generated ID", func() (primitive.ObjectID, error) { return generated, nil }, generated.Hex(), nil},
+		{"zero ID", func() (primitive.ObjectID, error) { return primitive.NilObjectID, nil }, "", ErrWeakSessionID},
+		{"failing generator", func() (primitive.ObjectID, error) { return primitive.NilObjectID, errGenerator }, "", errGenerator},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newUnitStore(t, WithIDGenerator(tt.gen))
+			id, err := s.newSessionID()
+			if !errors.Is(err, tt.wantErr) || id != tt.want {
+				t.Fatalf("newSessionID() = %q, %v, want %q, %v", id, err, tt.want, tt.wantErr)
+			}
+			if tt.wantErr == nil {
+				return
+			}
+			// Saving a new session fails before reaching the database.
+			session := sessions.NewSession(s, "test")
+			session.Options = s.sessionOptions(session.Name())
+			w := httptest.NewRecorder()
+			if err := s.Save(httptest.NewRequest(http.MethodGet, "/", nil), w, session); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Save() = %v, want %v", err, tt.wantErr)
+			}
+			if session.ID != "" || len(w.Result().Cookies()) != 0 {
+				t.Fatalf("Save() left ID %q and cookies %v, want none", session.ID, w.Result().Cookies())
+			}
+		})
+	}
+}
+
+func TestStringIDsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []Option
+		wantErr bool
+	}{
+		{"string IDs", []Option{WithStringIDs(true)}, false},
+		{"128 bits of entropy", []Option{WithStringIDs(true), WithMinIDEntropy(128)}, false},
+		{"more than 128 bits of entropy", []Option{WithStringIDs(true), WithMinIDEntropy(129)}, true},
+		{"with an ID generator", []Option{WithStringIDs(true), WithIDGenerator(RandomObjectID)}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewMongoStore(newUnitStore(t).collection, nil, testKeyPairs...)
+			for _, opt := range tt.opts {
+				opt(s)
+			}
+			if err := s.Validate(); (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() = %v, want error: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDecodeStringKeyed(t *testing.T) {
+	objID := primitive.NewObjectID()
+	tests := []struct {
+		name    string
+		key     interface{}
+		want    string
+		wantErr bool
+	}{
+		{"string key", "0123456789abcdef0123456789abcdef", "0123456789abcdef0123456789abcdef", false},
+		{"ObjectID key", objID, objID.Hex(), false},
+		{"empty key", "", "", true},
+		{"numeric key", 42, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newUnitStore(t, WithStringIDs(true))
+			raw, err := bson.Marshal(bson.M{"_id": tt.key, "name": "test", "modifiedAt": time.Now()})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var doc Session
+			err = s.decodeDocument(raw, &doc)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeDocument() = %v, want error: %v", err, tt.wantErr)
+			}
+			if err == nil && (doc.Key != tt.want || s.documentID(&doc) != tt.want || doc.Name != "test") {
+				t.Fatalf("decoded key %q, name %q, want %q", doc.Key, doc.Name, tt.want)
+			}
+		})
+	}
+}
+
+func TestStringIDs(t *testing.T) {
+	token := "0123456789abcdef0123456789abcdef"
+	tests := []struct {
+		name string
+		id   string
+	}{
+		{"preset 32-character token", token},
+		{"generated ID", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore(t, WithStringIDs(true))
+			session := sessions.NewSession(s, "test")
+			session.Options = s.sessionOptions(session.Name())
+			session.ID = tt.id
+			session.Values["user"] = "alice"
+			value := saveSession(t, s, session)
+			if tt.id != "" && session.ID != tt.id {
+				t.Fatalf("session saved as %q, want %q", session.ID, tt.id)
+			}
+			n, err := s.collection.CountDocuments(context.Background(), bson.M{"_id": session.ID})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != 1 {
+				t.Fatalf("%d documents keyed by %q, want 1", n, session.ID)
+			}
+			loaded := loadSession(t, s, "test", value)
+			if loaded.IsNew || loaded.ID != session.ID || loaded.Values["user"] != "alice" {
+				t.Fatalf("loaded session %q (new: %v) with %v", loaded.ID, loaded.IsNew, loaded.Values)
+			}
+		})
+	}
+}
+
+func TestPrimaryKeyField(t *testing.T) {
+	tests := []struct {
+		name  string
+		field string
+	}{
+		{"_id", "_id"},
+		{"sessionId", "sessionId"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := newTestStore(t, WithPrimaryKeyField(tt.field))
+			if err := s.EnsureIndexes(ctx); err != nil {
+				t.Fatal(err)
+			}
+			session := sessions.NewSession(s, "test")
+			session.Options = s.sessionOptions(session.Name())
+			session.Values["user"] = "alice"
+			value := saveSession(t, s, session)
+			objID, err := s.docID(session.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var stored bson.M
+			if err := s.collection.FindOne(ctx, bson.M{tt.field: objID}).Decode(&stored); err != nil {
+				t.Fatalf("no document with %s %s: %v", tt.field, session.ID, err)
+			}
+			if tt.field != "_id" && stored["_id"] == objID {
+				t.Fatalf("_id is the session ID, want one generated by MongoDB")
+			}
+			loaded := loadSession(t, s, "test", value)
+			if loaded.IsNew || loaded.ID != session.ID || loaded.Values["user"] != "alice" {
+				t.Fatalf("loaded session %q (new: %v) with %v", loaded.ID, loaded.IsNew, loaded.Values)
+			}
+			loaded.Values["user"] = "bob"
+			saveSession(t, s, loaded)
+			if n, err := s.collection.CountDocuments(ctx, bson.M{}); err != nil || n != 1 {
+				t.Fatalf("%d documents after saving again, %v, want 1", n, err)
+			}
+			if err := s.eraseID(ctx, session.ID); err != nil {
+				t.Fatal(err)
+			}
+			if n, err := s.collection.CountDocuments(ctx, bson.M{}); err != nil || n != 0 {
+				t.Fatalf("%d documents after erasing, %v, want 0", n, err)
+			}
+		})
+	}
+}
+
+func TestIDEncoding(t *testing.T) {
+	tests := []struct {
+		name     string
+		encoding IDEncoding
+		length   int
+	}{
+		{"hexadecimal", HexEncoding, 24},
+		{"base64", Base64Encoding, 16},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := newTestStore(t, WithIDEncoding(tt.encoding))
+			session := sessions.NewSession(s, "test")
+			session.Options = s.sessionOptions(session.Name())
+			session.Values["user"] = "alice"
+			value := saveSession(t, s, session)
+			if len(session.ID) != tt.length {
+				t.Fatalf("session saved with ID %q, want %d characters", session.ID, tt.length)
+			}
+			objID, err := s.docID(session.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := objID.(primitive.ObjectID); !ok {
+				t.Fatalf("document ID %v is a %T, want an ObjectID", objID, objID)
+			}
+			if n, err := s.collection.CountDocuments(ctx, bson.M{"_id": objID}); err != nil || n != 1 {
+				t.Fatalf("%d documents keyed by the ObjectID, %v, want 1", n, err)
+			}
+			loaded := loadSession(t, s, "test", value)
+			if loaded.IsNew || loaded.ID != session.ID || loaded.Values["user"] != "alice" {
+				t.Fatalf("loaded session %q (new: %v) with %v", loaded.ID, loaded.IsNew, loaded.Values)
+			}
+			if err := s.eraseID(ctx, loaded.ID); err != nil {
+				t.Fatal(err)
+			}
+			if n, err := s.collection.CountDocuments(ctx, bson.M{}); err != nil || n != 0 {
+				t.Fatalf("%d documents after erasing, %v, want 0", n, err)
+			}
+		})
+	}
+}
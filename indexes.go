@@ -0,0 +1,137 @@
+package mongostore
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ExpiresAtIndexName is the name of the TTL index created by EnsureIndexes.
+const ExpiresAtIndexName = "expiresAt_1"
+
+// EnsureIndexes creates the indexes used by the store, if they do not exist:
+// a TTL index on expiresAt, which makes MongoDB delete expired sessions, and
+// an index on userID for the per-user administrative methods, a sparse index
+// on refreshTokenHash for FindByRefreshToken, a unique index
+// on the primary key field set with WithPrimaryKeyField, if any, a unique
+// index on userID and name with WithUniquePerUserAndName, and a unique index
+// on the parent and number of chunks with WithChunking.
+//
+// Index creation is bounded by the timeout set with WithIndexTimeout, on top
+// of any deadline of ctx. A timed out call returns an error wrapping
+// context.DeadlineExceeded; note that the server may keep building the
+// indexes in the background after the client gave up.
+func (s *MongoStore) EnsureIndexes(ctx context.Context) error {
+	if s.collection == nil {
+		return ErrNoCollection
+	}
+	if s.indexTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.indexTimeout)
+		defer cancel()
+	}
+	models := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+			Options: options.Index().SetName(ExpiresAtIndexName).SetExpireAfterSeconds(0),
+		},
+		{
+			Keys:    bson.D{{Key: "userID", Value: 1}},
+			Options: options.Index().SetName(UserIDIndexName).SetCollation(s.indexCollation),
+		},
+		{
+			Keys:    bson.D{{Key: "refreshTokenHash", Value: 1}},
+			Options: options.Index().SetName(RefreshTokenIndexName).SetSparse(true),
+		},
+	}
+	if s.uniquePerUserAndName {
+		models = append(models, mongo.IndexModel{
+			Keys: bson.D{{Key: "userID", Value: 1}, {Key: "name", Value: 1}},
+			Options: options.Index().SetName(UserIDNameIndexName).SetUnique(true).
+				SetPartialFilterExpression(bson.M{"userID": bson.M{"$type": "string"}}).
+				SetCollation(s.indexCollation),
+		})
+	}
+	if s.chunkSize > 0 {
+		models = append(models, mongo.IndexModel{
+			Keys: bson.D{{Key: "chunkOf", Value: 1}, {Key: "gen", Value: 1}, {Key: "n", Value: 1}},
+			Options: options.Index().SetName(ChunkIndexName).SetUnique(true).
+				SetPartialFilterExpression(bson.M{"chunkOf": bson.M{"$exists": true}}),
+		})
+	}
+	if field := s.keyField(); field != "_id" {
+		models = append(models, mongo.IndexModel{
+			Keys:    bson.D{{Key: field, Value: 1}},
+			Options: options.Index().SetUnique(true).SetSparse(true),
+		})
+	}
+	if _, err := s.collection.Indexes().CreateMany(ctx, models); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return fmt.Errorf("mongostore: could not create indexes: %w", ctxErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// UpdateTTL sets the maximum age of the store sessions to newMaxAge seconds,
+// like MaxAge, and applies it to the stored sessions: their expiry date is
+// capped to newMaxAge seconds after their last modification, and TTL indexes
+// on modifiedAt, as created by older setups, are changed to expire documents
+// after newMaxAge seconds. The TTL index on expiresAt created by EnsureIndexes
+// does not depend on the maximum age.
+//
+// Changing a TTL index runs the collMod command, which requires the collMod
+// privilege on the collection. Chunks of chunked sessions (see WithChunking)
+// keep their expiry date, and outlive their session until then.
+func (s *MongoStore) UpdateTTL(ctx context.Context, newMaxAge int) error {
+	if s.collection == nil {
+		return ErrNoCollection
+	}
+	s.MaxAge(newMaxAge)
+	if newMaxAge <= 0 {
+		return nil
+	}
+	ctx, cancel := s.adminContext(ctx)
+	defer cancel()
+
+	maxAge := time.Duration(newMaxAge) * time.Second
+	capped := bson.M{"$add": bson.A{"$modifiedAt", maxAge.Milliseconds()}}
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.M{
+		"expiresAt": bson.M{"$min": bson.A{bson.M{"$ifNull": bson.A{"$expiresAt", capped}}, capped}},
+	}}}}
+	filter := bson.M{"chunkOf": bson.M{"$exists": false}, "modifiedAt": bson.M{"$exists": true}}
+	if _, err := s.collection.UpdateMany(ctx, filter, update); err != nil {
+		return fmt.Errorf("mongostore: could not update session expiry dates: %w", err)
+	}
+
+	cur, err := s.collection.Indexes().List(ctx)
+	if err != nil {
+		return err
+	}
+	var indexes []struct {
+		Keys               bson.D   `bson:"key"`
+		ExpireAfterSeconds *float64 `bson:"expireAfterSeconds"`
+	}
+	if err := cur.All(ctx, &indexes); err != nil {
+		return err
+	}
+	for _, index := range indexes {
+		if index.ExpireAfterSeconds == nil || *index.ExpireAfterSeconds == float64(newMaxAge) ||
+			len(index.Keys) != 1 || index.Keys[0].Key != "modifiedAt" {
+			continue
+		}
+		cmd := bson.D{
+			{Key: "collMod", Value: s.collection.Name()},
+			{Key: "index", Value: bson.M{"keyPattern": index.Keys, "expireAfterSeconds": newMaxAge}},
+		}
+		if err := s.collection.Database().RunCommand(ctx, cmd).Err(); err != nil {
+			return fmt.Errorf("mongostore: could not update TTL index on modifiedAt: %w", err)
+		}
+	}
+	return nil
+}
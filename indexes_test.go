@@ -0,0 +1,176 @@
+package mongostore
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestIndexCollation(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name  string
+		check func(t *testing.T, s *MongoStore)
+	}{
+		{"ListSessionsForUser", func(t *testing.T, s *MongoStore) {
+			metas, err := s.ListSessionsForUser(ctx, "User@Example.com")
+			if err != nil || len(metas) != 2 {
+				t.Fatalf("ListSessionsForUser() = %d sessions, %v, want 2", len(metas), err)
+			}
+		}},
+		{"SessionCountsByUser", func(t *testing.T, s *MongoStore) {
+			counts, err := s.SessionCountsByUser(ctx, 0)
+			if err != nil || len(counts) != 1 || counts[0].Count != 2 {
+				t.Fatalf("SessionCountsByUser() = %v, %v, want a single user with 2 sessions", counts, err)
+			}
+		}},
+		{"DistinctNames", func(t *testing.T, s *MongoStore) {
+			names, err := s.DistinctNames(ctx)
+			if want := []string{"Test", "test"}; err != nil || !reflect.DeepEqual(names, want) {
+				t.Fatalf("DistinctNames() = %v, %v, want %v", names, err, want)
+			}
+		}},
+		{"DeleteSessionsForUser", func(t *testing.T, s *MongoStore) {
+			deleted, err := s.DeleteSessionsForUser(ctx, "User@Example.com")
+			if err != nil || deleted != 2 {
+				t.Fatalf("DeleteSessionsForUser() = %d, %v, want 2", deleted, err)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore(t, WithIndexCollation(&options.Collation{Locale: "en", Strength: 2}))
+			if err := s.EnsureIndexes(ctx); err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range []string{"test", "Test"} {
+				session := sessions.NewSession(s, name)
+				session.Options = s.sessionOptions(name)
+				session.Values["userID"] = "user@example.com"
+				saveSession(t, s, session)
+			}
+			tt.check(t, s)
+		})
+	}
+}
+
+func TestIndexTimeout(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		opts    []Option
+		wantErr error
+	}{
+		{"already cancelled context", cancelled, nil, context.Canceled},
+		{"already cancelled context with an index timeout", cancelled, []Option{WithIndexTimeout(time.Minute)}, context.Canceled},
+		{"index timeout", context.Background(), []Option{WithIndexTimeout(50 * time.Millisecond)}, context.DeadlineExceeded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The client is connected to an address nothing listens on, so
+			// that index creation waits for a server until it times out.
+			client, err := mongo.Connect(context.Background(), options.Client().ApplyURI("mongodb://127.0.0.1:1"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Disconnect(context.Background())
+			s, err := NewMongoStoreWithOptions(client.Database("mongostore_test").Collection("sessions"), nil, testKeyPairs, tt.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			start := time.Now()
+			if err := s.EnsureIndexes(tt.ctx); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("EnsureIndexes() = %v, want %v", err, tt.wantErr)
+			}
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Fatalf("EnsureIndexes() returned after %s", elapsed)
+			}
+		})
+	}
+}
+
+func TestUpdateTTL(t *testing.T) {
+	tests := []struct {
+		name      string
+		maxAge    int
+		wantCap   bool
+		wantIndex float64
+	}{
+		{"shorter maximum age", 60, true, 60},
+		{"longer maximum age", 7200, false, 7200},
+		{"same maximum age", 3600, false, 3600},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := newTestStore(t)
+			s.MaxAge(3600)
+			// A TTL index on modifiedAt, as created by older setups.
+			_, err := s.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys:    bson.D{{Key: "modifiedAt", Value: 1}},
+				Options: options.Index().SetExpireAfterSeconds(3600),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			session := sessions.NewSession(s, "test")
+			session.Options = s.sessionOptions(session.Name())
+			saveSession(t, s, session)
+			var before Session
+			if err := s.decodeResult(s.collection.FindOne(ctx, bson.M{}), &before); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := s.UpdateTTL(ctx, tt.maxAge); err != nil {
+				t.Fatal(err)
+			}
+			if s.Options.MaxAge != tt.maxAge {
+				t.Fatalf("store MaxAge = %d, want %d", s.Options.MaxAge, tt.maxAge)
+			}
+			cur, err := s.collection.Indexes().List(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var indexes []struct {
+				Keys               bson.D   `bson:"key"`
+				ExpireAfterSeconds *float64 `bson:"expireAfterSeconds"`
+			}
+			if err := cur.All(ctx, &indexes); err != nil {
+				t.Fatal(err)
+			}
+			found := false
+			for _, index := range indexes {
+				if len(index.Keys) == 1 && index.Keys[0].Key == "modifiedAt" {
+					found = true
+					if index.ExpireAfterSeconds == nil || *index.ExpireAfterSeconds != tt.wantIndex {
+						t.Fatalf("modifiedAt index expires after %v seconds, want %v", index.ExpireAfterSeconds, tt.wantIndex)
+					}
+				}
+			}
+			if !found {
+				t.Fatal("modifiedAt index not found")
+			}
+
+			var after Session
+			if err := s.decodeResult(s.collection.FindOne(ctx, bson.M{}), &after); err != nil {
+				t.Fatal(err)
+			}
+			want := before.ExpiresAt
+			if tt.wantCap {
+				want = before.ModifiedAt.Add(time.Duration(tt.maxAge) * time.Second)
+			}
+			if !after.ExpiresAt.Equal(want) {
+				t.Fatalf("expiresAt = %s, want %s", after.ExpiresAt, want)
+			}
+		})
+	}
+}
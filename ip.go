@@ -0,0 +1,118 @@
+package mongostore
+
+import (
+	"context"
+	"net"
+	"net/http"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RemoteAddrIP returns the host part of r.RemoteAddr. It can be passed to
+// WithIPCapture when the application is not behind a proxy.
+func RemoteAddrIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// DeleteSessionsByIPPrefix deletes every session whose captured IP address is
+// within cidr, and returns the number of deleted sessions. Both IPv4 and IPv6
+// ranges are supported. Only sessions saved with WithIPCapture enabled can be
+// matched. Sessions are deleted like by DeleteByIDs, in batches, and archived
+// as erased with WithArchiveCollection.
+func (s *MongoStore) DeleteSessionsByIPPrefix(ctx context.Context, cidr string) (int64, error) {
+	if s.collection == nil {
+		return 0, ErrNoCollection
+	}
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return 0, err
+	}
+	// Buffered saves may capture addresses within cidr.
+	if err := s.Flush(ctx); err != nil {
+		return 0, err
+	}
+	first, last := ipRange(ipNet)
+	filter := bson.M{"ip": bson.M{"$gte": ipBinary(first), "$lte": ipBinary(last)}}
+	opts := options.Find().SetLimit(defaultBatchSize)
+	if s.archiveCollection == nil {
+		opts.SetProjection(bson.M{s.keyField(): 1})
+	}
+	var deleted int64
+	for {
+		// Deleted sessions no longer match: each batch starts over.
+		docs, ids, err := s.findRevoked(ctx, filter, opts)
+		if err != nil {
+			return deleted, err
+		}
+		if s.archiveCollection != nil {
+			s.archive(ctx, archiveErased, docs...)
+		}
+		n, err := s.deleteDocuments(ctx, ids)
+		deleted += n
+		if err != nil {
+			return deleted, err
+		}
+		if len(ids) < defaultBatchSize || n == 0 {
+			return deleted, nil
+		}
+	}
+}
+
+// findRevoked returns the session documents matching filter, along with their
+// document IDs.
+func (s *MongoStore) findRevoked(ctx context.Context, filter bson.M, opts *options.FindOptions) ([]bson.Raw, []interface{}, error) {
+	cur, err := s.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer cur.Close(ctx)
+
+	var docs []bson.Raw
+	var ids []interface{}
+	for cur.Next(ctx) {
+		raw := make(bson.Raw, len(cur.Current))
+		copy(raw, cur.Current)
+		var doc Session
+		if err := s.decodeDocument(raw, &doc); err != nil {
+			return nil, nil, err
+		}
+		docs = append(docs, raw)
+		ids = append(ids, s.documentID(&doc))
+	}
+	return docs, ids, cur.Err()
+}
+
+// ipRange returns the first and last 16-byte addresses of n. IPv4 networks
+// are mapped to the IPv4-mapped IPv6 range so that they compare like the
+// addresses stored by save.
+func ipRange(n *net.IPNet) (first, last net.IP) {
+	ones, bits := n.Mask.Size()
+	if bits == 8*net.IPv4len {
+		ones += 8 * (net.IPv6len - net.IPv4len)
+	}
+	mask := net.CIDRMask(ones, 8*net.IPv6len)
+	ip := n.IP.To16()
+	first = make(net.IP, net.IPv6len)
+	last = make(net.IP, net.IPv6len)
+	for i := range ip {
+		first[i] = ip[i] & mask[i]
+		last[i] = ip[i] | ^mask[i]
+	}
+	return first, last
+}
+
+// ipBinary returns the normalized binary form of ip stored in session
+// documents, or nil if ip is invalid.
+func ipBinary(ip net.IP) *primitive.Binary {
+	ip16 := ip.To16()
+	if ip16 == nil {
+		return nil
+	}
+	return &primitive.Binary{Data: ip16}
+}
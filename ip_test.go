@@ -0,0 +1,97 @@
+package mongostore
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestDeleteSessionsByIPPrefix(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []Option
+		archive bool
+		cidr    string
+		deleted int64
+	}{
+		{"matching range", nil, false, "192.0.2.0/24", 1},
+		{"other range", nil, false, "198.51.100.0/24", 0},
+		{"IPv6 range", nil, false, "2001:db8::/32", 0},
+		{"with a shared cache", []Option{WithSharedCache(NewMemoryCache(), time.Minute)}, false, "192.0.2.0/24", 1},
+		{"with a write buffer", []Option{WithWriteBuffer(10, 0)}, false, "192.0.2.0/24", 1},
+		{"with chunking", []Option{WithChunking(16)}, false, "192.0.2.0/24", 1},
+		{"archived", nil, true, "192.0.2.0/24", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			c := newTestCollection(t)
+			opts := append([]Option{WithIPCapture(RemoteAddrIP)}, tt.opts...)
+			archive := c.Database().Collection(c.Name() + "_archive")
+			if tt.archive {
+				t.Cleanup(func() { _ = archive.Drop(context.Background()) })
+				opts = append(opts, WithArchiveCollection(archive))
+			}
+			s, err := NewMongoStoreWithOptions(c, nil, testKeyPairs, opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// httptest requests come from 192.0.2.1.
+			session := sessions.NewSession(s, "test")
+			session.Options = s.sessionOptions(session.Name())
+			session.Values["user"] = "alice and a value long enough to be chunked"
+			value := saveSession(t, s, session)
+
+			// The loaded session is cached, and its next save buffered.
+			loaded := loadSession(t, s, "test", value)
+			loaded.Values["user"] = "bob"
+			saveSession(t, s, loaded)
+
+			n, err := s.DeleteSessionsByIPPrefix(ctx, tt.cidr)
+			if err != nil || n != tt.deleted {
+				t.Fatalf("DeleteSessionsByIPPrefix(%q) = %d, %v, want %d", tt.cidr, n, err, tt.deleted)
+			}
+			if err := s.Flush(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if again := loadSession(t, s, "test", value); again.IsNew != (tt.deleted > 0) {
+				t.Fatalf("session loaded after revocation (new: %v)", again.IsNew)
+			}
+			if n, err := c.CountDocuments(ctx, bson.M{}); err != nil || n != 1-tt.deleted {
+				t.Fatalf("%d documents left, %v, want %d", n, err, 1-tt.deleted)
+			}
+			if tt.archive {
+				if n, err := archive.CountDocuments(ctx, bson.M{"archiveReason": archiveErased}); err != nil || n != tt.deleted {
+					t.Fatalf("%d archived sessions, %v, want %d", n, err, tt.deleted)
+				}
+			}
+		})
+	}
+}
+
+func TestIPRange(t *testing.T) {
+	tests := []struct {
+		cidr        string
+		first, last string
+	}{
+		{"192.0.2.0/24", "192.0.2.0", "192.0.2.255"},
+		{"192.0.2.128/25", "192.0.2.128", "192.0.2.255"},
+		{"2001:db8::/32", "2001:db8::", "2001:db8:ffff:ffff:ffff:ffff:ffff:ffff"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.cidr, func(t *testing.T) {
+			_, n, err := net.ParseCIDR(tt.cidr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			first, last := ipRange(n)
+			if !first.Equal(net.ParseIP(tt.first)) || !last.Equal(net.ParseIP(tt.last)) {
+				t.Fatalf("ipRange(%s) = %s, %s, want %s, %s", tt.cidr, first, last, tt.first, tt.last)
+			}
+		})
+	}
+}
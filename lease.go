@@ -0,0 +1,91 @@
+package mongostore
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Lease atomically acquires an exclusive lease on the session with the given
+// ID for ttl, and returns the loaded session along with a function releasing
+// the lease. It is meant for flows where a single request at a time may
+// process a session, e.g. to consume a one-time token. Leases only exclude
+// other calls to Lease: loading and saving the session are not affected.
+//
+// It returns ErrSessionLeased if the session is already leased, and
+// ErrSessionNotFound if it does not exist. Leases expire after ttl even if
+// they are never released. Close waits for outstanding leases to be
+// released.
+func (s *MongoStore) Lease(ctx context.Context, id string, ttl time.Duration) (*sessions.Session, func() error, error) {
+	if s.collection == nil {
+		return nil, nil, ErrNoCollection
+	}
+	objID, err := s.docID(id)
+	if err != nil {
+		return nil, nil, ErrSessionNotFound
+	}
+	untrack, ok := s.lifecycle.track()
+	if !ok {
+		return nil, nil, ErrStoreClosed
+	}
+	now := time.Now()
+	token := primitive.NewObjectID()
+	scope := s.scopeFilter(ctx, s.idFilter(objID))
+	filter := bson.M{"$and": bson.A{scope, bson.M{"$or": bson.A{
+		bson.M{"lockedUntil": bson.M{"$exists": false}},
+		bson.M{"lockedUntil": bson.M{"$lte": now}},
+	}}}}
+	update := bson.M{"$set": bson.M{"lockedUntil": now.Add(ttl), "lockToken": token}}
+	var doc Session
+	err = s.decodeResult(s.collection.FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate()), &doc)
+	if err != nil {
+		untrack()
+	}
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		n, countErr := s.collection.CountDocuments(ctx, scope)
+		switch {
+		case countErr != nil:
+			return nil, nil, countErr
+		case n > 0:
+			return nil, nil, ErrSessionLeased
+		}
+		return nil, nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	release := func() error {
+		defer untrack()
+		filter := bson.M{"$and": bson.A{scope, bson.M{"lockToken": token}}}
+		_, err := s.collection.UpdateOne(context.Background(), filter,
+			bson.M{"$unset": bson.M{"lockedUntil": "", "lockToken": ""}})
+		return err
+	}
+	if err := s.assembleChunks(ctx, &doc); err != nil {
+		if releaseErr := release(); releaseErr != nil {
+			s.log(ctx).Errorf("mongostore: could not release lease of session %s: %v", id, releaseErr)
+		}
+		return nil, nil, err
+	}
+	session := sessions.NewSession(s, doc.Name)
+	session.Options = s.sessionOptions(session.Name())
+	session.ID = id
+	if err := s.loadDocument(ctx, session, &doc); err != nil {
+		if releaseErr := release(); releaseErr != nil {
+			s.log(ctx).Errorf("mongostore: could not release lease of session %s: %v", id, releaseErr)
+		}
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			err = ErrSessionNotFound
+		}
+		return nil, nil, err
+	}
+	session.IsNew = false
+	return session, release, nil
+}
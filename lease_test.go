@@ -0,0 +1,117 @@
+package mongostore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestLease(t *testing.T) {
+	tests := []struct {
+		name    string
+		ttl     time.Duration
+		release bool
+		wait    time.Duration
+		wantErr error
+	}{
+		{"held lease", time.Minute, false, 0, ErrSessionLeased},
+		{"released lease", time.Minute, true, 0, nil},
+		{"expired lease", 50 * time.Millisecond, false, 100 * time.Millisecond, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := newTestStore(t)
+			session := sessions.NewSession(s, "test")
+			session.Options = s.sessionOptions(session.Name())
+			session.Values["token"] = "one-time"
+			saveSession(t, s, session)
+			leased, release, err := s.Lease(ctx, session.ID, tt.ttl)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if leased.ID != session.ID || leased.Values["token"] != "one-time" {
+				t.Fatalf("leased session %q with %v", leased.ID, leased.Values)
+			}
+			if tt.release {
+				if err := release(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			time.Sleep(tt.wait)
+			_, again, err := s.Lease(ctx, session.ID, time.Minute)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("second Lease() = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil {
+				if err := again(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if !tt.release {
+				if err := release(); err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestLeaseNotFound(t *testing.T) {
+	s := newTestStore(t)
+	if _, _, err := s.Lease(context.Background(), primitive.NewObjectID().Hex(), time.Minute); !errors.Is(err, ErrSessionNotFound) {
+		t.Fatalf("Lease() = %v, want ErrSessionNotFound", err)
+	}
+}
+
+func TestLeaseContention(t *testing.T) {
+	for _, workers := range []int{2, 8, 32} {
+		t.Run(fmt.Sprint(workers, " workers"), func(t *testing.T) {
+			ctx := context.Background()
+			s := newTestStore(t)
+			session := sessions.NewSession(s, "test")
+			session.Options = s.sessionOptions(session.Name())
+			saveSession(t, s, session)
+			var (
+				wg       sync.WaitGroup
+				mu       sync.Mutex
+				acquired int
+				releases []func() error
+			)
+			start := make(chan struct{})
+			for i := 0; i < workers; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					<-start
+					_, release, err := s.Lease(ctx, session.ID, time.Minute)
+					mu.Lock()
+					defer mu.Unlock()
+					switch {
+					case err == nil:
+						acquired++
+						releases = append(releases, release)
+					case !errors.Is(err, ErrSessionLeased):
+						t.Errorf("Lease() = %v, want nil or ErrSessionLeased", err)
+					}
+				}()
+			}
+			close(start)
+			wg.Wait()
+			if acquired != 1 {
+				t.Fatalf("%d of %d workers acquired the lease, want 1", acquired, workers)
+			}
+			for _, release := range releases {
+				if err := release(); err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+	}
+}
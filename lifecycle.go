@@ -0,0 +1,75 @@
+package mongostore
+
+import (
+	"context"
+	"sync"
+)
+
+// lifecycle tracks the background work of a store, so that Close can wait for
+// it to finish.
+type lifecycle struct {
+	mu     sync.Mutex
+	closed bool
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// doneChan returns a channel closed when the store is closed.
+func (l *lifecycle) doneChan() chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.done == nil {
+		l.done = make(chan struct{})
+	}
+	return l.done
+}
+
+// isClosed reports whether the store was closed.
+func (l *lifecycle) isClosed() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.closed
+}
+
+// track registers a unit of background work, and returns the function to
+// call when it is done. It reports false if the store is already closed.
+func (l *lifecycle) track() (func(), bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil, false
+	}
+	l.wg.Add(1)
+	var once sync.Once
+	return func() { once.Do(l.wg.Done) }, true
+}
+
+// Close stops the store: sessions can no longer be saved (Save returns
+// ErrStoreClosed), buffered saves are flushed (see WithWriteBuffer),
+// background goroutines started by the store are stopped, and Close waits
+// for them and for outstanding leases (see Lease) to be released, or for ctx
+// to be done, in which case it returns ctx.Err().
+//
+// Close can be called several times.
+func (s *MongoStore) Close(ctx context.Context) error {
+	done := s.lifecycle.doneChan()
+	s.lifecycle.mu.Lock()
+	if !s.lifecycle.closed {
+		s.lifecycle.closed = true
+		close(done)
+	}
+	s.lifecycle.mu.Unlock()
+	flushErr := s.Flush(ctx)
+
+	finished := make(chan struct{})
+	go func() {
+		s.lifecycle.wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return flushErr
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,90 @@
+package mongostore
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestClose(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(t *testing.T, s *MongoStore)
+		wantErr error
+	}{
+		{"idle store", func(t *testing.T, s *MongoStore) {}, nil},
+		{"garbage collection goroutine", func(t *testing.T, s *MongoStore) {
+			if err := s.StartGC(context.Background(), time.Hour); err != nil {
+				t.Fatal(err)
+			}
+		}, nil},
+		{"released lease", func(t *testing.T, s *MongoStore) {
+			untrack, _ := s.lifecycle.track()
+			untrack()
+		}, nil},
+		{"outstanding lease", func(t *testing.T, s *MongoStore) {
+			s.lifecycle.track()
+		}, context.DeadlineExceeded},
+		{"closed twice", func(t *testing.T, s *MongoStore) {
+			if err := s.Close(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+		}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newUnitStore(t)
+			tt.setup(t, s)
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			if err := s.Close(ctx); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Close() = %v, want %v", err, tt.wantErr)
+			}
+			session := sessions.NewSession(s, "test")
+			session.Options = s.sessionOptions(session.Name())
+			if err := s.Save(httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRecorder(), session); !errors.Is(err, ErrStoreClosed) {
+				t.Fatalf("Save() after Close = %v, want ErrStoreClosed", err)
+			}
+			if err := s.StartGC(context.Background(), time.Hour); !errors.Is(err, ErrStoreClosed) {
+				t.Fatalf("StartGC() after Close = %v, want ErrStoreClosed", err)
+			}
+		})
+	}
+}
+
+func TestCloseFlushesWriteBuffer(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t, WithWriteBuffer(100, time.Hour))
+	session := sessions.NewSession(s, "test")
+	session.Options = s.sessionOptions(session.Name())
+	session.Values["user"] = "alice"
+	saveSession(t, s, session)
+	if n, err := s.collection.CountDocuments(ctx, bson.M{}); err != nil || n != 0 {
+		t.Fatalf("%d documents before Close, %v, want the save buffered", n, err)
+	}
+	if err := s.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := s.collection.CountDocuments(ctx, bson.M{}); err != nil || n != 1 {
+		t.Fatalf("%d documents after Close, %v, want 1", n, err)
+	}
+	session.Values["user"] = "bob"
+	if err := s.Save(httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRecorder(), session); !errors.Is(err, ErrStoreClosed) {
+		t.Fatalf("Save() after Close = %v, want ErrStoreClosed", err)
+	}
+	other, err := NewMongoStoreWithOptions(s.collection, nil, testKeyPairs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := sessions.NewSession(other, "test")
+	loaded.ID = session.ID
+	if err := other.load(ctx, loaded); err != nil || loaded.Values["user"] != "alice" {
+		t.Fatalf("stored values %v, %v, want the values saved before Close", loaded.Values, err)
+	}
+}
@@ -0,0 +1,52 @@
+package mongostore
+
+import "context"
+
+// Logger is the minimal leveled logging interface used by MongoStore. It can
+// be implemented on top of any logging library.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// nopLogger is the default Logger, which discards everything.
+type nopLogger struct{}
+
+func (nopLogger) Debugf(string, ...interface{}) {}
+func (nopLogger) Warnf(string, ...interface{})  {}
+func (nopLogger) Errorf(string, ...interface{}) {}
+
+// correlatedLogger is a Logger appending a correlation ID to messages.
+type correlatedLogger struct {
+	Logger
+	id string
+}
+
+func (l correlatedLogger) Debugf(format string, args ...interface{}) {
+	l.Logger.Debugf(format+" (correlation ID %s)", append(args, l.id)...)
+}
+
+func (l correlatedLogger) Warnf(format string, args ...interface{}) {
+	l.Logger.Warnf(format+" (correlation ID %s)", append(args, l.id)...)
+}
+
+func (l correlatedLogger) Errorf(format string, args ...interface{}) {
+	l.Logger.Errorf(format+" (correlation ID %s)", append(args, l.id)...)
+}
+
+// log returns the store logger for operations on behalf of ctx, which appends
+// the correlation ID of ctx to messages, if any (see
+// WithCorrelationIDExtractor).
+func (s *MongoStore) log(ctx context.Context) Logger {
+	if s.logger == nil {
+		return nopLogger{}
+	}
+	if s.correlationID == nil {
+		return s.logger
+	}
+	if id := s.correlationID(ctx); id != "" {
+		return correlatedLogger{Logger: s.logger, id: id}
+	}
+	return s.logger
+}
@@ -0,0 +1,82 @@
+package mongostore
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// recordingLogger is a Logger keeping the messages logged.
+type recordingLogger struct {
+	messages []string
+}
+
+func (l *recordingLogger) Debugf(format string, args ...interface{}) {
+	l.messages = append(l.messages, fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) Warnf(format string, args ...interface{}) {
+	l.messages = append(l.messages, fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) Errorf(format string, args ...interface{}) {
+	l.messages = append(l.messages, fmt.Sprintf(format, args...))
+}
+
+// requestIDKey is the context key of the correlation ID in TestCorrelatedLogs.
+type requestIDKey struct{}
+
+func TestCorrelatedLogs(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	tests := []struct {
+		name string
+		opts []Option
+		op   func(ctx context.Context, s *MongoStore)
+	}{
+		{"loadDocument", nil, func(ctx context.Context, s *MongoStore) {
+			s.loadDocument(ctx, sessions.NewSession(s, "test"), &Session{})
+		}},
+		{"loadData", nil, func(ctx context.Context, s *MongoStore) {
+			s.loadDocument(ctx, sessions.NewSession(s, "test"), &Session{ModifiedAt: time.Now(), Data: "invalid"})
+		}},
+		{"addCreationMetadata", []Option{WithCreationMetadata(func(*http.Request) bson.M {
+			return bson.M{"name": "reserved"}
+		})}, func(ctx context.Context, s *MongoStore) {
+			s.addCreationMetadata(ctx, r, bson.M{}, bson.M{}, bson.M{})
+		}},
+		{"decorateDocument", []Option{WithDocumentDecorator(func(r *http.Request, session *sessions.Session, doc bson.M) {
+			doc["expiresAt"] = time.Now()
+		})}, func(ctx context.Context, s *MongoStore) {
+			s.decorateDocument(ctx, r, sessions.NewSession(s, "test"), bson.M{})
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logger recordingLogger
+			opts := append([]Option{
+				WithLogger(&logger),
+				WithCorrelationIDExtractor(func(ctx context.Context) string {
+					id, _ := ctx.Value(requestIDKey{}).(string)
+					return id
+				}),
+			}, tt.opts...)
+			s := newUnitStore(t, opts...)
+			tt.op(context.WithValue(context.Background(), requestIDKey{}, "request-42"), s)
+			if len(logger.messages) == 0 {
+				t.Fatal("nothing was logged")
+			}
+			for _, msg := range logger.messages {
+				if !strings.HasSuffix(msg, "(correlation ID request-42)") {
+					t.Errorf("message %q has no correlation ID", msg)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package mongostore
+
+import (
+	"context"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// defaultBatchSize is the batch size of batched maintenance operations when
+// the given one is not positive.
+const defaultBatchSize = 100
+
+// MigrateSerialization rewrites the data of the sessions stored with another
+// serializer than the store's (see WithSerializer), in batches of batchSize
+// documents (or 100 if batchSize is not positive), and returns the number of
+// migrated sessions. Documents without a serializer field were written with
+// gob.
+//
+// The store serializer must be able to read the old data: JSONSerializer
+// reads gob data, for instance. Migration is idempotent, since migrated
+// documents no longer match, and can therefore be resumed after an
+// interruption. Sessions that cannot be decoded are skipped, and chunked
+// sessions (see WithChunking) are only migrated when they are next saved.
+func (s *MongoStore) MigrateSerialization(ctx context.Context, batchSize int) (int64, error) {
+	if s.collection == nil {
+		return 0, ErrNoCollection
+	}
+	ctx, cancel := s.adminContext(ctx)
+	defer cancel()
+	if batchSize <= 0 {
+		batchSize = defaultBatchSize
+	}
+	current := serializerName(s.serializer())
+	filter := bson.M{"serializer": bson.M{"$ne": current}, "chunkOf": bson.M{"$exists": false}, "chunks": bson.M{"$exists": false}, "values": bson.M{"$exists": false}}
+	if current == "gob" {
+		filter["serializer"] = bson.M{"$exists": true, "$ne": current}
+	}
+	opts := options.Find().SetSort(bson.D{{Key: s.keyField(), Value: 1}}).SetLimit(int64(batchSize))
+
+	var migrated int64
+	// Pages follow the keys, which sort after the minimal key of their type.
+	var lastID interface{} = primitive.NilObjectID
+	if s.stringIDs {
+		lastID = ""
+	}
+	for {
+		page := bson.M{"$and": bson.A{filter, bson.M{s.keyField(): bson.M{"$gt": lastID}}}}
+		cur, err := s.collection.Find(ctx, page, opts)
+		if err != nil {
+			return migrated, err
+		}
+		var docs []Session
+		for cur.Next(ctx) {
+			var doc Session
+			if err := s.decodeDocument(cur.Current, &doc); err != nil {
+				cur.Close(ctx)
+				return migrated, err
+			}
+			docs = append(docs, doc)
+		}
+		err = cur.Err()
+		cur.Close(ctx)
+		if err != nil {
+			return migrated, err
+		}
+		for i := range docs {
+			lastID = s.documentID(&docs[i])
+			ok, err := s.migrateDocument(ctx, &docs[i])
+			if err != nil {
+				return migrated, err
+			}
+			if ok {
+				migrated++
+			}
+		}
+		if len(docs) < batchSize {
+			return migrated, nil
+		}
+	}
+}
+
+// migrateDocument re-encodes the data of doc with the store serializer. It
+// reports whether the document was rewritten.
+func (s *MongoStore) migrateDocument(ctx context.Context, doc *Session) (bool, error) {
+	session := sessions.NewSession(s, doc.Name)
+	if err := s.decodeData(session, doc); err != nil {
+		s.log(ctx).Warnf("mongostore: cannot migrate session %s: %v", s.sessionID(s.documentID(doc)), err)
+		return false, nil
+	}
+	data, compression, err := s.encodeData(session, session.Values)
+	if err != nil {
+		s.log(ctx).Warnf("mongostore: cannot migrate session %s: %v", s.sessionID(s.documentID(doc)), err)
+		return false, nil
+	}
+	update := bson.M{"$set": bson.M{
+		"data":       s.dataValue(data, compression),
+		"compressed": compression == GzipCompression && !s.binaryData,
+		"serializer": serializerName(s.serializer()),
+	}}
+	// Only rewrite the document if it was not saved in the meantime.
+	filter := s.idFilter(s.documentID(doc))
+	filter["modifiedAt"] = s.timestamp(doc.ModifiedAt)
+	res, err := s.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount > 0, nil
+}
+
+// Backfill sets the fields that session documents written by older versions
+// of this package lack, deriving them from their modification date, in
+// batches of batchSize documents (or 100 if batchSize is not positive), and
+// returns the number of updated documents: createdAt and dataModifiedAt are
+// set to modifiedAt, and, if MaxAge is positive, expiresAt to MaxAge seconds
+// after modifiedAt, so that the TTL index created by EnsureIndexes deletes
+// them and queries and partial indexes on these fields see them.
+//
+// Fields that are already set are left untouched, so Backfill is idempotent
+// and can be resumed after an interruption. Documents without a
+// modification date are skipped, as are documents keyed by strings, which
+// RepairIDs fixes.
+func (s *MongoStore) Backfill(ctx context.Context, batchSize int) (int64, error) {
+	if s.collection == nil {
+		return 0, ErrNoCollection
+	}
+	ctx, cancel := s.adminContext(ctx)
+	defer cancel()
+	if batchSize <= 0 {
+		batchSize = defaultBatchSize
+	}
+	missing := bson.A{
+		bson.M{"createdAt": nil},
+		bson.M{"dataModifiedAt": nil},
+	}
+	set := bson.M{
+		"createdAt":      bson.M{"$ifNull": bson.A{"$createdAt", "$modifiedAt"}},
+		"dataModifiedAt": bson.M{"$ifNull": bson.A{"$dataModifiedAt", "$modifiedAt"}},
+	}
+	if s.Options.MaxAge > 0 {
+		maxAge := time.Duration(s.Options.MaxAge) * time.Second
+		missing = append(missing, bson.M{"expiresAt": nil})
+		set["expiresAt"] = bson.M{"$ifNull": bson.A{"$expiresAt", bson.M{"$add": bson.A{"$modifiedAt", maxAge.Milliseconds()}}}}
+	}
+	filter := bson.M{
+		s.keyField(): bson.M{"$type": "objectId"},
+		"modifiedAt": bson.M{"$type": s.timestampType()},
+		"chunkOf":    bson.M{"$exists": false},
+		"$or":        missing,
+	}
+	opts := options.Find().SetProjection(bson.M{s.keyField(): 1}).SetLimit(int64(batchSize))
+
+	var updated int64
+	for {
+		ids, err := s.findIDs(ctx, filter, opts)
+		if err != nil {
+			return updated, err
+		}
+		if len(ids) == 0 {
+			return updated, nil
+		}
+		// Updated documents no longer match the filter.
+		res, err := s.collection.UpdateMany(ctx, bson.M{s.keyField(): bson.M{"$in": ids}}, mongo.Pipeline{{{Key: "$set", Value: set}}})
+		if err != nil {
+			return updated, err
+		}
+		updated += res.ModifiedCount
+		if len(ids) < batchSize {
+			return updated, nil
+		}
+	}
+}
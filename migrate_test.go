@@ -0,0 +1,120 @@
+package mongostore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestMigrateSerialization(t *testing.T) {
+	tests := []struct {
+		name      string
+		batchSize int
+	}{
+		{"default batch size", 0},
+		{"single-document batches", 1},
+		{"batch size not dividing the document count", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			gobStore := newTestStore(t)
+			var values []string
+			for _, user := range []string{"alice", "bob", "carol"} {
+				session := sessions.NewSession(gobStore, "test")
+				session.Options = gobStore.sessionOptions(session.Name())
+				session.Values["user"] = user
+				values = append(values, saveSession(t, gobStore, session))
+			}
+			s, err := NewMongoStoreWithOptions(gobStore.collection, nil, testKeyPairs, WithSerializer(JSONSerializer{}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			n, err := s.MigrateSerialization(ctx, tt.batchSize)
+			if err != nil || n != 3 {
+				t.Fatalf("MigrateSerialization() = %d, %v, want 3 migrated sessions", n, err)
+			}
+			if n, err := s.MigrateSerialization(ctx, tt.batchSize); err != nil || n != 0 {
+				t.Fatalf("MigrateSerialization() again = %d, %v, want 0 migrated sessions", n, err)
+			}
+			left, err := s.collection.CountDocuments(ctx, bson.M{"serializer": bson.M{"$ne": "json"}})
+			if err != nil || left != 0 {
+				t.Fatalf("%d sessions left in gob, %v", left, err)
+			}
+			for i, user := range []string{"alice", "bob", "carol"} {
+				if loaded := loadSession(t, s, "test", values[i]); loaded.IsNew || loaded.Values["user"] != user {
+					t.Fatalf("loaded session (new: %v) with %v, want user %q", loaded.IsNew, loaded.Values, user)
+				}
+			}
+		})
+	}
+}
+
+func TestBackfill(t *testing.T) {
+	modified := time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)
+	created := modified.Add(-time.Hour)
+	tests := []struct {
+		name      string
+		batchSize int
+		maxAge    int
+	}{
+		{"default batch size", 0, 3600},
+		{"single-document batches", 1, 3600},
+		{"without MaxAge", 2, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := newTestStore(t)
+			s.Options.MaxAge = tt.maxAge
+			legacy := []primitive.ObjectID{primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()}
+			partial, complete, undated := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+			docs := []interface{}{
+				bson.M{"_id": partial, "name": "test", "data": "", "modifiedAt": modified, "createdAt": created},
+				bson.M{"_id": complete, "name": "test", "data": "", "modifiedAt": modified, "createdAt": created, "dataModifiedAt": created, "expiresAt": modified},
+				bson.M{"_id": undated, "name": "test", "data": ""},
+				bson.M{"_id": primitive.NewObjectID().Hex(), "name": "test", "data": "", "modifiedAt": modified},
+			}
+			for _, id := range legacy {
+				docs = append(docs, bson.M{"_id": id, "name": "test", "data": "", "modifiedAt": modified})
+			}
+			if _, err := s.collection.InsertMany(ctx, docs); err != nil {
+				t.Fatal(err)
+			}
+			n, err := s.Backfill(ctx, tt.batchSize)
+			if err != nil || n != 4 {
+				t.Fatalf("Backfill() = %d, %v, want 4", n, err)
+			}
+			if n, err := s.Backfill(ctx, tt.batchSize); err != nil || n != 0 {
+				t.Fatalf("second Backfill() = %d, %v, want 0", n, err)
+			}
+
+			var wantExpiresAt time.Time
+			if tt.maxAge > 0 {
+				wantExpiresAt = modified.Add(time.Duration(tt.maxAge) * time.Second)
+			}
+			want := map[primitive.ObjectID]Session{
+				partial:  {CreatedAt: created, DataModifiedAt: modified, ExpiresAt: wantExpiresAt},
+				complete: {CreatedAt: created, DataModifiedAt: created, ExpiresAt: modified},
+				undated:  {},
+			}
+			for _, id := range legacy {
+				want[id] = Session{CreatedAt: modified, DataModifiedAt: modified, ExpiresAt: wantExpiresAt}
+			}
+			for id, w := range want {
+				var doc Session
+				if err := s.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&doc); err != nil {
+					t.Fatal(err)
+				}
+				if !doc.CreatedAt.Equal(w.CreatedAt) || !doc.DataModifiedAt.Equal(w.DataModifiedAt) || !doc.ExpiresAt.Equal(w.ExpiresAt) {
+					t.Errorf("document %s: createdAt %v, dataModifiedAt %v, expiresAt %v, want %v, %v, %v", id.Hex(),
+						doc.CreatedAt, doc.DataModifiedAt, doc.ExpiresAt, w.CreatedAt, w.DataModifiedAt, w.ExpiresAt)
+				}
+			}
+		})
+	}
+}
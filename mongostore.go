@@ -3,19 +3,111 @@ package mongostore
 import (
 	"context"
 	"errors"
+	"fmt"
+	"net"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gorilla/securecookie"
 	"github.com/gorilla/sessions"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
 )
 
+// DefaultCollectionName is the name of the session collection created by
+// NewMongoStoreWithPrefix, before the prefix is applied.
+const DefaultCollectionName = "sessions"
+
+// Names of the indexes used by the store queries.
+const (
+	IDIndexName     = "_id_"
+	UserIDIndexName = "userID_1"
+)
+
+// maxNamespaceLength is the maximum length of a "<database>.<collection>"
+// namespace accepted by MongoDB.
+const maxNamespaceLength = 255
+
+// maxCookieSize is the maximum size of the name and value of a cookie that
+// browsers are required to accept.
+const maxCookieSize = 4096
+
 var (
-	errInvalidModificationDate = errors.New("invalid session modification date")
+	// ErrInvalidCollectionName is returned when a collection name derived from
+	// a prefix is not a legal MongoDB collection name.
+	ErrInvalidCollectionName = errors.New("invalid collection name")
+
+	// ErrNoKeyPairs is returned when a store is configured without any key
+	// pair, and would therefore be unable to sign session cookies and data.
+	ErrNoKeyPairs = errors.New("no key pairs configured")
+
+	// ErrSessionNotFound is returned by administrative methods when the
+	// requested session does not exist, and by SaveIfVersion for sessions
+	// that were never stored.
+	ErrSessionNotFound = errors.New("session not found")
+
+	// ErrInvalidID is returned when a session ID is malformed.
+	ErrInvalidID = errors.New("invalid session ID")
+
+	// ErrSessionLeased is returned by Lease when the session is already
+	// leased.
+	ErrSessionLeased = errors.New("session already leased")
+
+	// ErrVersionMismatch is returned by SaveIfVersion when the stored
+	// session version differs from the expected one.
+	ErrVersionMismatch = errors.New("session version mismatch")
+
+	// ErrSerializationConflict is returned when the serialization settings
+	// of a store contradict each other, or when a session was written with a
+	// serializer the store cannot read.
+	ErrSerializationConflict = errors.New("conflicting session serialization")
+
+	// ErrNoCollection is returned when using a store without a collection,
+	// e.g. a MongoStore that was not created with one of the constructors.
+	ErrNoCollection = errors.New("no session collection configured")
+
+	// ErrStoreClosed is returned when writing sessions with a closed store.
+	ErrStoreClosed = errors.New("store closed")
+
+	// ErrSessionTooLarge is returned by Save when the serialized session
+	// values exceed the size set with WithMaxSessionSize.
+	ErrSessionTooLarge = errors.New("session too large")
+
+	// ErrCookieTooLarge is returned by Save when the session cookie would be
+	// larger than browsers accept. The session is then not written.
+	ErrCookieTooLarge = errors.New("session cookie too large")
+
+	// ErrInvalidModificationDate is returned when loading a session document
+	// without a modification date, unless the store was configured with
+	// WithMissingModifiedAtAllowed.
+	ErrInvalidModificationDate = errors.New("invalid session modification date")
+
+	// ErrSessionDeleted is returned when saving a loaded session whose
+	// document was deleted in the meantime, e.g. by a concurrent logout,
+	// with a store configured with WithNoResurrection.
+	ErrSessionDeleted = errors.New("session deleted")
+
+	// ErrWeakSessionID is returned when session IDs cannot carry the
+	// entropy required with WithMinIDEntropy, or when the generator set with
+	// WithIDGenerator returns NilObjectID.
+	ErrWeakSessionID = errors.New("weak session ID")
+
+	errUndecodableSession = errors.New("undecodable session data")
+	errConditionFailed    = errors.New("session document does not match the write condition")
+	// errInvalidSession is returned when the validator set with
+	// WithLoadValidator rejects a session, which is then treated as missing.
+	errInvalidSession = fmt.Errorf("invalid session: %w", mongo.ErrNoDocuments)
+	// errStaleSession is returned when a session reached its absolute
+	// timeout or was created before the date set with WithMinCreatedAt. The
+	// document outlives the rejection, so the session is replaced by a new
+	// one rather than saved again under its creation date.
+	errStaleSession = fmt.Errorf("stale session: %w", mongo.ErrNoDocuments)
 )
 
 // MongoStore stores sessions in a MongoDB collection.
@@ -23,16 +115,127 @@ type MongoStore struct {
 	Codecs     []securecookie.Codec
 	Options    *sessions.Options
 	collection *mongo.Collection
+	prefix     string
+	logger     Logger
+	hooks      Hooks
+
+	strictErase   bool
+	migrateValues func(values map[interface{}]interface{}) (changed bool)
+	resolveIP     func(r *http.Request) string
+	expiryJitter  time.Duration
+	expiryFloor   time.Duration
+	regenerateKey interface{}
+
+	analyticsReadPref *readpref.ReadPref
+	decorate          func(r *http.Request, session *sessions.Session, doc bson.M)
+
+	resolveCookieOptions func(r *http.Request) *sessions.Options
+
+	maxSessionsPerUser int
+	idIndexHint        bool
+	userIDIndexHint    bool
+
+	valueSerializer      securecookie.Serializer
+	compression          bool
+	compressionThreshold int
+
+	indexTimeout time.Duration
+	codecMaxAge  int
+
+	cache    CacheStore
+	cacheTTL time.Duration
+
+	clockSkew time.Duration
+
+	lifecycle lifecycle
+	gcRunning int32
+	chunkSize int
+
+	absoluteTimeout time.Duration
+	primaryKeyField string
+	cleanDuplicates bool
+	coerceKeys      bool
+	keyPolicySet    bool
+	idEncoding      IDEncoding
+	correlationID   func(ctx context.Context) string
+	alwaysSetCookie bool
+	userIDKey       interface{}
+	maxSessionSize  int
+
+	causalConsistency    bool
+	uniquePerUserAndName bool
+	fingerprints         []string
+
+	binaryData        bool
+	binaryCompression Compression
+	bsonValues        bool
+	encryptedKeys     map[interface{}]bool
+
+	allowMissingModifiedAt bool
+
+	writeBuffer       *writeBuffer
+	archiveCollection *mongo.Collection
+	perNameMaxAge     map[string]int
+	redactedKeys      map[interface{}]bool
+	loadFilter        func(ctx context.Context) bson.M
+	recoveryCodecs    []securecookie.Codec
+	onEncodeError     func(name string, err error)
+	noResurrection    bool
+	creationMetadata  func(r *http.Request) bson.M
+	adminTimeout      time.Duration
+	idGenerator       func() (primitive.ObjectID, error)
+	minIDEntropy      int
+	expiryGrace       time.Duration
+	unixMillis        bool
+	slots             chan struct{}
+	inFlight          int32
+	partitionedCookie bool
+	minCreatedAt      time.Time
+	loadValidator     func(session *sessions.Session) error
+	deleteInvalid     bool
+	requestConcern    *readconcern.ReadConcern
+	indexCollation    *options.Collation
+	codecNow          func() int64
+	stringIDs         bool
+
+	exportCoerceKeys   bool
+	exportKeyPolicySet bool
 }
 
-// Session is the model for a session document.
+// Session is the model for a session document. Documents may hold other
+// fields, e.g. written by other systems: the store never replaces documents,
+// so such fields are preserved by saves.
 type Session struct {
-	ID         primitive.ObjectID `bson:"_id,omitempty"`
-	Data       string             `bson:"data"`
-	ModifiedAt time.Time          `bson:"modifiedAt"`
+	ID             primitive.ObjectID `bson:"_id,omitempty"`
+	Name           string             `bson:"name,omitempty"`
+	Data           string             `bson:"data"`
+	Chunks         int                `bson:"chunks,omitempty"`
+	ChunkGen       primitive.ObjectID `bson:"chunkGen,omitempty"`
+	Compressed     bool               `bson:"compressed,omitempty"`
+	Serializer     string             `bson:"serializer,omitempty"`
+	CreatedAt      time.Time          `bson:"createdAt,omitempty"`
+	ModifiedAt     time.Time          `bson:"modifiedAt"`
+	DataModifiedAt time.Time          `bson:"dataModifiedAt,omitempty"`
+	ExpiresAt      time.Time          `bson:"expiresAt,omitempty"`
+	Version        int                `bson:"version,omitempty"`
+	UserID         string             `bson:"userID,omitempty"`
+	IPAddress      string             `bson:"ipAddress,omitempty"`
+	IP             *primitive.Binary  `bson:"ip,omitempty"`
+	Values         bson.Raw           `bson:"values,omitempty"`
+
+	// DataCompression is the compression of binary data (see
+	// WithBinaryData). It is not stored, but derived from the data.
+	DataCompression Compression `bson:"-"`
+
+	// Key is the session ID of documents read by a store configured with
+	// WithStringIDs, whose ID is then zero unless they are keyed by an
+	// ObjectID. It is read from the key field, and is not stored as such.
+	Key string `bson:"-"`
 }
 
-// NewMongoStore returns a new MongoStore instance.
+// NewMongoStore returns a new MongoStore instance. It does not validate its
+// arguments: without key pairs, New and Save fail with ErrNoKeyPairs. Use
+// NewMongoStoreWithOptions to reject them upfront.
 func NewMongoStore(c *mongo.Collection, opts *sessions.Options, keyPairs ...[]byte) *MongoStore {
 	if opts == nil {
 		opts = &sessions.Options{
@@ -44,11 +247,115 @@ func NewMongoStore(c *mongo.Collection, opts *sessions.Options, keyPairs ...[]by
 		Codecs:     securecookie.CodecsFromPairs(keyPairs...),
 		Options:    opts,
 		collection: c,
+		logger:     nopLogger{},
+
+		compressionThreshold: DefaultCompressionThreshold,
+		fingerprints:         keyFingerprints(keyPairs),
 	}
 	ms.MaxAge(opts.MaxAge)
 	return ms
 }
 
+// Lengths of the keys generated by NewMongoStoreWithGeneratedKeys: a 64-byte
+// HMAC-SHA256 hash key, and a 32-byte AES-256 block key.
+const (
+	generatedHashKeyLength  = 64
+	generatedBlockKeyLength = 32
+)
+
+// NewMongoStoreWithGeneratedKeys returns a new MongoStore instance, like
+// NewMongoStore, with a hash key and a block key freshly generated by
+// securecookie.GenerateRandomKey, which it returns so that the application
+// can persist them: sessions can only be decoded with the keys they were
+// encoded with. It is meant to bootstrap deployments with strong keys
+// rather than short or hand-written ones.
+//
+// It returns an error if the system random source fails.
+func NewMongoStoreWithGeneratedKeys(c *mongo.Collection, opts *sessions.Options) (ms *MongoStore, hashKey, blockKey []byte, err error) {
+	hashKey = securecookie.GenerateRandomKey(generatedHashKeyLength)
+	blockKey = securecookie.GenerateRandomKey(generatedBlockKeyLength)
+	if hashKey == nil || blockKey == nil {
+		return nil, nil, nil, errors.New("mongostore: could not generate keys")
+	}
+	return NewMongoStore(c, opts, hashKey, blockKey), hashKey, blockKey, nil
+}
+
+// NewMongoStoreWithPrefix returns a new MongoStore instance storing sessions in
+// the "<prefix>_sessions" collection of db, or in "sessions" if prefix is
+// empty. This allows several applications to share a database.
+//
+// It returns ErrInvalidCollectionName if the derived name is not a legal
+// collection name, and ErrNoKeyPairs if no key pair is given.
+func NewMongoStoreWithPrefix(db *mongo.Database, prefix string, opts *sessions.Options, keyPairs ...[]byte) (*MongoStore, error) {
+	name := DefaultCollectionName
+	if prefix != "" {
+		name = prefix + "_" + DefaultCollectionName
+	}
+	if err := validateCollectionName(db.Name(), name); err != nil {
+		return nil, err
+	}
+	ms := NewMongoStore(db.Collection(name), opts, keyPairs...)
+	ms.prefix = prefix
+	if err := ms.Validate(); err != nil {
+		return nil, err
+	}
+	return ms, nil
+}
+
+// NewMongoStoreWithOptions returns a new MongoStore instance configured with
+// the given options, which are applied in order.
+//
+// It returns an error if the resulting configuration is invalid, see Validate.
+func NewMongoStoreWithOptions(c *mongo.Collection, opts *sessions.Options, keyPairs [][]byte, options ...Option) (*MongoStore, error) {
+	ms := NewMongoStore(c, opts, keyPairs...)
+	for _, option := range options {
+		option(ms)
+	}
+	if err := ms.Validate(); err != nil {
+		return nil, err
+	}
+	return ms, nil
+}
+
+// Validate checks the store configuration.
+//
+// It returns ErrNoCollection if the store has no collection, ErrNoKeyPairs
+// if it has no codec, unless it was configured with WithInsecureNoKeys,
+// ErrWeakSessionID if session IDs are shorter than the entropy set with
+// WithMinIDEntropy, an error if cookies are partitioned (see
+// WithPartitionedCookie) but the store options are not Secure with
+// SameSite=None, and ErrSerializationConflict if a serializer set with
+// WithSerializer cannot be applied to every codec, in which case values would
+// be serialized differently depending on their size and the codec used.
+func (s *MongoStore) Validate() error {
+	if s.collection == nil {
+		return ErrNoCollection
+	}
+	if len(s.Codecs) == 0 {
+		return ErrNoKeyPairs
+	}
+	if s.partitionedCookie && (!s.Options.Secure || s.Options.SameSite != http.SameSiteNoneMode) {
+		return errors.New("mongostore: partitioned cookies must be Secure with SameSite=None")
+	}
+	if s.minIDEntropy > s.entropy() {
+		return fmt.Errorf("%w: session IDs have %d bits, %d are required", ErrWeakSessionID, s.entropy(), s.minIDEntropy)
+	}
+	if s.stringIDs && s.idGenerator != nil {
+		return errors.New("mongostore: the ID generator cannot be used with string IDs")
+	}
+	if s.binaryData && s.chunkSize > 0 {
+		return fmt.Errorf("%w: binary data cannot be chunked", ErrSerializationConflict)
+	}
+	if s.valueSerializer != nil {
+		for _, codec := range s.decodeCodecs() {
+			if _, ok := codec.(*securecookie.SecureCookie); !ok {
+				return fmt.Errorf("%w: serializer %s cannot be applied to codec %T", ErrSerializationConflict, serializerName(s.valueSerializer), codec)
+			}
+		}
+	}
+	return nil
+}
+
 // Get returns a session for the given name after adding it to the registry.
 //
 // It returns a new session if the sessions doesn't exist. Access IsNew on
@@ -66,97 +373,985 @@ func (s *MongoStore) Get(r *http.Request, name string) (*sessions.Session, error
 // decode the session data twice, while Get() registers and reuses the same
 // decoded session after the first call.
 func (s *MongoStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	var value string
+	if c, errCookie := r.Cookie(name); errCookie == nil {
+		value = c.Value
+	}
+	return s.DecodeSessionCookie(r.Context(), name, value)
+}
+
+// DecodeSessionCookie returns the session for the given name from the raw
+// value of its cookie, without adding it to the registry. It is useful when
+// the cookie is not carried by an *http.Request, e.g. in WebSocket connection
+// parameters.
+//
+// It follows the same semantics as New: an empty cookie value yields a new
+// session, and a new session is returned alongside an error if the cookie or
+// the session could not be decoded. The error is ErrNoKeyPairs if the store
+// has no codec, even without a cookie, ErrNoCollection if it has no collection,
+// and wraps ErrInvalidID if the cookie carries a malformed session ID, which
+// is then not looked up.
+func (s *MongoStore) DecodeSessionCookie(ctx context.Context, name, cookieValue string) (*sessions.Session, error) {
 	session := sessions.NewSession(s, name)
-	opts := *s.Options
-	session.Options = &opts
+	session.Options = s.sessionOptions(session.Name())
 	session.IsNew = true
-	var err error
-	if c, errCookie := r.Cookie(name); errCookie == nil {
-		if err = securecookie.DecodeMulti(name, c.Value, &session.ID, s.Codecs...); err == nil {
-			if err = s.load(r.Context(), session); err == nil {
-				session.IsNew = false
-			}
-		}
+	if s.collection == nil {
+		return session, ErrNoCollection
+	}
+	if len(s.Codecs) == 0 {
+		s.log(ctx).Errorf("mongostore: cannot decode cookie for session %q: %v", name, ErrNoKeyPairs)
+		return session, ErrNoKeyPairs
+	}
+	if cookieValue == "" {
+		return session, nil
+	}
+	err := s.decodeMulti(name, cookieValue, &session.ID)
+	if IsExpired(err) {
+		s.log(ctx).Debugf("mongostore: cookie for session %q expired: %v", name, err)
+	} else if err != nil {
+		s.log(ctx).Warnf("mongostore: could not decode cookie for session %q: %v", name, err)
+	} else if _, err = s.parseID(session.ID); err != nil {
+		s.log(ctx).Warnf("mongostore: cookie for session %q carries a malformed ID: %v", name, err)
+		session.ID = ""
+	} else if err = s.load(ctx, session); err == nil {
+		session.IsNew = false
+		stateOf(session).cookieID = session.ID
+		s.event(EventLoaded)
+	} else if errors.Is(err, errUndecodableSession) {
+		s.hooks.OnUndecodableSession(session.ID)
+		session.ID = ""
+		session.Values = make(map[interface{}]interface{})
+		resetState(session)
+		err = nil
+	} else if errors.Is(err, errInvalidSession) || errors.Is(err, errStaleSession) {
+		session.ID = ""
+		session.Values = make(map[interface{}]interface{})
+		resetState(session)
+		err = nil
 	}
 	return session, err
 }
 
+// NewFromCookieHeader returns the session for the given name from a raw
+// Cookie header, as forwarded by a proxy, without adding it to the registry.
+// It follows the same semantics as New: a new session is returned if the
+// header has no cookie with that name.
+func (s *MongoStore) NewFromCookieHeader(ctx context.Context, header, name string) (*sessions.Session, error) {
+	r := http.Request{Header: http.Header{"Cookie": {header}}}
+	var value string
+	if c, errCookie := r.Cookie(name); errCookie == nil {
+		value = c.Value
+	}
+	return s.DecodeSessionCookie(ctx, name, value)
+}
+
+// EraseByCookie deletes the session for the given name whose ID is encoded
+// in cookieValue, without loading it. It is meant for logout handlers that
+// never loaded the session.
+//
+// It returns an error if the cookie cannot be decoded. Erasing a session that
+// no longer exists succeeds, unless the store was configured with
+// WithStrictErase.
+func (s *MongoStore) EraseByCookie(ctx context.Context, name, cookieValue string) error {
+	if len(s.Codecs) == 0 {
+		return ErrNoKeyPairs
+	}
+	var id string
+	if err := s.decodeMulti(name, cookieValue, &id); err != nil {
+		s.log(ctx).Warnf("mongostore: could not decode cookie for session %q: %v", name, err)
+		return err
+	}
+	return s.eraseID(ctx, id)
+}
+
 // Save adds a single session to the response.
+//
+// The session cookie is not set again when the session was loaded from a
+// cookie carrying the same ID, unless the store was configured with
+// WithAlwaysSetCookie(true). The cookie then keeps its original expiry.
+// Save does nothing for read-only requests (see WithReadOnly).
 func (s *MongoStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	_, err := s.SaveWithResult(r, w, session)
+	return err
+}
+
+// SaveResult describes the outcome of SaveWithResult.
+type SaveResult struct {
+	// ID is the ID of the saved session.
+	ID string
+	// Created reports whether the session document was created, rather
+	// than updated.
+	Created bool
+}
+
+// SaveWithResult is like Save, but also reports the outcome of the save, e.g.
+// for audit logging. Erasing a session only sets the ID of the result.
+func (s *MongoStore) SaveWithResult(r *http.Request, w http.ResponseWriter, session *sessions.Session) (SaveResult, error) {
+	if s.collection == nil {
+		return SaveResult{}, ErrNoCollection
+	}
+	if isReadOnly(r.Context()) {
+		s.log(r.Context()).Debugf("mongostore: not saving session %s in a read-only request", session.ID)
+		return SaveResult{ID: session.ID}, nil
+	}
 	if session.Options.MaxAge < 0 {
-		if err := s.erase(r.Context(), session); err != nil {
-			return err
+		err := s.erase(r.Context(), session)
+		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			return SaveResult{}, err
 		}
-		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
-		return nil
+		// An absent document is only an error with WithStrictErase: the
+		// session is gone either way, so its cookie is cleared.
+		s.setCookie(w, sessions.NewCookie(session.Name(), "", s.cookieOptions(r, session)))
+		return SaveResult{ID: session.ID}, err
 	}
 
-	if session.ID == "" {
-		session.ID = primitive.NewObjectID().Hex()
+	var encoded string
+	if err := s.save(r.Context(), r, session, &encoded); err != nil {
+		return SaveResult{}, err
 	}
-	if err := s.save(r.Context(), session); err != nil {
-		return err
+	st := loadedState(session)
+	result := SaveResult{ID: session.ID, Created: st != nil && st.created}
+	if encoded != "" {
+		s.setCookie(w, sessions.NewCookie(session.Name(), encoded, s.cookieOptions(r, session)))
 	}
-	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.Codecs...)
+	return result, nil
+}
+
+// issuesCookie reports whether saving session under the given ID sets its
+// cookie, i.e. unless it was loaded from a cookie carrying that ID.
+func (s *MongoStore) issuesCookie(session *sessions.Session, id string) bool {
+	st := loadedState(session)
+	return s.alwaysSetCookie || session.IsNew || st == nil || st.cookieID != id
+}
+
+// encodeCookie returns the value of the cookie of the session with the given
+// name and ID. It returns an error wrapping ErrCookieTooLarge if the cookie
+// would exceed maxCookieSize.
+func (s *MongoStore) encodeCookie(ctx context.Context, name, id string) (string, error) {
+	encoded, err := securecookie.EncodeMulti(name, id, s.Codecs...)
 	if err != nil {
-		return err
+		return "", err
 	}
-	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
-	return nil
+	if size := len(name) + len(encoded); size > maxCookieSize {
+		s.log(ctx).Errorf("mongostore: cookie of session %s is %d bytes long", id, size)
+		return "", fmt.Errorf("%w: %d bytes", ErrCookieTooLarge, size)
+	}
+	return encoded, nil
+}
+
+// setCookie adds the Set-Cookie header for c to w, with the Partitioned
+// attribute if the store was configured with WithPartitionedCookie.
+func (s *MongoStore) setCookie(w http.ResponseWriter, c *http.Cookie) {
+	if s.partitionedCookie {
+		setPartitionedCookie(w, c)
+		return
+	}
+	http.SetCookie(w, c)
+}
+
+// sessionOptions returns a copy of the store options for a session with the
+// given name, with the MaxAge set for the name by WithPerNameMaxAge, if any.
+func (s *MongoStore) sessionOptions(name string) *sessions.Options {
+	var opts sessions.Options
+	if s.Options != nil {
+		opts = *s.Options
+	}
+	if maxAge, ok := s.perNameMaxAge[name]; ok {
+		opts.MaxAge = maxAge
+	}
+	return &opts
+}
+
+// cookieOptions returns the options of the cookie issued for session in
+// response to r. They are the session options, whose unset Path, Domain,
+// Secure, HttpOnly and SameSite fields default to the store options, unless a
+// cookie options resolver returns options for r, in which case only MaxAge is
+// taken from the session options. With an absolute timeout, MaxAge is further
+// capped to the remaining lifetime of the session.
+func (s *MongoStore) cookieOptions(r *http.Request, session *sessions.Session) *sessions.Options {
+	opts := *session.Options
+	if s.Options != nil {
+		mergeOptions(&opts, s.Options)
+	}
+	if s.resolveCookieOptions != nil {
+		if resolved := s.resolveCookieOptions(r); resolved != nil {
+			opts = *resolved
+			opts.MaxAge = session.Options.MaxAge
+		}
+	}
+	if s.absoluteTimeout > 0 && opts.MaxAge >= 0 {
+		remaining := int(time.Until(s.absoluteDeadline(loadedState(session))) / time.Second)
+		if remaining <= 0 {
+			remaining = -1
+		}
+		if opts.MaxAge == 0 || remaining < opts.MaxAge {
+			opts.MaxAge = remaining
+		}
+	}
+	return &opts
+}
+
+// mergeOptions sets the unset Path, Domain, Secure, HttpOnly and SameSite
+// fields of opts to their value in defaults. Since unset booleans cannot be
+// told from false ones, sessions cannot disable Secure or HttpOnly cookies
+// enabled by the defaults.
+func mergeOptions(opts, defaults *sessions.Options) {
+	if opts.Path == "" {
+		opts.Path = defaults.Path
+	}
+	if opts.Domain == "" {
+		opts.Domain = defaults.Domain
+	}
+	if !opts.Secure {
+		opts.Secure = defaults.Secure
+	}
+	if !opts.HttpOnly {
+		opts.HttpOnly = defaults.HttpOnly
+	}
+	if opts.SameSite == 0 {
+		opts.SameSite = defaults.SameSite
+	}
+}
+
+// absoluteDeadline returns the date after which the session whose state is
+// st, possibly nil, expires regardless of its activity, when the store has
+// an absolute timeout.
+func (s *MongoStore) absoluteDeadline(st *sessionState) time.Time {
+	created := time.Now()
+	if st != nil && !st.createdAt.IsZero() {
+		created = st.createdAt
+	}
+	return created.Add(s.absoluteTimeout)
 }
 
 // MaxAge sets the maximum age for the store and the underlying cookie
 // implementation. Individual sessions can be deleted by setting Options.MaxAge
 // = -1 for that session.
+//
+// If the store was configured with WithCodecMaxAge, the securecookie
+// instances keep their own maximum age.
 func (s *MongoStore) MaxAge(age int) {
 	s.Options.MaxAge = age
+	s.applyCodecMaxAge()
+}
 
-	// Set the maxAge for each securecookie instance.
-	for _, codec := range s.Codecs {
-		if sc, ok := codec.(*securecookie.SecureCookie); ok {
-			sc.MaxAge(age)
-		}
+// decodeCodecs returns the codecs decoding cookies and session data: the
+// store codecs, followed by the recovery codecs set with WithRecoveryCodecs.
+func (s *MongoStore) decodeCodecs() []securecookie.Codec {
+	if len(s.recoveryCodecs) == 0 {
+		return s.Codecs
 	}
+	codecs := make([]securecookie.Codec, 0, len(s.Codecs)+len(s.recoveryCodecs))
+	return append(append(codecs, s.Codecs...), s.recoveryCodecs...)
+}
+
+// Prefix returns the collection name prefix the store was created with by
+// NewMongoStoreWithPrefix, or an empty string.
+func (s *MongoStore) Prefix() string {
+	return s.prefix
 }
 
 // load retrieves a session document from the MongoDB collection.
-func (s *MongoStore) load(ctx context.Context, session *sessions.Session) error {
+func (s *MongoStore) load(ctx context.Context, session *sessions.Session) (err error) {
+	defer s.observe(OpLoad, time.Now(), &err)
+	if session.ID == "" {
+		s.log(ctx).Debugf("mongostore: cannot load session %q without ID", session.Name())
+		return mongo.ErrNoDocuments
+	}
+	id, err := s.parseID(session.ID)
+	if err != nil {
+		s.log(ctx).Debugf("mongostore: session ID %q is not a valid document ID: %v", session.ID, err)
+		return mongo.ErrNoDocuments
+	}
+	release, err := s.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	if s.writeBuffer.has(id) {
+		// Do not load an outdated document.
+		if err := s.Flush(ctx); err != nil {
+			return err
+		}
+	}
+	if s.loadFilter == nil {
+		// Cached documents cannot be matched against the load filter.
+		if doc, ok := s.cacheGet(ctx, session.ID); ok {
+			return s.rejectInvalid(ctx, id, s.loadDocument(ctx, session, doc))
+		}
+	}
+	// Should a legacy document keyed by the hexadecimal string of the
+	// session ID exist alongside the current one, the most recently modified
+	// document wins.
+	filter := s.scopeFilter(ctx, s.idFilter(id))
+	findOpts := options.FindOne().SetSort(bson.D{{Key: "modifiedAt", Value: -1}})
+	if s.correlationID != nil {
+		if cid := s.correlationID(ctx); cid != "" {
+			findOpts.SetComment(cid)
+		}
+	}
+	if s.idIndexHint {
+		findOpts.SetHint(IDIndexName)
+	}
+	raw, err := s.collection.FindOne(ctx, filter, findOpts).DecodeBytes()
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			s.log(ctx).Debugf("mongostore: session %s not found", session.ID)
+		} else {
+			s.log(ctx).Errorf("mongostore: could not load session %s: %v", session.ID, err)
+		}
+		return err
+	}
 	var doc Session
-	if err := s.collection.FindOne(ctx, bson.M{"_id": session.ID}).Decode(&doc); err != nil {
+	if err := s.decodeDocument(raw, &doc); err != nil {
+		s.log(ctx).Errorf("mongostore: could not load session %s: %v", session.ID, err)
+		return err
+	}
+	if s.cleanDuplicates || s.hooks.OnDuplicateSession != nil {
+		s.handleDuplicates(ctx, session.ID, filter, raw.Lookup("_id"))
+	}
+	if err := s.assembleChunks(ctx, &doc); err != nil {
+		s.log(ctx).Errorf("mongostore: could not load chunks of session %s: %v", session.ID, err)
+		return err
+	}
+	s.cacheSet(ctx, &doc)
+	return s.rejectInvalid(ctx, id, s.loadDocument(ctx, session, &doc))
+}
+
+// rejectInvalid returns err, the error loading the document with the given
+// ID, after deleting the document if it was rejected by the load validator
+// and the store was configured with WithDeleteInvalidSessions.
+func (s *MongoStore) rejectInvalid(ctx context.Context, id interface{}, err error) error {
+	if !s.deleteInvalid || !errors.Is(err, errInvalidSession) {
+		return err
+	}
+	if _, delErr := s.deleteDocuments(ctx, []interface{}{id}); delErr != nil {
+		s.log(ctx).Errorf("mongostore: could not delete invalid session %s: %v", s.sessionID(id), delErr)
+	}
+	return err
+}
+
+// loadDocument decodes the session document doc into session.
+func (s *MongoStore) loadDocument(ctx context.Context, session *sessions.Session, doc *Session) error {
+	cutoff := s.expiryCutoff(time.Now())
+	expiring := false
+	if !doc.ExpiresAt.IsZero() && doc.ExpiresAt.Before(cutoff) {
+		if doc.ExpiresAt.Before(cutoff.Add(-s.expiryGrace)) {
+			s.log(ctx).Debugf("mongostore: session %s expired at %s", session.ID, doc.ExpiresAt)
+			return mongo.ErrNoDocuments
+		}
+		s.log(ctx).Debugf("mongostore: session %s expired at %s, within the grace period", session.ID, doc.ExpiresAt)
+		expiring = true
+	}
+	if s.absoluteTimeout > 0 && !doc.CreatedAt.IsZero() && doc.CreatedAt.Add(s.absoluteTimeout).Before(cutoff) {
+		s.log(ctx).Debugf("mongostore: session %s reached its absolute timeout", session.ID)
+		session.Values = make(map[interface{}]interface{})
+		return errStaleSession
+	}
+	if !s.minCreatedAt.IsZero() && doc.CreatedAt.Before(s.minCreatedAt) {
+		s.log(ctx).Debugf("mongostore: session %s was created before %s", session.ID, s.minCreatedAt)
+		session.Values = make(map[interface{}]interface{})
+		return errStaleSession
+	}
+	if doc.ModifiedAt.IsZero() && !s.allowMissingModifiedAt {
+		s.log(ctx).Errorf("mongostore: session %s has no modification date", session.ID)
+		return ErrInvalidModificationDate
+	}
+	if doc.Values != nil {
+		if err := s.decodeValues(session, doc.Values); err != nil {
+			s.log(ctx).Errorf("mongostore: could not decode values of session %s: %v", session.ID, err)
+			return err
+		}
+	} else if err := s.loadData(ctx, session, doc); err != nil {
+		return err
+	}
+	if s.migrateValues != nil && s.migrateValues(session.Values) {
+		s.log(ctx).Debugf("mongostore: migrated values of session %s", session.ID)
+	}
+	st := stateOf(session)
+	st.version = doc.Version
+	st.createdAt = doc.CreatedAt
+	st.expiring = expiring
+	st.chunked = doc.Chunks > 0
+	if doc.Values == nil {
+		st.stored = &Session{
+			Data:            doc.Data,
+			Compressed:      doc.Compressed,
+			DataCompression: doc.DataCompression,
+			Serializer:      doc.Serializer,
+			DataModifiedAt:  doc.DataModifiedAt,
+		}
+	}
+	s.watch(session, st)
+	if s.loadValidator != nil {
+		if err := s.loadValidator(session); err != nil {
+			s.log(ctx).Warnf("mongostore: session %s is invalid: %v", session.ID, err)
+			session.Values = make(map[interface{}]interface{})
+			return errInvalidSession
+		}
+	}
+	return nil
+}
+
+// loadData decodes the data of the session document doc, encoded by the
+// codecs, into session.
+func (s *MongoStore) loadData(ctx context.Context, session *sessions.Session, doc *Session) error {
+	if len(s.Codecs) == 0 {
+		s.log(ctx).Errorf("mongostore: cannot decode data of session %s: %v", session.ID, ErrNoKeyPairs)
+		return ErrNoKeyPairs
+	}
+	if err := s.checkSerializer(doc); err != nil {
+		s.log(ctx).Errorf("mongostore: cannot decode data of session %s: %v", session.ID, err)
 		return err
 	}
-	if err := securecookie.DecodeMulti(session.Name(), doc.Data, &session.Values, s.Codecs...); err != nil {
+	if err := s.decodeData(session, doc); err != nil {
+		s.log(ctx).Warnf("mongostore: could not decode data of session %s: %v", session.ID, err)
+		if scErr, ok := err.(securecookie.Error); ok && scErr.IsDecode() && s.hooks.OnUndecodableSession != nil {
+			return fmt.Errorf("%w: %v", errUndecodableSession, err)
+		}
 		return err
 	}
 	return nil
 }
 
-// save upserts a session document in the MongoDB collection.
-func (s *MongoStore) save(ctx context.Context, session *sessions.Session) error {
-	objID, err := primitive.ObjectIDFromHex(session.ID)
+// save upserts a session document in the MongoDB collection, generating a
+// new ID for sessions without one. The request r is used to capture request
+// metadata, and may be nil.
+func (s *MongoStore) save(ctx context.Context, r *http.Request, session *sessions.Session, cookie *string) error {
+	release, err := s.acquire(ctx)
 	if err != nil {
 		return err
 	}
+	defer release()
+	return s.write(ctx, r, session, nil, cookie)
+}
 
-	encoded, err := securecookie.EncodeMulti(session.Name(), session.Values, s.Codecs...)
+// write upserts a session document in the MongoDB collection, like save. If
+// cond is not nil, the document is only updated if it also matches cond, and
+// errConditionFailed is returned otherwise. If cookie is not nil and the save
+// sets the session cookie (see issuesCookie), the cookie value is encoded
+// into it before the document is written, so that a cookie too large for
+// browsers fails the save without writing anything.
+//
+// When the values of a loaded session did not change, its data is not
+// rewritten: only its modification and expiry dates and request metadata
+// are updated.
+//
+// Documents are only ever updated with $set, $unset, $inc and $setOnInsert
+// operations on the store's own fields, never replaced, so that fields
+// written by other systems survive saves.
+func (s *MongoStore) write(ctx context.Context, r *http.Request, session *sessions.Session, cond bson.M, cookie *string) (err error) {
+	if s.collection == nil {
+		return ErrNoCollection
+	}
+	defer s.observe(OpSave, time.Now(), &err)
+	if s.lifecycle.isClosed() {
+		return ErrStoreClosed
+	}
+	if len(s.Codecs) == 0 {
+		s.log(ctx).Errorf("mongostore: cannot save session %q: %v", session.Name(), ErrNoKeyPairs)
+		return ErrNoKeyPairs
+	}
+	var staleID string
+	id := session.ID
+	// Only new sessions may create their document with WithNoResurrection.
+	create := !s.noResurrection || session.IsNew
+	if id == "" {
+		if id, err = s.newSessionID(); err != nil {
+			return err
+		}
+		create = true
+	} else if cond == nil && s.watchedValueChanged(session) {
+		staleID = id
+		if id, err = s.newSessionID(); err != nil {
+			return err
+		}
+		create = true
+		s.log(ctx).Debugf("mongostore: regenerating session %s as %s", staleID, id)
+	}
+	objID, err := s.docID(id)
 	if err != nil {
 		return err
 	}
+	if cookie != nil && s.issuesCookie(session, id) {
+		if *cookie, err = s.encodeCookie(ctx, session.Name(), id); err != nil {
+			return err
+		}
+	}
+	st := stateOf(session)
+	if staleID == "" {
+		session.ID = id
+		if err = s.writeDocument(ctx, r, session, st, id, cond, cookie, objID, "", create); err == nil {
+			s.watch(session, st)
+		}
+		return err
+	}
+	// The session is written under its new ID and the document stored under
+	// the old ID is deleted in a single transaction. The driver may run it
+	// more than once, so each run starts over from the state of the session,
+	// which is only updated, along with its ID, once the transaction
+	// committed.
+	prev := *st
+	var next sessionState
+	err = s.inTransaction(ctx, func(ctx context.Context) error {
+		next = prev
+		return s.writeDocument(ctx, r, session, &next, id, cond, cookie, objID, staleID, create)
+	})
+	if err != nil {
+		return err
+	}
+	session.ID = id
+	s.watch(session, &next)
+	*st = next
+	return nil
+}
 
-	doc := Session{
-		ID:         objID,
-		Data:       encoded,
-		ModifiedAt: time.Now(),
+// writeDocument writes the document of session, updating its state st.
+// The session ID id and its document ID objID were decided by write, which
+// also documents cond and cookie. create reports whether the document may be
+// created. If staleID is not empty, session is given the new ID id, and the
+// document stored under staleID is deleted.
+func (s *MongoStore) writeDocument(ctx context.Context, r *http.Request, session *sessions.Session, st *sessionState, id string, cond bson.M, cookie *string, objID interface{}, staleID string, create bool) error {
+	values := session.Values
+	now := time.Now()
+	set := bson.M{"modifiedAt": s.timestamp(now)}
+	unset := bson.M{}
+	buffered := s.writeBuffer != nil && cond == nil && staleID == "" && create
+	touch := !buffered && staleID == "" && !s.bsonValues && s.valuesUnchanged(session, st, values, now)
+	var encoded string
+	var compression Compression
+	var err error
+	if s.bsonValues {
+		encrypted, err := s.encryptValues(values)
+		if err != nil {
+			return s.encodeFailed(ctx, session.Name(), id, err)
+		}
+		m, err := stringKeyed(encrypted, s.coerceKeys)
+		if err != nil {
+			return s.encodeFailed(ctx, session.Name(), id, err)
+		}
+		set["name"] = session.Name()
+		set["values"] = m
+		set["dataModifiedAt"] = s.timestamp(now)
+		unset["data"] = ""
+		unset["compressed"] = ""
+		unset["serializer"] = ""
+	} else if !touch {
+		unset["values"] = ""
+		if encoded, compression, err = s.encodeData(session, values); err != nil {
+			return s.encodeFailed(ctx, session.Name(), id, err)
+		}
+		set["name"] = session.Name()
+		set["data"] = s.dataValue(encoded, compression)
+		set["serializer"] = serializerName(s.serializer())
+		set["dataModifiedAt"] = s.timestamp(now)
+		if compression == GzipCompression && !s.binaryData {
+			set["compressed"] = true
+		} else {
+			unset["compressed"] = ""
+		}
+	}
+	expiresAt := s.expiresAt(now, session.Options.MaxAge)
+	if s.absoluteTimeout > 0 {
+		if deadline := s.absoluteDeadline(st); expiresAt.IsZero() || deadline.Before(expiresAt) {
+			expiresAt = deadline
+		}
+	}
+	if !expiresAt.IsZero() {
+		set["expiresAt"] = s.timestamp(expiresAt)
+	}
+	var chunks int
+	var gen primitive.ObjectID
+	if !touch {
+		if s.chunkSize > 0 && len(encoded) > s.chunkSize {
+			chunks = (len(encoded) + s.chunkSize - 1) / s.chunkSize
+			gen = primitive.NewObjectID()
+			set["data"] = ""
+			set["chunks"] = chunks
+			set["chunkGen"] = gen
+		} else {
+			unset["chunks"] = ""
+			unset["chunkGen"] = ""
+		}
+	}
+	user := s.userID(values)
+	if user != "" {
+		set["userID"] = user
+	} else {
+		unset["userID"] = ""
+	}
+	if s.resolveIP != nil && r != nil {
+		ip := s.resolveIP(r)
+		set["ipAddress"] = ip
+		if b := ipBinary(net.ParseIP(ip)); b != nil {
+			set["ip"] = b
+		}
+	}
+	if s.decorate != nil {
+		s.decorateDocument(ctx, r, session, set)
+	}
+	insert := bson.M{"createdAt": s.timestamp(now)}
+	if s.creationMetadata != nil && r != nil {
+		s.addCreationMetadata(ctx, r, set, unset, insert)
+	}
+	unique := s.uniquePerUserAndName && user != ""
+	if buffered && chunks == 0 && !unique && (s.maxSessionsPerUser <= 0 || user == "") {
+		// Saves of chunked documents are written directly, deleting their
+		// chunks.
+		if !st.chunked {
+			st.created = session.IsNew
+			if session.IsNew && st.version == 0 {
+				// First save of the session.
+				s.event(EventCreated)
+			}
+			if session.IsNew {
+				st.version = 1
+				st.createdAt = now
+			} else {
+				st.version++
+			}
+			return s.bufferWrite(ctx, objID, id, set, unset, insert)
+		}
+	}
+	if s.writeBuffer.has(objID) {
+		// Apply the buffered saves of the session first.
+		if err := s.Flush(ctx); err != nil {
+			return err
+		}
+	}
+	// Unlike reads, the upsert matches the ObjectID alone (see idFilter).
+	filter := s.scopeFilter(ctx, bson.M{s.keyField(): objID})
+	for k, v := range cond {
+		filter[k] = v
+	}
+	opts := options.Update().SetUpsert(cond == nil && !touch && create)
+	if s.idIndexHint {
+		opts.SetHint(IDIndexName)
 	}
-	opts := options.Update().SetUpsert(true)
-	update := bson.D{{Key: "$set", Value: &doc}}
-	if _, err := s.collection.UpdateOne(ctx, bson.M{"_id": objID}, update, opts); err != nil {
+	update := bson.D{
+		{Key: "$set", Value: set},
+		{Key: "$inc", Value: bson.M{"version": 1}},
+		{Key: "$setOnInsert", Value: insert},
+	}
+	if len(unset) > 0 {
+		update = append(update, bson.E{Key: "$unset", Value: unset})
+	}
+	if unique {
+		if err := s.deleteOtherUserSessions(ctx, user, session.Name(), objID); err != nil {
+			s.log(ctx).Errorf("mongostore: could not replace sessions of user %q: %v", user, err)
+			return err
+		}
+	}
+	if chunks > 0 {
+		// The chunks are written under a new generation, which loads only
+		// read once the document references it.
+		if err := s.writeChunks(ctx, objID, gen, encoded, expiresAt); err != nil {
+			s.log(ctx).Errorf("mongostore: could not write chunks of session %s: %v", id, err)
+			s.discardChunks(ctx, id, objID, gen)
+			return err
+		}
+	}
+	res, err := s.collection.UpdateOne(ctx, filter, update, opts)
+	if unique && isDuplicateKeyError(err) {
+		// A concurrent save of another session of the user won: replace it.
+		if err := s.deleteOtherUserSessions(ctx, user, session.Name(), objID); err != nil {
+			s.log(ctx).Errorf("mongostore: could not replace sessions of user %q: %v", user, err)
+			return err
+		}
+		res, err = s.collection.UpdateOne(ctx, filter, update, opts)
+	}
+	if err != nil {
+		s.log(ctx).Errorf("mongostore: could not save session %s: %v", id, err)
+		s.discardChunks(ctx, id, objID, gen)
 		return err
 	}
+	s.cacheInvalidate(ctx, id)
+	if touch && cond == nil && create && res.MatchedCount == 0 {
+		// The document was deleted since the session was loaded.
+		st.stored = nil
+		return s.write(ctx, r, session, cond, cookie)
+	}
+	if (cond != nil || !create) && res.MatchedCount == 0 {
+		s.discardChunks(ctx, id, objID, gen)
+		if cond == nil {
+			s.log(ctx).Debugf("mongostore: not resurrecting deleted session %s", id)
+			return ErrSessionDeleted
+		}
+		return errConditionFailed
+	}
+	if chunks > 0 || st.chunked {
+		// Delete the previous generation of chunks, or all of them once the
+		// data is no longer chunked.
+		if err := s.deleteStaleChunks(ctx, objID, gen); err != nil {
+			s.log(ctx).Warnf("mongostore: could not delete stale chunks of session %s: %v", id, err)
+		}
+		st.chunked = chunks > 0
+	}
+	if !touch {
+		stored := &Session{Data: encoded, DataCompression: compression, Serializer: serializerName(s.serializer()), DataModifiedAt: now}
+		if chunks > 0 || s.bsonValues {
+			stored = nil
+		}
+		st.stored = stored
+	}
+	st.created = res.UpsertedCount > 0
+	if res.UpsertedCount > 0 {
+		s.event(EventCreated)
+		st.version = 1
+		st.createdAt = now
+	} else {
+		st.version++
+	}
+	if staleID != "" {
+		if staleObjID, err := s.docID(staleID); err == nil {
+			if _, err := s.collection.DeleteMany(ctx, s.idFilter(staleObjID)); err != nil {
+				s.log(ctx).Errorf("mongostore: could not delete regenerated session %s: %v", staleID, err)
+				return err
+			}
+			if s.chunkSize > 0 {
+				if err := s.deleteChunks(ctx, staleObjID); err != nil {
+					s.log(ctx).Warnf("mongostore: could not delete chunks of session %s: %v", staleID, err)
+				}
+			}
+			s.cacheInvalidate(ctx, staleID)
+		}
+	}
+	if s.maxSessionsPerUser > 0 && user != "" {
+		s.evictUserSessions(ctx, user)
+	}
 	return nil
 }
 
+// discardChunks deletes the generation gen of chunks of the session with the
+// given ID and document ID objID, written by a save that did not update the
+// document, if gen is not zero.
+func (s *MongoStore) discardChunks(ctx context.Context, id string, objID interface{}, gen primitive.ObjectID) {
+	if gen.IsZero() {
+		return
+	}
+	if err := s.deleteChunkGen(ctx, objID, gen); err != nil {
+		s.log(ctx).Warnf("mongostore: could not delete unused chunks of session %s: %v", id, err)
+	}
+}
+
+// inTransaction runs fn in a transaction, in the MongoDB session carried by
+// ctx if any (see CausalContext), or else in a new one. The driver retries fn
+// on transient transaction errors.
+func (s *MongoStore) inTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	sess := mongo.SessionFromContext(ctx)
+	if sess == nil {
+		var err error
+		if sess, err = s.collection.Database().Client().StartSession(); err != nil {
+			return err
+		}
+		defer sess.EndSession(context.Background())
+	}
+	_, err := sess.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	return err
+}
+
+// encodeFailed wraps err, an error encoding the values of the session with
+// the given name and ID, identifying the session, and reports it to the hook
+// set with WithOnEncodeError.
+func (s *MongoStore) encodeFailed(ctx context.Context, name, id string, err error) error {
+	err = fmt.Errorf("mongostore: could not encode values of session %s named %q: %w", id, name, err)
+	s.log(ctx).Errorf("%v", err)
+	if s.onEncodeError != nil {
+		s.onEncodeError(name, err)
+	}
+	return err
+}
+
 // erase deletes a session document from the MongoDB collection.
+//
+// Deleting a session that does not exist is not an error, unless the store
+// was configured with WithStrictErase.
 func (s *MongoStore) erase(ctx context.Context, session *sessions.Session) error {
-	return s.collection.FindOneAndDelete(ctx, bson.M{"_id": session.ID}).Err()
+	return s.eraseID(ctx, session.ID)
+}
+
+// eraseID deletes the document of the session with the given ID, following
+// the same rules as erase.
+func (s *MongoStore) eraseID(ctx context.Context, sessionID string) (err error) {
+	if s.collection == nil {
+		return ErrNoCollection
+	}
+	defer s.observe(OpErase, time.Now(), &err)
+	release, err := s.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	id, err := s.docID(sessionID)
+	if err == nil {
+		s.writeBuffer.discard(id)
+		filter := s.scopeFilter(ctx, s.idFilter(id))
+		opts := options.FindOneAndDelete().SetSort(bson.D{{Key: "modifiedAt", Value: -1}})
+		res := s.collection.FindOneAndDelete(ctx, filter, opts)
+		err = res.Err()
+		if err == nil {
+			s.event(EventDestroyed)
+			// A legacy document left behind would be loaded instead.
+			if _, dupErr := s.collection.DeleteMany(ctx, filter); dupErr != nil {
+				s.log(ctx).Warnf("mongostore: could not delete duplicates of session %s: %v", sessionID, dupErr)
+			}
+		}
+		if raw, rawErr := res.DecodeBytes(); rawErr == nil && s.archiveCollection != nil {
+			s.archive(ctx, archiveErased, raw)
+		}
+		s.cacheInvalidate(ctx, sessionID)
+		if s.chunkSize > 0 && err == nil {
+			if chunkErr := s.deleteChunks(ctx, id); chunkErr != nil {
+				s.log(ctx).Warnf("mongostore: could not delete chunks of session %s: %v", sessionID, chunkErr)
+			}
+		}
+	} else {
+		err = mongo.ErrNoDocuments
+	}
+	if errors.Is(err, mongo.ErrNoDocuments) && !s.strictErase {
+		s.log(ctx).Debugf("mongostore: session %s already erased", sessionID)
+		return nil
+	}
+	if err != nil {
+		s.log(ctx).Errorf("mongostore: could not erase session %s: %v", sessionID, err)
+	}
+	return err
+}
+
+// scopeFilter returns filter merged with the load filter set with
+// WithLoadFilter, if any. The key field of filter always wins.
+func (s *MongoStore) scopeFilter(ctx context.Context, filter bson.M) bson.M {
+	if s.loadFilter == nil {
+		return filter
+	}
+	for k, v := range s.loadFilter(ctx) {
+		if _, ok := filter[k]; !ok {
+			filter[k] = v
+		}
+	}
+	return filter
+}
+
+// keyField returns the name of the document field holding session IDs.
+func (s *MongoStore) keyField() string {
+	if s.primaryKeyField == "" {
+		return "_id"
+	}
+	return s.primaryKeyField
+}
+
+// decodeDocument decodes the session document raw into doc, taking its ID
+// from the key field.
+func (s *MongoStore) decodeDocument(raw bson.Raw, doc *Session) error {
+	if hasBinaryData(raw) {
+		return s.decodeBinaryDocument(raw, doc)
+	}
+	return s.decodeFields(raw, doc)
+}
+
+// decodeFields decodes the fields of the session document raw into doc, like
+// decodeDocument, except for binary data.
+func (s *MongoStore) decodeFields(raw bson.Raw, doc *Session) error {
+	field := s.keyField()
+	key := raw.Lookup(field)
+	if s.stringIDs {
+		return s.decodeStringKeyed(raw, key, doc)
+	}
+	if key.Type == bsontype.String {
+		// Legacy document keyed by the hexadecimal string of the ID.
+		id, err := primitive.ObjectIDFromHex(key.StringValue())
+		if err != nil {
+			return fmt.Errorf("mongostore: session document has an invalid %s: %w", field, err)
+		}
+		if field == "_id" {
+			if raw, err = withoutField(raw, field); err != nil {
+				return err
+			}
+		}
+		if err := bson.Unmarshal(raw, doc); err != nil {
+			return err
+		}
+		doc.ID = id
+		return nil
+	}
+	if err := bson.Unmarshal(raw, doc); err != nil {
+		return err
+	}
+	if field != "_id" {
+		id, ok := key.ObjectIDOK()
+		if !ok {
+			return fmt.Errorf("mongostore: session document %s has no valid %s field", doc.ID.Hex(), field)
+		}
+		doc.ID = id
+	}
+	return nil
+}
+
+// decodeStringKeyed decodes the session document raw, whose key field holds
+// key, into doc for a store configured with WithStringIDs. Documents keyed by
+// an ObjectID get its hexadecimal string as session ID.
+func (s *MongoStore) decodeStringKeyed(raw bson.Raw, key bson.RawValue, doc *Session) error {
+	field := s.keyField()
+	var id string
+	switch key.Type {
+	case bsontype.String:
+		id = key.StringValue()
+	case bsontype.ObjectID:
+		id = key.ObjectID().Hex()
+	}
+	if id == "" {
+		return fmt.Errorf("mongostore: session document has no valid %s field", field)
+	}
+	if field == "_id" && key.Type == bsontype.String {
+		var err error
+		if raw, err = withoutField(raw, field); err != nil {
+			return err
+		}
+	}
+	if err := bson.Unmarshal(raw, doc); err != nil {
+		return err
+	}
+	doc.Key = id
+	return nil
+}
+
+// withoutField returns a copy of the document raw without the given field.
+func withoutField(raw bson.Raw, field string) (bson.Raw, error) {
+	elems, err := raw.Elements()
+	if err != nil {
+		return nil, err
+	}
+	d := make(bson.D, 0, len(elems))
+	for _, e := range elems {
+		if e.Key() != field {
+			d = append(d, bson.E{Key: e.Key(), Value: e.Value()})
+		}
+	}
+	return bson.Marshal(d)
+}
+
+// decodeResult decodes the session document of res into doc, like
+// decodeDocument.
+func (s *MongoStore) decodeResult(res *mongo.SingleResult, doc *Session) error {
+	raw, err := res.DecodeBytes()
+	if err != nil {
+		return err
+	}
+	return s.decodeDocument(raw, doc)
+}
+
+// validateCollectionName checks that name is a legal collection name in the
+// database named db.
+func validateCollectionName(db, name string) error {
+	switch {
+	case strings.ContainsAny(name, "$\x00"):
+		return fmt.Errorf("%w: %q contains '$' or a null character", ErrInvalidCollectionName, name)
+	case strings.HasPrefix(name, "system."):
+		return fmt.Errorf("%w: %q uses the reserved \"system.\" prefix", ErrInvalidCollectionName, name)
+	case len(db)+1+len(name) > maxNamespaceLength:
+		return fmt.Errorf("%w: namespace %q is longer than %d bytes", ErrInvalidCollectionName, db+"."+name, maxNamespaceLength)
+	}
+	return nil
 }
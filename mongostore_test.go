@@ -0,0 +1,1432 @@
+package mongostore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// testURIEnv names the environment variable holding the URI of the MongoDB
+// deployment integration tests run against. They are skipped when it is
+// unset.
+const testURIEnv = "MONGOSTORE_TEST_URI"
+
+var testKeyPairs = [][]byte{[]byte("0123456789abcdef0123456789abcdef")}
+
+// newUnitStore returns a store whose collection is never connected, for
+// tests that do not reach the database.
+func newUnitStore(t testing.TB, opts ...Option) *MongoStore {
+	t.Helper()
+	client, err := mongo.NewClient(options.Client().ApplyURI("mongodb://127.0.0.1:1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewMongoStoreWithOptions(client.Database("mongostore_test").Collection("sessions"), nil, testKeyPairs, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// newTestCollection returns a collection of the deployment named by
+// testURIEnv, dropped when the test ends, or skips the test. The client is
+// further configured with opts.
+func newTestCollection(t testing.TB, opts ...*options.ClientOptions) *mongo.Collection {
+	t.Helper()
+	uri := os.Getenv(testURIEnv)
+	if uri == "" {
+		t.Skipf("%s is not set", testURIEnv)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, append([]*options.ClientOptions{options.Client().ApplyURI(uri)}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := strings.NewReplacer("/", "_", " ", "_", "#", "_").Replace(t.Name())
+	c := client.Database("mongostore_test").Collection(name + "_" + primitive.NewObjectID().Hex())
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = c.Drop(ctx)
+		_ = client.Disconnect(ctx)
+	})
+	return c
+}
+
+// skipUnlessReplicaSet skips the test unless c belongs to a replica set.
+func skipUnlessReplicaSet(t testing.TB, c *mongo.Collection) {
+	t.Helper()
+	var hello bson.M
+	if err := c.Database().RunCommand(context.Background(), bson.M{"isMaster": 1}).Decode(&hello); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := hello["setName"]; !ok {
+		t.Skip("the deployment is not a replica set")
+	}
+}
+
+// commandRecorder records the commands of a given name sent by the clients it
+// monitors.
+type commandRecorder struct {
+	name string
+	mu   sync.Mutex
+	cmds []bson.Raw
+}
+
+func newCommandRecorder(name string) *commandRecorder {
+	return &commandRecorder{name: name}
+}
+
+// monitor returns the command monitor to set on the client options.
+func (c *commandRecorder) monitor() *event.CommandMonitor {
+	return &event.CommandMonitor{Started: func(_ context.Context, e *event.CommandStartedEvent) {
+		if e.CommandName == c.name {
+			c.mu.Lock()
+			c.cmds = append(c.cmds, e.Command)
+			c.mu.Unlock()
+		}
+	}}
+}
+
+// reset forgets the commands recorded so far.
+func (c *commandRecorder) reset() {
+	c.mu.Lock()
+	c.cmds = nil
+	c.mu.Unlock()
+}
+
+// commands returns the commands recorded since the last reset.
+func (c *commandRecorder) commands() []bson.Raw {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]bson.Raw(nil), c.cmds...)
+}
+
+// newTestStore returns a store backed by a collection created with
+// newTestCollection.
+func newTestStore(t testing.TB, opts ...Option) *MongoStore {
+	t.Helper()
+	s, err := NewMongoStoreWithOptions(newTestCollection(t), nil, testKeyPairs, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// saveSession saves session and returns the value of the cookie set, which
+// is empty if none was.
+func saveSession(t testing.TB, s *MongoStore, session *sessions.Session) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	if err := s.Save(httptest.NewRequest(http.MethodGet, "/", nil), w, session); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	return cookieValue(w, session.Name())
+}
+
+// cookieValue returns the value of the cookie with the given name set in w.
+func cookieValue(w *httptest.ResponseRecorder, name string) string {
+	for _, c := range w.Result().Cookies() {
+		if c.Name == name {
+			return c.Value
+		}
+	}
+	return ""
+}
+
+// loadSession decodes the session with the given name from its cookie value.
+func loadSession(t testing.TB, s *MongoStore, name, value string) *sessions.Session {
+	t.Helper()
+	session, err := s.DecodeSessionCookie(context.Background(), name, value)
+	if err != nil {
+		t.Fatalf("DecodeSessionCookie: %v", err)
+	}
+	return session
+}
+
+// setCreatedAt overwrites the creation date of the session document with the
+// given ID.
+func setCreatedAt(t testing.TB, s *MongoStore, id string, createdAt time.Time) {
+	t.Helper()
+	objID, err := s.parseID(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.collection.UpdateOne(context.Background(), bson.M{"_id": objID}, bson.M{"$set": bson.M{"createdAt": createdAt}})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMinCreatedAt(t *testing.T) {
+	cutoff := time.Now().Add(-time.Hour)
+	tests := []struct {
+		name      string
+		createdAt time.Time
+		wantNew   bool
+	}{
+		{"created before the cutoff", cutoff.Add(-time.Minute), true},
+		{"created after the cutoff", cutoff.Add(time.Minute), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore(t, WithMinCreatedAt(cutoff))
+			session := sessions.NewSession(s, "test")
+			session.Options = s.sessionOptions(session.Name())
+			session.Values["user"] = "alice"
+			value := saveSession(t, s, session)
+			setCreatedAt(t, s, session.ID, tt.createdAt)
+
+			loaded := loadSession(t, s, "test", value)
+			if loaded.IsNew != tt.wantNew {
+				t.Fatalf("IsNew = %v, want %v", loaded.IsNew, tt.wantNew)
+			}
+			if !tt.wantNew {
+				if loaded.ID != session.ID || loaded.Values["user"] != "alice" {
+					t.Fatalf("loaded session %s with %v", loaded.ID, loaded.Values)
+				}
+				return
+			}
+			if loaded.ID != "" || len(loaded.Values) != 0 {
+				t.Fatalf("stale session kept ID %q and values %v", loaded.ID, loaded.Values)
+			}
+			loaded.Values["user"] = "alice"
+			value = saveSession(t, s, loaded)
+			if loaded.ID == session.ID {
+				t.Fatalf("stale session saved under its old ID")
+			}
+			if again := loadSession(t, s, "test", value); again.IsNew || again.Values["user"] != "alice" {
+				t.Fatalf("session saved after the rejection does not load: IsNew = %v, values %v", again.IsNew, again.Values)
+			}
+		})
+	}
+}
+
+func TestLoadDocumentMinCreatedAt(t *testing.T) {
+	cutoff := time.Now().Add(-time.Hour)
+	tests := []struct {
+		name      string
+		createdAt time.Time
+		wantErr   error
+	}{
+		{"created before the cutoff", cutoff.Add(-time.Minute), errStaleSession},
+		{"created after the cutoff", cutoff.Add(time.Minute), nil},
+		{"without creation date", time.Time{}, errStaleSession},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newUnitStore(t, WithMinCreatedAt(cutoff), WithBSONValues(true))
+			values, err := bson.Marshal(bson.M{"user": "alice"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			doc := &Session{CreatedAt: tt.createdAt, ModifiedAt: time.Now(), Values: values}
+			session := sessions.NewSession(s, "test")
+			if err := s.loadDocument(context.Background(), session, doc); err != tt.wantErr {
+				t.Fatalf("loadDocument() = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil && len(session.Values) != 0 {
+				t.Fatalf("rejected session kept values %v", session.Values)
+			}
+		})
+	}
+}
+
+func TestAbsoluteTimeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		age     time.Duration
+		wantNew bool
+	}{
+		{"within the timeout", 30 * time.Minute, false},
+		{"past the timeout", 2 * time.Hour, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore(t, WithAbsoluteTimeout(time.Hour))
+			session := sessions.NewSession(s, "test")
+			session.Options = s.sessionOptions(session.Name())
+			session.Values["user"] = "alice"
+			value := saveSession(t, s, session)
+			setCreatedAt(t, s, session.ID, time.Now().Add(-tt.age))
+
+			loaded := loadSession(t, s, "test", value)
+			if loaded.IsNew != tt.wantNew {
+				t.Fatalf("IsNew = %v, want %v", loaded.IsNew, tt.wantNew)
+			}
+			if !tt.wantNew {
+				return
+			}
+			if loaded.ID != "" || len(loaded.Values) != 0 {
+				t.Fatalf("timed out session kept ID %q and values %v", loaded.ID, loaded.Values)
+			}
+			value = saveSession(t, s, loaded)
+			if loaded.ID == session.ID {
+				t.Fatalf("timed out session saved under its old ID")
+			}
+			if again := loadSession(t, s, "test", value); again.IsNew {
+				t.Fatalf("session saved after the timeout does not load")
+			}
+		})
+	}
+}
+
+func TestAbsoluteTimeoutCookieMaxAge(t *testing.T) {
+	s := newUnitStore(t, WithAbsoluteTimeout(time.Hour))
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	tests := []struct {
+		name string
+		age  time.Duration
+		want int
+	}{
+		{"just created", 0, 3600},
+		{"half way", 30 * time.Minute, 1800},
+		{"almost timed out", 59 * time.Minute, 60},
+		{"timed out", 2 * time.Hour, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session := sessions.NewSession(s, "test")
+			session.Options = s.sessionOptions(session.Name())
+			stateOf(session).createdAt = time.Now().Add(-tt.age)
+			got := s.cookieOptions(r, session).MaxAge
+			// Allow for the time elapsed since the creation date was set.
+			if got > tt.want || got < tt.want-1 {
+				t.Fatalf("MaxAge = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCookieOptionsResolver(t *testing.T) {
+	embedded := func(r *http.Request) *sessions.Options {
+		if r.Header.Get("Sec-Fetch-Dest") != "iframe" {
+			return nil
+		}
+		return &sessions.Options{Path: "/", Secure: true, HttpOnly: true, SameSite: http.SameSiteNoneMode}
+	}
+	tests := []struct {
+		name         string
+		resolve      func(r *http.Request) *sessions.Options
+		dest         string
+		wantSameSite http.SameSite
+		wantSecure   bool
+	}{
+		{"no resolver", nil, "iframe", http.SameSiteLaxMode, false},
+		{"top-level request", embedded, "document", http.SameSiteLaxMode, false},
+		{"embedded request", embedded, "iframe", http.SameSiteNoneMode, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newUnitStore(t, WithCookieOptionsResolver(tt.resolve))
+			s.Options = &sessions.Options{Path: "/", MaxAge: 3600, HttpOnly: true, SameSite: http.SameSiteLaxMode}
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Sec-Fetch-Dest", tt.dest)
+			session := sessions.NewSession(s, "test")
+			session.Options = s.sessionOptions(session.Name())
+			got := s.cookieOptions(r, session)
+			if got.SameSite != tt.wantSameSite || got.Secure != tt.wantSecure || got.MaxAge != 3600 {
+				t.Fatalf("cookie options = %+v, want SameSite %v, Secure %v, MaxAge 3600", got, tt.wantSameSite, tt.wantSecure)
+			}
+			if s.Options.SameSite != http.SameSiteLaxMode || s.Options.Secure {
+				t.Fatalf("store options mutated to %+v", s.Options)
+			}
+		})
+	}
+}
+
+func TestCookieOptionsDefaults(t *testing.T) {
+	defaults := sessions.Options{Path: "/app", Domain: "example.com", MaxAge: 3600, Secure: true, HttpOnly: true, SameSite: http.SameSiteLaxMode}
+	tests := []struct {
+		name    string
+		session sessions.Options
+		want    sessions.Options
+	}{
+		{"MaxAge only", sessions.Options{MaxAge: 60},
+			sessions.Options{Path: "/app", Domain: "example.com", MaxAge: 60, Secure: true, HttpOnly: true, SameSite: http.SameSiteLaxMode}},
+		{"explicit path", sessions.Options{Path: "/admin", MaxAge: 60},
+			sessions.Options{Path: "/admin", Domain: "example.com", MaxAge: 60, Secure: true, HttpOnly: true, SameSite: http.SameSiteLaxMode}},
+		{"explicit domain and SameSite", sessions.Options{Domain: "admin.example.com", MaxAge: 60, SameSite: http.SameSiteStrictMode},
+			sessions.Options{Path: "/app", Domain: "admin.example.com", MaxAge: 60, Secure: true, HttpOnly: true, SameSite: http.SameSiteStrictMode}},
+		{"no options set", sessions.Options{},
+			sessions.Options{Path: "/app", Domain: "example.com", Secure: true, HttpOnly: true, SameSite: http.SameSiteLaxMode}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newUnitStore(t)
+			store := defaults
+			s.Options = &store
+			session := sessions.NewSession(s, "test")
+			opts := tt.session
+			session.Options = &opts
+			got := s.cookieOptions(httptest.NewRequest(http.MethodGet, "/", nil), session)
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Fatalf("cookie options = %+v, want %+v", *got, tt.want)
+			}
+			if *s.Options != defaults || opts != tt.session {
+				t.Fatalf("store options %+v and session options %+v were mutated", *s.Options, opts)
+			}
+		})
+	}
+}
+
+func TestNewMongoStoreWithPrefix(t *testing.T) {
+	db := newUnitStore(t).collection.Database()
+	tests := []struct {
+		name     string
+		prefix   string
+		keyPairs [][]byte
+		want     string
+		wantErr  error
+	}{
+		{"no prefix", "", testKeyPairs, "sessions", nil},
+		{"prefix", "app1", testKeyPairs, "app1_sessions", nil},
+		{"dotted prefix", "app1.eu", testKeyPairs, "app1.eu_sessions", nil},
+		{"prefix with a dollar sign", "app$1", testKeyPairs, "", ErrInvalidCollectionName},
+		{"prefix with a null character", "app\x001", testKeyPairs, "", ErrInvalidCollectionName},
+		{"system prefix", "system.app", testKeyPairs, "", ErrInvalidCollectionName},
+		{"too long prefix", strings.Repeat("a", maxNamespaceLength), testKeyPairs, "", ErrInvalidCollectionName},
+		{"no key pair", "app1", nil, "", ErrNoKeyPairs},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewMongoStoreWithPrefix(db, tt.prefix, nil, tt.keyPairs...)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("NewMongoStoreWithPrefix() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := s.collection.Name(); got != tt.want {
+				t.Fatalf("collection %q, want %q", got, tt.want)
+			}
+			if got := s.Prefix(); got != tt.prefix {
+				t.Fatalf("Prefix() = %q, want %q", got, tt.prefix)
+			}
+		})
+	}
+}
+
+func TestDecodeSessionCookie(t *testing.T) {
+	s := newUnitStore(t)
+	encode := func(name, id string) string {
+		encoded, err := securecookie.EncodeMulti(name, id, s.Codecs...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return encoded
+	}
+	forged, err := securecookie.EncodeMulti("test", primitive.NewObjectID().Hex(),
+		securecookie.CodecsFromPairs([]byte("fedcba9876543210fedcba9876543210"))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name         string
+		value        string
+		wantErr      error
+		wantTampered bool
+	}{
+		{"empty cookie", "", nil, false},
+		{"forged cookie", forged, nil, true},
+		{"cookie of another session name", encode("other", primitive.NewObjectID().Hex()), nil, true},
+		{"malformed session ID", encode("test", "not an ID"), ErrInvalidID, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session, err := s.DecodeSessionCookie(context.Background(), "test", tt.value)
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("DecodeSessionCookie() error = %v, want %v", err, tt.wantErr)
+			}
+			if got := IsTampered(err); got != tt.wantTampered {
+				t.Fatalf("IsTampered(%v) = %v, want %v", err, got, tt.wantTampered)
+			}
+			if tt.wantErr == nil && !tt.wantTampered && err != nil {
+				t.Fatalf("DecodeSessionCookie() error = %v", err)
+			}
+			if session == nil || !session.IsNew || session.ID != "" || session.Name() != "test" || session.Options == nil {
+				t.Fatalf("DecodeSessionCookie() = %+v, want a new session named %q", session, "test")
+			}
+		})
+	}
+}
+
+func TestDecodeSessionCookieLoad(t *testing.T) {
+	s := newTestStore(t)
+	session := sessions.NewSession(s, "test")
+	session.Options = s.sessionOptions(session.Name())
+	session.Values["user"] = "alice"
+	value := saveSession(t, s, session)
+	tests := []struct {
+		name       string
+		cookieName string
+		wantLoaded bool
+	}{
+		{"same name", "test", true},
+		{"other name", "other", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loaded, err := s.DecodeSessionCookie(context.Background(), tt.cookieName, value)
+			if (err == nil) != tt.wantLoaded {
+				t.Fatalf("DecodeSessionCookie() error = %v, want loaded: %v", err, tt.wantLoaded)
+			}
+			if loaded.IsNew == tt.wantLoaded || (tt.wantLoaded && (loaded.ID != session.ID || loaded.Values["user"] != "alice")) {
+				t.Fatalf("DecodeSessionCookie() = session %q (new: %v) with %v, want loaded: %v", loaded.ID, loaded.IsNew, loaded.Values, tt.wantLoaded)
+			}
+		})
+	}
+}
+
+func TestNilCollection(t *testing.T) {
+	tests := []struct {
+		name  string
+		store *MongoStore
+	}{
+		{"zero store", &MongoStore{}},
+		{"store without collection", NewMongoStore(nil, nil, testKeyPairs...)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.store.Validate(); err != ErrNoCollection {
+				t.Fatalf("Validate() = %v, want %v", err, ErrNoCollection)
+			}
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			session, err := tt.store.New(r, "test")
+			if err != ErrNoCollection {
+				t.Fatalf("New() error = %v, want %v", err, ErrNoCollection)
+			}
+			if session == nil || session.Options == nil {
+				t.Fatalf("New() returned a session without options")
+			}
+			session.Values["user"] = "alice"
+			if err := tt.store.Save(r, httptest.NewRecorder(), session); err != ErrNoCollection {
+				t.Fatalf("Save() = %v, want %v", err, ErrNoCollection)
+			}
+			session.Options.MaxAge = -1
+			if err := tt.store.Save(r, httptest.NewRecorder(), session); err != ErrNoCollection {
+				t.Fatalf("Save() of an erased session = %v, want %v", err, ErrNoCollection)
+			}
+		})
+	}
+}
+
+// tenantKey is the context key of the tenant in TestLoadFilter.
+type tenantKey struct{}
+
+func TestLoadFilter(t *testing.T) {
+	tenantFilter := WithLoadFilter(func(ctx context.Context) bson.M {
+		tenant, _ := ctx.Value(tenantKey{}).(string)
+		return bson.M{"tenant": tenant}
+	})
+	tests := []struct {
+		name string
+		op   func(ctx context.Context, s *MongoStore, id, cookie string) error
+	}{
+		{"load", func(ctx context.Context, s *MongoStore, id, cookie string) error {
+			session, err := s.DecodeSessionCookie(ctx, "test", cookie)
+			if err == nil && session.IsNew {
+				return ErrSessionNotFound
+			}
+			return err
+		}},
+		{"Lease", func(ctx context.Context, s *MongoStore, id, cookie string) error {
+			_, release, err := s.Lease(ctx, id, time.Minute)
+			if err == nil {
+				err = release()
+			}
+			return err
+		}},
+		{"GetValue", func(ctx context.Context, s *MongoStore, id, cookie string) error {
+			_, _, err := s.GetValue(ctx, id, "user")
+			return err
+		}},
+		{"RawData", func(ctx context.Context, s *MongoStore, id, cookie string) error {
+			_, _, err := s.RawData(ctx, id)
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore(t, tenantFilter, WithBSONValues(true))
+			tenantA := context.WithValue(context.Background(), tenantKey{}, "a")
+			tenantB := context.WithValue(context.Background(), tenantKey{}, "b")
+			session := sessions.NewSession(s, "test")
+			session.Options = s.sessionOptions(session.Name())
+			session.Values["user"] = "alice"
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(tenantA)
+			if err := s.Save(r, w, session); err != nil {
+				t.Fatal(err)
+			}
+			cookie := cookieValue(w, "test")
+
+			if err := tt.op(tenantB, s, session.ID, cookie); err != ErrSessionNotFound && err != nil {
+				t.Fatalf("other tenant: %v", err)
+			} else if err == nil {
+				t.Fatalf("other tenant reached the session")
+			}
+			if err := tt.op(tenantA, s, session.ID, cookie); err != nil {
+				t.Fatalf("same tenant: %v", err)
+			}
+		})
+	}
+}
+
+func TestCookieTooLarge(t *testing.T) {
+	tests := []struct {
+		name    string
+		session string
+		tooBig  bool
+	}{
+		{"short name", "test", false},
+		{"name filling the cookie", strings.Repeat("s", maxCookieSize), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The client is not connected: only saves rejected before
+			// writing the document fail with ErrCookieTooLarge.
+			s := newUnitStore(t)
+			session := sessions.NewSession(s, tt.session)
+			session.Options = s.sessionOptions(session.Name())
+			w := httptest.NewRecorder()
+			err := s.Save(httptest.NewRequest(http.MethodGet, "/", nil), w, session)
+			if got := errors.Is(err, ErrCookieTooLarge); got != tt.tooBig {
+				t.Fatalf("Save() = %v, want ErrCookieTooLarge: %v", err, tt.tooBig)
+			}
+			if err == nil {
+				t.Fatal("Save() succeeded with a disconnected client")
+			}
+			if cookies := w.Result().Cookies(); len(cookies) != 0 {
+				t.Fatalf("failed save set cookies %v", cookies)
+			}
+		})
+	}
+}
+
+func TestRegenerateOnChange(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name          string
+		authenticated interface{}
+		reset         bool
+		regenerated   bool
+	}{
+		{"login", true, false, true},
+		{"login resetting values", true, true, true},
+		{"unchanged", false, false, false},
+		{"unchanged resetting values", false, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCollection(t)
+			skipUnlessReplicaSet(t, c)
+			s, err := NewMongoStoreWithOptions(c, nil, testKeyPairs, WithRegenerateOnChange("authenticated"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			session := sessions.NewSession(s, "test")
+			session.Options = s.sessionOptions(session.Name())
+			session.Values["authenticated"] = false
+			value := saveSession(t, s, session)
+			oldID := session.ID
+
+			loaded := loadSession(t, s, "test", value)
+			if tt.reset {
+				loaded.Values = map[interface{}]interface{}{"authenticated": tt.authenticated}
+			} else {
+				loaded.Values["authenticated"] = tt.authenticated
+			}
+			w := httptest.NewRecorder()
+			if err := s.Save(httptest.NewRequest(http.MethodGet, "/", nil), w, loaded); err != nil {
+				t.Fatal(err)
+			}
+			if got := loaded.ID != oldID; got != tt.regenerated {
+				t.Fatalf("session ID %s after saving %s, want regenerated: %v", loaded.ID, oldID, tt.regenerated)
+			}
+			if got := cookieValue(w, "test") != ""; got != tt.regenerated {
+				t.Fatalf("cookie reissued: %v, want %v", got, tt.regenerated)
+			}
+			n, err := c.CountDocuments(ctx, bson.M{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != 1 {
+				t.Fatalf("%d session documents, want 1", n)
+			}
+			if tt.regenerated {
+				if reloaded := loadSession(t, s, "test", cookieValue(w, "test")); reloaded.IsNew || reloaded.Values["authenticated"] != true {
+					t.Fatalf("regenerated session loaded with %v (new: %v)", reloaded.Values, reloaded.IsNew)
+				}
+			}
+		})
+	}
+}
+
+func TestSessionState(t *testing.T) {
+	tests := []struct {
+		name    string
+		reset   bool
+		value   interface{}
+		changed bool
+	}{
+		{"untouched", false, false, false},
+		{"changed", false, true, true},
+		{"values reset", true, false, false},
+		{"values reset and changed", true, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var validated map[interface{}]interface{}
+			s := newUnitStore(t, WithRegenerateOnChange("authenticated"), WithLoadValidator(func(session *sessions.Session) error {
+				validated = session.Values
+				return nil
+			}))
+			session := sessions.NewSession(s, "test")
+			session.Values["authenticated"] = false
+			data, _, err := s.encodeData(session, session.Values)
+			if err != nil {
+				t.Fatal(err)
+			}
+			session.Values = make(map[interface{}]interface{})
+			doc := &Session{ModifiedAt: time.Now(), Data: data, Serializer: serializerName(s.serializer())}
+			if err := s.loadDocument(context.Background(), session, doc); err != nil {
+				t.Fatal(err)
+			}
+			session.IsNew = false
+			want := map[interface{}]interface{}{"authenticated": false}
+			if !reflect.DeepEqual(validated, want) || !reflect.DeepEqual(session.Values, want) {
+				t.Fatalf("loaded values %v, validated %v, want %v", session.Values, validated, want)
+			}
+			if tt.reset {
+				session.Values = map[interface{}]interface{}{"authenticated": tt.value}
+			} else {
+				session.Values["authenticated"] = tt.value
+			}
+			if got := s.watchedValueChanged(session); got != tt.changed {
+				t.Fatalf("watchedValueChanged() = %v, want %v", got, tt.changed)
+			}
+		})
+	}
+}
+
+func TestWatchedValueUnknown(t *testing.T) {
+	tests := []struct {
+		name    string
+		isNew   bool
+		changed bool
+	}{
+		{"new session", true, false},
+		{"stored session", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newUnitStore(t, WithRegenerateOnChange("authenticated"))
+			session := sessions.NewSession(s, "test")
+			session.IsNew = tt.isNew
+			session.Values["authenticated"] = true
+			if got := s.watchedValueChanged(session); got != tt.changed {
+				t.Fatalf("watchedValueChanged() = %v, want %v", got, tt.changed)
+			}
+		})
+	}
+}
+
+func TestRegenerateOnChangeFailure(t *testing.T) {
+	// The client is not connected: the transaction cannot start.
+	s := newUnitStore(t, WithRegenerateOnChange("authenticated"))
+	session := sessions.NewSession(s, "test")
+	session.Options = s.sessionOptions(session.Name())
+	session.ID = primitive.NewObjectID().Hex()
+	// A loaded session whose watched value is unknown is regenerated.
+	session.IsNew = false
+	session.Values["authenticated"] = true
+	oldID := session.ID
+	if err := s.Save(httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRecorder(), session); err == nil {
+		t.Fatal("Save() succeeded with a disconnected client")
+	}
+	if session.ID != oldID {
+		t.Fatalf("failed regeneration left session ID %s, want %s", session.ID, oldID)
+	}
+}
+
+func TestErase(t *testing.T) {
+	tests := []struct {
+		name    string
+		strict  bool
+		present bool
+		wantErr error
+	}{
+		{"present session", false, true, nil},
+		{"absent session", false, false, nil},
+		{"present session with strict erase", true, true, nil},
+		{"absent session with strict erase", true, false, mongo.ErrNoDocuments},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore(t, WithStrictErase(tt.strict))
+			session := sessions.NewSession(s, "test")
+			session.Options = s.sessionOptions(session.Name())
+			session.Values["user"] = "alice"
+			saveSession(t, s, session)
+			if !tt.present {
+				if err := s.eraseID(context.Background(), session.ID); err != nil {
+					t.Fatal(err)
+				}
+			}
+			session.Options.MaxAge = -1
+			w := httptest.NewRecorder()
+			err := s.Save(httptest.NewRequest(http.MethodGet, "/", nil), w, session)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Save() = %v, want %v", err, tt.wantErr)
+			}
+			cookies := w.Result().Cookies()
+			if len(cookies) != 1 || cookies[0].MaxAge >= 0 {
+				t.Fatalf("erase set cookies %v, want the cleared session cookie", cookies)
+			}
+			n, err := s.collection.CountDocuments(context.Background(), bson.M{})
+			if err != nil || n != 0 {
+				t.Fatalf("%d documents left, %v", n, err)
+			}
+		})
+	}
+}
+
+func TestDoubleLogout(t *testing.T) {
+	tests := []struct {
+		name    string
+		strict  bool
+		wantErr error
+	}{
+		{"lenient erase", false, nil},
+		{"strict erase", true, mongo.ErrNoDocuments},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore(t, WithStrictErase(tt.strict))
+			session := sessions.NewSession(s, "test")
+			session.Options = s.sessionOptions(session.Name())
+			session.Values["user"] = "alice"
+			value := saveSession(t, s, session)
+
+			// Two requests of the same user log out concurrently.
+			first, second := loadSession(t, s, "test", value), loadSession(t, s, "test", value)
+			first.Options.MaxAge = -1
+			second.Options.MaxAge = -1
+			if err := s.Save(httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRecorder(), first); err != nil {
+				t.Fatalf("first logout: %v", err)
+			}
+			w := httptest.NewRecorder()
+			if err := s.Save(httptest.NewRequest(http.MethodGet, "/", nil), w, second); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("second logout = %v, want %v", err, tt.wantErr)
+			}
+			if cookies := w.Result().Cookies(); len(cookies) != 1 || cookies[0].MaxAge >= 0 {
+				t.Fatalf("second logout set cookies %v, want the cleared session cookie", cookies)
+			}
+		})
+	}
+}
+
+func TestEraseByCookie(t *testing.T) {
+	tests := []struct {
+		name    string
+		cookie  func(valid string) string
+		erased  bool
+		wantErr bool
+	}{
+		{"valid cookie", func(valid string) string { return valid }, false, false},
+		{"valid cookie of an erased session", func(valid string) string { return valid }, true, false},
+		{"invalid cookie", func(valid string) string { return valid + "x" }, false, true},
+		{"empty cookie", func(string) string { return "" }, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := newTestStore(t)
+			session := sessions.NewSession(s, "test")
+			session.Options = s.sessionOptions(session.Name())
+			value := saveSession(t, s, session)
+			if tt.erased {
+				if err := s.eraseID(ctx, session.ID); err != nil {
+					t.Fatal(err)
+				}
+			}
+			err := s.EraseByCookie(ctx, "test", tt.cookie(value))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("EraseByCookie() = %v, want error: %v", err, tt.wantErr)
+			}
+			want := int64(0)
+			if tt.wantErr {
+				want = 1
+			}
+			n, err := s.collection.CountDocuments(ctx, bson.M{})
+			if err != nil || n != want {
+				t.Fatalf("%d documents left, %v, want %d", n, err, want)
+			}
+		})
+	}
+}
+
+func TestEmptyIDLoad(t *testing.T) {
+	tests := []struct {
+		name string
+		id   string
+	}{
+		{"empty ID", ""},
+		{"malformed ID", "not an ID"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The unit store fails any query, so a not-found result means
+			// load returned before reaching the database.
+			s := newUnitStore(t)
+			session := sessions.NewSession(s, "test")
+			session.ID = tt.id
+			if err := s.load(context.Background(), session); !errors.Is(err, mongo.ErrNoDocuments) {
+				t.Fatalf("load() = %v, want mongo.ErrNoDocuments", err)
+			}
+		})
+	}
+}
+
+func TestEmptyIDSave(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  []Option
+		isNew bool
+	}{
+		{"new session", nil, true},
+		{"loaded session", nil, false},
+		{"loaded session without resurrection", []Option{WithNoResurrection(true)}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore(t, tt.opts...)
+			session := sessions.NewSession(s, "test")
+			session.Options = s.sessionOptions(session.Name())
+			session.IsNew = tt.isNew
+			session.Values["user"] = "alice"
+			value := saveSession(t, s, session)
+			if _, err := s.parseID(session.ID); err != nil {
+				t.Fatalf("session saved with ID %q: %v", session.ID, err)
+			}
+			if loaded := loadSession(t, s, "test", value); loaded.IsNew || loaded.ID != session.ID {
+				t.Fatalf("loaded session %q (new: %v), want %q", loaded.ID, loaded.IsNew, session.ID)
+			}
+		})
+	}
+}
+
+func TestTouchUpdate(t *testing.T) {
+	tests := []struct {
+		name     string
+		change   bool
+		wantData bool
+	}{
+		{"touch", false, false},
+		{"value change", true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := newCommandRecorder("update")
+			s, err := NewMongoStoreWithOptions(newTestCollection(t, options.Client().SetMonitor(recorder.monitor())), nil, testKeyPairs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			session := sessions.NewSession(s, "test")
+			session.Options = s.sessionOptions(session.Name())
+			session.Values["user"] = "alice"
+			value := saveSession(t, s, session)
+			loaded := loadSession(t, s, "test", value)
+			if tt.change {
+				loaded.Values["user"] = "bob"
+			}
+			recorder.reset()
+			saveSession(t, s, loaded)
+			cmds := recorder.commands()
+			if len(cmds) != 1 {
+				t.Fatalf("%d update commands, want 1", len(cmds))
+			}
+			set, err := cmds[0].LookupErr("updates", "0", "u", "$set")
+			if err != nil {
+				t.Fatalf("update %s has no $set: %v", cmds[0], err)
+			}
+			if _, err := set.Document().LookupErr("modifiedAt"); err != nil {
+				t.Fatalf("update sets %s, want modifiedAt", set)
+			}
+			if _, err := set.Document().LookupErr("data"); (err == nil) != tt.wantData {
+				t.Fatalf("update sets %s, want data: %v", set, tt.wantData)
+			}
+		})
+	}
+}
+
+func TestConditionalSetCookie(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       []Option
+		change     func(session *sessions.Session)
+		wantCookie bool
+	}{
+		{"unchanged session", nil, func(*sessions.Session) {}, false},
+		{"changed values", nil, func(session *sessions.Session) { session.Values["user"] = "bob" }, false},
+		{"erased session", nil, func(session *sessions.Session) { session.Options.MaxAge = -1 }, true},
+		{"always set cookie", []Option{WithAlwaysSetCookie(true)}, func(*sessions.Session) {}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore(t, tt.opts...)
+			session := sessions.NewSession(s, "test")
+			session.Options = s.sessionOptions(session.Name())
+			session.Values["user"] = "alice"
+			value := saveSession(t, s, session)
+			if value == "" {
+				t.Fatal("no cookie set for a new session")
+			}
+			loaded := loadSession(t, s, "test", value)
+			tt.change(loaded)
+			w := httptest.NewRecorder()
+			if err := s.Save(httptest.NewRequest(http.MethodGet, "/", nil), w, loaded); err != nil {
+				t.Fatal(err)
+			}
+			if got := w.Header().Get("Set-Cookie") != ""; got != tt.wantCookie {
+				t.Fatalf("Set-Cookie %q, want a cookie: %v", w.Header().Get("Set-Cookie"), tt.wantCookie)
+			}
+		})
+	}
+}
+
+func TestSaveWithResult(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        []Option
+		existing    bool
+		preset      bool
+		wantCreated bool
+	}{
+		{"new session", nil, false, false, true},
+		{"new session with a preset ID", nil, false, true, true},
+		{"existing session", nil, true, false, false},
+		{"new buffered session", []Option{WithWriteBuffer(100, 0)}, false, false, true},
+		{"existing buffered session", []Option{WithWriteBuffer(100, 0)}, true, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore(t, tt.opts...)
+			session := sessions.NewSession(s, "test")
+			session.Options = s.sessionOptions(session.Name())
+			session.Values["user"] = "alice"
+			if tt.preset {
+				session.ID = primitive.NewObjectID().Hex()
+			}
+			if tt.existing {
+				value := saveSession(t, s, session)
+				if err := s.Flush(context.Background()); err != nil {
+					t.Fatal(err)
+				}
+				session = loadSession(t, s, "test", value)
+				session.Values["user"] = "bob"
+			}
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			result, err := s.SaveWithResult(r, httptest.NewRecorder(), session)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Created != tt.wantCreated || result.ID != session.ID || result.ID == "" {
+				t.Fatalf("SaveWithResult() = %+v, want Created: %v and ID %q", result, tt.wantCreated, session.ID)
+			}
+		})
+	}
+}
+
+func TestNewFromCookieHeader(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   func(value string) string
+		wantLoad bool
+	}{
+		{"present cookie", func(value string) string { return "test=" + value }, true},
+		{"several cookies", func(value string) string { return "theme=dark; test=" + value + "; lang=fr" }, true},
+		{"absent cookie", func(string) string { return "theme=dark; lang=fr" }, false},
+		{"cookie of another name", func(value string) string { return "other=" + value }, false},
+		{"empty header", func(string) string { return "" }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore(t)
+			session := sessions.NewSession(s, "test")
+			session.Options = s.sessionOptions(session.Name())
+			session.Values["user"] = "alice"
+			value := saveSession(t, s, session)
+			got, err := s.NewFromCookieHeader(context.Background(), tt.header(value), "test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.IsNew == tt.wantLoad {
+				t.Fatalf("session new: %v, want loaded: %v", got.IsNew, tt.wantLoad)
+			}
+			if tt.wantLoad && (got.ID != session.ID || got.Values["user"] != "alice") {
+				t.Fatalf("loaded session %q with %v, want %q", got.ID, got.Values, session.ID)
+			}
+		})
+	}
+}
+
+func TestModificationDate(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       []Option
+		modifiedAt time.Time
+		wantErr    error
+	}{
+		{"valid timestamp", nil, time.Now(), nil},
+		{"zero timestamp", nil, time.Time{}, ErrInvalidModificationDate},
+		{"zero timestamp allowed", []Option{WithMissingModifiedAtAllowed(true)}, time.Time{}, nil},
+		{"valid timestamp allowed", []Option{WithMissingModifiedAtAllowed(true)}, time.Now(), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newUnitStore(t, tt.opts...)
+			session := sessions.NewSession(s, "test")
+			data, _, err := s.encodeData(session, map[interface{}]interface{}{"user": "alice"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = s.loadDocument(context.Background(), session, &Session{Data: data, ModifiedAt: tt.modifiedAt})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("loadDocument() = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && session.Values["user"] != "alice" {
+				t.Fatalf("loaded values %v", session.Values)
+			}
+		})
+	}
+}
+
+func TestNewMongoStoreWithGeneratedKeys(t *testing.T) {
+	c := newUnitStore(t).collection
+	s, hashKey, blockKey, err := NewMongoStoreWithGeneratedKeys(c, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hashKey) != generatedHashKeyLength || len(blockKey) != generatedBlockKeyLength {
+		t.Fatalf("generated keys of %d and %d bytes, want %d and %d", len(hashKey), len(blockKey), generatedHashKeyLength, generatedBlockKeyLength)
+	}
+	other, otherHashKey, otherBlockKey, err := NewMongoStoreWithGeneratedKeys(c, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(hashKey, otherHashKey) || bytes.Equal(blockKey, otherBlockKey) {
+		t.Fatal("NewMongoStoreWithGeneratedKeys() generated the same keys twice")
+	}
+	encoded, err := securecookie.EncodeMulti("test", "value", s.Codecs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name         string
+		store        *MongoStore
+		wantTampered bool
+	}{
+		{"generating store", s, false},
+		{"store with the returned keys", NewMongoStore(c, nil, hashKey, blockKey), false},
+		{"store with other generated keys", other, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var decoded string
+			err := tt.store.decodeMulti("test", encoded, &decoded)
+			if got := IsTampered(err); got != tt.wantTampered {
+				t.Fatalf("IsTampered(%v) = %v, want %v", err, got, tt.wantTampered)
+			}
+			if !tt.wantTampered && decoded != "value" {
+				t.Fatalf("decoded %q, want %q", decoded, "value")
+			}
+		})
+	}
+}
+
+func TestEncodeError(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  []Option
+		value interface{}
+	}{
+		{"gob channel", nil, make(chan int)},
+		{"gob function", nil, func() {}},
+		{"JSON channel", []Option{WithSerializer(JSONSerializer{})}, make(chan int)},
+		{"JSON non-string key", []Option{WithSerializer(JSONSerializer{})}, map[interface{}]interface{}{42: "answer"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hookNames []string
+			var hookErr error
+			s := newUnitStore(t, append([]Option{WithOnEncodeError(func(name string, err error) {
+				hookNames = append(hookNames, name)
+				hookErr = err
+			})}, tt.opts...)...)
+			session := sessions.NewSession(s, "prefs")
+			session.Options = s.sessionOptions(session.Name())
+			session.Values["value"] = tt.value
+			w := httptest.NewRecorder()
+			err := s.Save(httptest.NewRequest(http.MethodGet, "/", nil), w, session)
+			if err == nil {
+				t.Fatal("Save() succeeded, want an encode error")
+			}
+			if session.ID == "" || !strings.Contains(err.Error(), `"prefs"`) || !strings.Contains(err.Error(), session.ID) {
+				t.Fatalf("Save() = %v, want it to identify session %s named %q", err, session.ID, "prefs")
+			}
+			if len(hookNames) != 1 || hookNames[0] != "prefs" || hookErr == nil || hookErr.Error() != err.Error() {
+				t.Fatalf("OnEncodeError called with %q, %v, want %q, %v", hookNames, hookErr, "prefs", err)
+			}
+			if cookie := cookieValue(w, "prefs"); cookie != "" {
+				t.Fatalf("Save() set cookie %q despite the encode error", cookie)
+			}
+		})
+	}
+}
+
+func TestNoResurrection(t *testing.T) {
+	tests := []struct {
+		name          string
+		noResurrect   bool
+		change        bool
+		wantErr       error
+		wantDocuments int64
+	}{
+		{"changed session resurrected", false, true, nil, 1},
+		{"unchanged session resurrected", false, false, nil, 1},
+		{"changed session not resurrected", true, true, ErrSessionDeleted, 0},
+		{"unchanged session not resurrected", true, false, ErrSessionDeleted, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore(t, WithNoResurrection(tt.noResurrect))
+			session := sessions.NewSession(s, "test")
+			session.Options = s.sessionOptions(session.Name())
+			session.IsNew = true
+			session.Values["user"] = "alice"
+			value := saveSession(t, s, session)
+
+			// Request A logs out while request B holds the session.
+			a, b := loadSession(t, s, "test", value), loadSession(t, s, "test", value)
+			a.Options.MaxAge = -1
+			saveSession(t, s, a)
+			if tt.change {
+				b.Values["page"] = "/home"
+			}
+			err := s.Save(httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRecorder(), b)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Save() = %v, want %v", err, tt.wantErr)
+			}
+			docID, err := s.docID(session.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			n, err := s.collection.CountDocuments(context.Background(), s.idFilter(docID))
+			if err != nil || n != tt.wantDocuments {
+				t.Fatalf("%d documents, %v, want %d", n, err, tt.wantDocuments)
+			}
+		})
+	}
+}
+
+func TestForeignFields(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   []Option
+		change bool
+	}{
+		{"value change", nil, true},
+		{"touch", nil, false},
+		{"BSON values", []Option{WithBSONValues(true)}, true},
+		{"chunked data", []Option{WithChunking(64)}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := newTestStore(t, tt.opts...)
+			session := sessions.NewSession(s, "test")
+			session.Options = s.sessionOptions(session.Name())
+			session.Values["user"] = "alice"
+			value := saveSession(t, s, session)
+			docID, err := s.docID(session.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Another system annotates the session document.
+			foreign := bson.M{"audit": bson.M{"reviewedBy": "bob"}, "riskScore": int32(7)}
+			if _, err := s.collection.UpdateOne(ctx, s.idFilter(docID), bson.M{"$set": foreign}); err != nil {
+				t.Fatal(err)
+			}
+
+			loaded := loadSession(t, s, "test", value)
+			if tt.change {
+				loaded.Values["page"] = strings.Repeat("x", 256)
+			}
+			saveSession(t, s, loaded)
+			var doc bson.M
+			if err := s.collection.FindOne(ctx, s.idFilter(docID)).Decode(&doc); err != nil {
+				t.Fatal(err)
+			}
+			for k, want := range foreign {
+				if got := doc[k]; !reflect.DeepEqual(got, want) {
+					t.Errorf("field %s = %v after save, want %v", k, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadValidator(t *testing.T) {
+	requireUser := WithLoadValidator(func(session *sessions.Session) error {
+		if _, ok := session.Values["user"].(string); !ok {
+			return errors.New("no user")
+		}
+		return nil
+	})
+	tests := []struct {
+		name        string
+		opts        []Option
+		values      map[interface{}]interface{}
+		wantValid   bool
+		wantDeleted bool
+	}{
+		{"valid session", nil, map[interface{}]interface{}{"user": "alice"}, true, false},
+		{"malformed session", nil, map[interface{}]interface{}{"page": "/home"}, false, false},
+		{"malformed session deleted", []Option{WithDeleteInvalidSessions(true)}, map[interface{}]interface{}{"page": "/home"}, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore(t, append([]Option{requireUser}, tt.opts...)...)
+			session := sessions.NewSession(s, "test")
+			session.Options = s.sessionOptions(session.Name())
+			for k, v := range tt.values {
+				session.Values[k] = v
+			}
+			value := saveSession(t, s, session)
+
+			loaded := loadSession(t, s, "test", value)
+			if tt.wantValid {
+				if loaded.IsNew || loaded.ID != session.ID || loaded.Values["user"] != "alice" {
+					t.Fatalf("loaded session %q (new: %v) with %v, want the saved session", loaded.ID, loaded.IsNew, loaded.Values)
+				}
+				return
+			}
+			if !loaded.IsNew || loaded.ID == session.ID || len(loaded.Values) != 0 {
+				t.Fatalf("loaded session %q (new: %v) with %v, want a fresh session", loaded.ID, loaded.IsNew, loaded.Values)
+			}
+			docID, err := s.docID(session.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			n, err := s.collection.CountDocuments(context.Background(), s.idFilter(docID))
+			if err != nil || (n == 0) != tt.wantDeleted {
+				t.Fatalf("%d documents of the invalid session, %v, want deleted: %v", n, err, tt.wantDeleted)
+			}
+		})
+	}
+}
+
+func TestValueMigration(t *testing.T) {
+	// upcast replaces the permissions array of old sessions by a perms map.
+	upcast := func(values map[interface{}]interface{}) bool {
+		permissions, ok := values["permissions"].([]interface{})
+		if !ok {
+			return false
+		}
+		perms := make(map[string]interface{}, len(permissions))
+		for _, p := range permissions {
+			perms[fmt.Sprint(p)] = true
+		}
+		values["perms"] = perms
+		delete(values, "permissions")
+		return true
+	}
+	tests := []struct {
+		name   string
+		opts   []Option
+		stored bson.M
+		want   map[interface{}]interface{}
+	}{
+		{"old shape", []Option{WithValueMigration(upcast)},
+			bson.M{"permissions": bson.A{"read", "write"}},
+			map[interface{}]interface{}{"perms": map[string]interface{}{"read": true, "write": true}}},
+		{"new shape", []Option{WithValueMigration(upcast)},
+			bson.M{"perms": bson.M{"read": true}},
+			map[interface{}]interface{}{"perms": map[string]interface{}{"read": true}}},
+		{"without migration", nil,
+			bson.M{"permissions": bson.A{"read"}},
+			map[interface{}]interface{}{"permissions": []interface{}{"read"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newUnitStore(t, append([]Option{WithBSONValues(true)}, tt.opts...)...)
+			values, err := bson.Marshal(tt.stored)
+			if err != nil {
+				t.Fatal(err)
+			}
+			session := sessions.NewSession(s, "test")
+			if err := s.loadDocument(context.Background(), session, &Session{ModifiedAt: time.Now(), Values: values}); err != nil {
+				t.Fatal(err)
+			}
+			if got := session.Values; !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("loaded values %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNoCodecsOnLoad(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name string
+		op   func(s *MongoStore, cookie string) error
+	}{
+		{"DecodeSessionCookie", func(s *MongoStore, cookie string) error {
+			session, err := s.DecodeSessionCookie(ctx, "test", cookie)
+			if session == nil || !session.IsNew {
+				t.Errorf("DecodeSessionCookie() returned %v, want a new session", session)
+			}
+			return err
+		}},
+		{"loadData", func(s *MongoStore, cookie string) error {
+			return s.loadData(ctx, sessions.NewSession(s, "test"), &Session{Data: cookie})
+		}},
+		{"EraseByCookie", func(s *MongoStore, cookie string) error {
+			return s.EraseByCookie(ctx, "test", cookie)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newUnitStore(t)
+			cookie, err := securecookie.EncodeMulti("test", primitive.NewObjectID().Hex(), s.Codecs...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The keys were removed at runtime.
+			s.Codecs = nil
+			if err := tt.op(s, cookie); !errors.Is(err, ErrNoKeyPairs) {
+				t.Fatalf("error = %v, want ErrNoKeyPairs", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,13 @@
+module github.com/SpecialFlocon/mongostore/mongostoreotel
+
+go 1.20
+
+require (
+	github.com/SpecialFlocon/mongostore v0.0.0
+	github.com/gorilla/sessions v1.2.1
+	go.mongodb.org/mongo-driver v1.4.2
+	go.opentelemetry.io/otel/metric v1.24.0
+	go.opentelemetry.io/otel/sdk/metric v1.24.0
+)
+
+replace github.com/SpecialFlocon/mongostore => ../
@@ -0,0 +1,78 @@
+// Package mongostoreotel counts the sessions created, loaded and destroyed by
+// a mongostore.MongoStore as OpenTelemetry metrics, by subscribing to the
+// store hooks. It lives in its own module so that the mongostore package
+// does not depend on OpenTelemetry.
+//
+//	metrics, err := mongostoreotel.NewMetrics(otel.GetMeterProvider())
+//	if err != nil {
+//		return err
+//	}
+//	store, err := mongostore.NewMongoStoreWithOptions(c, nil, keyPairs,
+//		mongostore.WithHooks(metrics.Hooks()))
+package mongostoreotel
+
+import (
+	"context"
+
+	"github.com/SpecialFlocon/mongostore"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// ScopeName is the instrumentation scope of the meter the counters are
+// created with.
+const ScopeName = "github.com/SpecialFlocon/mongostore"
+
+// Names of the counters.
+const (
+	CreatedCounterName   = "mongostore.sessions.created"
+	LoadedCounterName    = "mongostore.sessions.loaded"
+	DestroyedCounterName = "mongostore.sessions.destroyed"
+)
+
+// Metrics holds the counters fed by the hooks of a store.
+type Metrics struct {
+	created   metric.Int64Counter
+	loaded    metric.Int64Counter
+	destroyed metric.Int64Counter
+}
+
+// NewMetrics creates the counters with a meter of provider. It returns an
+// error if an instrument cannot be created.
+func NewMetrics(provider metric.MeterProvider) (*Metrics, error) {
+	meter := provider.Meter(ScopeName)
+	var m Metrics
+	var err error
+	if m.created, err = meter.Int64Counter(CreatedCounterName,
+		metric.WithDescription("Number of session documents created by saves."),
+		metric.WithUnit("{session}")); err != nil {
+		return nil, err
+	}
+	if m.loaded, err = meter.Int64Counter(LoadedCounterName,
+		metric.WithDescription("Number of sessions loaded from their cookie."),
+		metric.WithUnit("{session}")); err != nil {
+		return nil, err
+	}
+	if m.destroyed, err = meter.Int64Counter(DestroyedCounterName,
+		metric.WithDescription("Number of sessions erased."),
+		metric.WithUnit("{session}")); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// Hooks returns store hooks updating m, to be set with mongostore.WithHooks.
+// Other hooks can be set on the returned value.
+func (m *Metrics) Hooks() mongostore.Hooks {
+	return mongostore.Hooks{
+		OnSessionEvent: func(event string) {
+			switch event {
+			case mongostore.EventCreated:
+				m.created.Add(context.Background(), 1)
+			case mongostore.EventLoaded:
+				m.loaded.Add(context.Background(), 1)
+			case mongostore.EventDestroyed:
+				m.destroyed.Add(context.Background(), 1)
+			}
+		},
+	}
+}
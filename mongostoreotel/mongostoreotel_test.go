@@ -0,0 +1,141 @@
+package mongostoreotel
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/SpecialFlocon/mongostore"
+	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+// newMetrics returns metrics recorded by a meter provider whose reader is
+// returned along.
+func newMetrics(t *testing.T) (*Metrics, *sdkmetric.ManualReader) {
+	t.Helper()
+	reader := sdkmetric.NewManualReader()
+	m, err := NewMetrics(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m, reader
+}
+
+// counters returns the values of the counters collected by reader, by name.
+func counters(t *testing.T, reader *sdkmetric.ManualReader) map[string]int64 {
+	t.Helper()
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	values := make(map[string]int64)
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			sum, ok := m.Data.(metricdata.Sum[int64])
+			if !ok {
+				continue
+			}
+			for _, dp := range sum.DataPoints {
+				values[m.Name] += dp.Value
+			}
+		}
+	}
+	return values
+}
+
+func TestHooks(t *testing.T) {
+	tests := []struct {
+		name   string
+		events []string
+		want   map[string]int64
+	}{
+		{"no event", nil, map[string]int64{}},
+		{"created", []string{mongostore.EventCreated}, map[string]int64{CreatedCounterName: 1}},
+		{"loaded twice", []string{mongostore.EventLoaded, mongostore.EventLoaded}, map[string]int64{LoadedCounterName: 2}},
+		{"lifecycle", []string{mongostore.EventCreated, mongostore.EventLoaded, mongostore.EventDestroyed}, map[string]int64{
+			CreatedCounterName:   1,
+			LoadedCounterName:    1,
+			DestroyedCounterName: 1,
+		}},
+		{"unknown event", []string{"unknown"}, map[string]int64{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, reader := newMetrics(t)
+			hooks := m.Hooks()
+			for _, event := range tt.events {
+				hooks.OnSessionEvent(event)
+			}
+			got := counters(t, reader)
+			for name, want := range tt.want {
+				if got[name] != want {
+					t.Errorf("%s = %d, want %d", name, got[name], want)
+				}
+			}
+			for name, value := range got {
+				if _, ok := tt.want[name]; !ok && value != 0 {
+					t.Errorf("%s = %d, want 0", name, value)
+				}
+			}
+		})
+	}
+}
+
+// TestStore runs against the MongoDB deployment whose URI is set in
+// MONGOSTORE_TEST_URI, and is skipped without it.
+func TestStore(t *testing.T) {
+	uri := os.Getenv("MONGOSTORE_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGOSTORE_TEST_URI is not set")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect(context.Background())
+	c := client.Database("mongostore_test").Collection("otel_" + primitive.NewObjectID().Hex())
+	defer c.Drop(context.Background())
+
+	m, reader := newMetrics(t)
+	store, err := mongostore.NewMongoStoreWithOptions(c, nil,
+		[][]byte{[]byte("0123456789abcdef0123456789abcdef")},
+		mongostore.WithHooks(m.Hooks()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	session := sessions.NewSession(store, "test")
+	session.Options = &sessions.Options{Path: "/", MaxAge: 3600}
+	w := httptest.NewRecorder()
+	if err := store.Save(r, w, session); err != nil {
+		t.Fatal(err)
+	}
+	for _, cookie := range w.Result().Cookies() {
+		r.AddCookie(cookie)
+	}
+	loaded, err := store.New(r, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded.Options.MaxAge = -1
+	if err := store.Save(r, httptest.NewRecorder(), loaded); err != nil {
+		t.Fatal(err)
+	}
+
+	got := counters(t, reader)
+	for _, name := range []string{CreatedCounterName, LoadedCounterName, DestroyedCounterName} {
+		if got[name] != 1 {
+			t.Errorf("%s = %d, want 1", name, got[name])
+		}
+	}
+}
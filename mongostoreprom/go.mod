@@ -0,0 +1,12 @@
+module github.com/SpecialFlocon/mongostore/mongostoreprom
+
+go 1.15
+
+require (
+	github.com/SpecialFlocon/mongostore v0.0.0
+	github.com/gorilla/securecookie v1.1.1
+	github.com/prometheus/client_golang v1.7.1
+	go.mongodb.org/mongo-driver v1.4.2
+)
+
+replace github.com/SpecialFlocon/mongostore => ../
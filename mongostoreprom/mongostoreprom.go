@@ -0,0 +1,109 @@
+// Package mongostoreprom exports the metrics of a mongostore.MongoStore to
+// Prometheus, by subscribing to the store hooks. It lives in its own module
+// so that the mongostore package does not depend on the Prometheus client.
+//
+//	metrics, err := mongostoreprom.Register(prometheus.DefaultRegisterer)
+//	if err != nil {
+//		return err
+//	}
+//	store, err := mongostore.NewMongoStoreWithOptions(c, nil, keyPairs,
+//		mongostore.WithHooks(metrics.Hooks()))
+package mongostoreprom
+
+import (
+	"time"
+
+	"github.com/SpecialFlocon/mongostore"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Namespace is the namespace of the exported metrics.
+const Namespace = "mongostore"
+
+// Metrics holds the collectors fed by the hooks of a store.
+type Metrics struct {
+	// Durations observes the duration of session operations, in seconds,
+	// by operation (see mongostore.OpLoad, OpSave and OpErase).
+	Durations *prometheus.HistogramVec
+
+	// Errors counts the failed session operations, by operation.
+	Errors *prometheus.CounterVec
+
+	// CacheLookups counts the lookups in the shared cache, by result: "hit"
+	// or "miss". The hit ratio is the rate of hits over the rate of all
+	// lookups.
+	CacheLookups *prometheus.CounterVec
+
+	// Events counts the session lifecycle events, by event (see
+	// mongostore.EventCreated, EventLoaded and EventDestroyed).
+	Events *prometheus.CounterVec
+}
+
+// NewMetrics returns unregistered collectors, e.g. to register them with
+// other labels. Most applications use Register.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		Durations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Name:      "operation_duration_seconds",
+			Help:      "Duration of session loads, saves and erases.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"op"}),
+		Errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "operation_errors_total",
+			Help:      "Number of failed session loads, saves and erases.",
+		}, []string{"op"}),
+		CacheLookups: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "cache_lookups_total",
+			Help:      "Number of lookups in the shared session cache, by result.",
+		}, []string{"result"}),
+		Events: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "session_events_total",
+			Help:      "Number of sessions created, loaded and destroyed.",
+		}, []string{"event"}),
+	}
+}
+
+// Register registers new collectors with reg and returns them. It returns an
+// error if reg already has collectors with the same names, e.g. when
+// registering the metrics of a second store.
+func Register(reg prometheus.Registerer) (*Metrics, error) {
+	m := NewMetrics()
+	for _, c := range m.collectors() {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// collectors returns the collectors of m.
+func (m *Metrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{m.Durations, m.Errors, m.CacheLookups, m.Events}
+}
+
+// Hooks returns store hooks updating m, to be set with mongostore.WithHooks.
+// Other hooks can be set on the returned value.
+func (m *Metrics) Hooks() mongostore.Hooks {
+	return mongostore.Hooks{
+		OnOperation: func(op string, duration time.Duration, err error) {
+			m.Durations.WithLabelValues(op).Observe(duration.Seconds())
+			if err != nil {
+				m.Errors.WithLabelValues(op).Inc()
+			}
+		},
+		OnCacheLookup: func(hit bool) {
+			result := "miss"
+			if hit {
+				result = "hit"
+			}
+			m.CacheLookups.WithLabelValues(result).Inc()
+		},
+		OnSessionEvent: func(event string) {
+			m.Events.WithLabelValues(event).Inc()
+		},
+	}
+}
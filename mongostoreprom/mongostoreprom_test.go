@@ -0,0 +1,74 @@
+package mongostoreprom
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/SpecialFlocon/mongostore"
+	"github.com/gorilla/securecookie"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestRegister(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	if _, err := Register(reg); err != nil {
+		t.Fatalf("Register() = %v", err)
+	}
+	if _, err := Register(reg); err == nil {
+		t.Fatalf("registering twice succeeded")
+	}
+}
+
+func TestHooks(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m, err := Register(reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The client is never connected: loads fail without reaching a server.
+	client, err := mongo.NewClient(options.Client().ApplyURI("mongodb://127.0.0.1:1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := mongostore.NewMongoStoreWithOptions(client.Database("test").Collection("sessions"), nil,
+		[][]byte{[]byte("0123456789abcdef0123456789abcdef")},
+		mongostore.WithHooks(m.Hooks()),
+		mongostore.WithSharedCache(mongostore.NewMemoryCache(), time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookie, err := securecookie.EncodeMulti("test", primitive.NewObjectID().Hex(), store.Codecs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.DecodeSessionCookie(context.Background(), "test", cookie); err == nil {
+		t.Fatalf("loading a session without a server succeeded")
+	}
+
+	tests := []struct {
+		name      string
+		collector prometheus.Collector
+		want      float64
+	}{
+		{"load errors", m.Errors.WithLabelValues(mongostore.OpLoad), 1},
+		{"save errors", m.Errors.WithLabelValues(mongostore.OpSave), 0},
+		{"cache misses", m.CacheLookups.WithLabelValues("miss"), 1},
+		{"cache hits", m.CacheLookups.WithLabelValues("hit"), 0},
+		{"loaded sessions", m.Events.WithLabelValues(mongostore.EventLoaded), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := testutil.ToFloat64(tt.collector); got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if n := testutil.CollectAndCount(m.Durations); n != 1 {
+		t.Fatalf("%d duration series, want 1", n)
+	}
+}
@@ -0,0 +1,122 @@
+package mongostore
+
+import (
+	"errors"
+	"fmt"
+)
+
+// MsgpackCodec encodes and decodes MessagePack, e.g. with the Marshal and
+// Unmarshal functions of github.com/vmihailenco/msgpack. The codec is
+// injected so that this package does not depend on a MessagePack library.
+type MsgpackCodec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// MsgpackSerializer is a securecookie.Serializer encoding session values as
+// MessagePack with Codec, which is more compact and usually faster to decode
+// than gob and JSON. Install it with WithSerializer, along with
+// WithBinaryData to store the encoded data as BSON binary rather than as
+// base64 text.
+//
+// Like with JSONSerializer, values are encoded as a map with string keys:
+// serializing values with a non-string key fails, unless CoerceKeys is set.
+// Integers round-trip as int when they fit, floats as float64, and nested
+// maps as map[string]interface{}. Other types round-trip as Codec decodes
+// them. Unlike JSONSerializer, MsgpackSerializer does not read gob data:
+// sessions stored with another serializer fail to load with
+// ErrSerializationConflict, and cookies encoded with another serializer are
+// rejected, so it is best installed on a new store.
+type MsgpackSerializer struct {
+	// Codec encodes and decodes MessagePack.
+	Codec MsgpackCodec
+	// CoerceKeys converts non-string keys to strings instead of failing.
+	CoerceKeys bool
+}
+
+// errNoMsgpackCodec is returned by a MsgpackSerializer without Codec.
+var errNoMsgpackCodec = errors.New("mongostore: MsgpackSerializer has no codec")
+
+// Serialize encodes src as MessagePack.
+func (m MsgpackSerializer) Serialize(src interface{}) ([]byte, error) {
+	if m.Codec == nil {
+		return nil, errNoMsgpackCodec
+	}
+	if values, ok := src.(map[interface{}]interface{}); ok {
+		keyed, err := stringKeyed(values, m.CoerceKeys)
+		if err != nil {
+			return nil, err
+		}
+		src = keyed
+	}
+	return m.Codec.Marshal(src)
+}
+
+// Deserialize decodes the MessagePack data src into dst.
+func (m MsgpackSerializer) Deserialize(src []byte, dst interface{}) error {
+	if m.Codec == nil {
+		return errNoMsgpackCodec
+	}
+	values, ok := dst.(*map[interface{}]interface{})
+	if !ok {
+		return m.Codec.Unmarshal(src, dst)
+	}
+	var decoded map[string]interface{}
+	if err := m.Codec.Unmarshal(src, &decoded); err != nil {
+		return err
+	}
+	if *values == nil {
+		*values = make(map[interface{}]interface{}, len(decoded))
+	}
+	for k, v := range decoded {
+		(*values)[k] = fromMsgpack(v)
+	}
+	return nil
+}
+
+// fromMsgpack converts a value decoded from MessagePack to the types session
+// values are loaded as, recursively: MessagePack libraries decode integers
+// with the smallest type that holds them.
+func fromMsgpack(v interface{}) interface{} {
+	switch v := v.(type) {
+	case int8:
+		return int(v)
+	case int16:
+		return int(v)
+	case int32:
+		return int(v)
+	case int64:
+		if int64(int(v)) == v {
+			return int(v)
+		}
+	case uint8:
+		return int(v)
+	case uint16:
+		return int(v)
+	case uint32:
+		if uint64(int(v)) == uint64(v) {
+			return int(v)
+		}
+	case uint64:
+		if v <= uint64(int(^uint(0)>>1)) {
+			return int(v)
+		}
+	case float32:
+		return float64(v)
+	case []interface{}:
+		for i, e := range v {
+			v[i] = fromMsgpack(e)
+		}
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = fromMsgpack(e)
+		}
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[fmt.Sprint(k)] = fromMsgpack(e)
+		}
+		return m
+	}
+	return v
+}
@@ -0,0 +1,189 @@
+package mongostore
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// miniMsgpackCodec is a MsgpackCodec implementing the subset of MessagePack
+// the tests and benchmarks encode. Like MessagePack libraries, it decodes
+// integers with the smallest type that holds them.
+type miniMsgpackCodec struct{}
+
+func (miniMsgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	return appendMsgpack(nil, v)
+}
+
+func (miniMsgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	decoded, rest, err := readMsgpack(data)
+	if err != nil {
+		return err
+	}
+	if len(rest) > 0 {
+		return errors.New("msgpack: trailing data")
+	}
+	dst := reflect.ValueOf(v)
+	if dst.Kind() != reflect.Ptr || decoded == nil {
+		return fmt.Errorf("msgpack: cannot decode into %T", v)
+	}
+	src := reflect.ValueOf(decoded)
+	if !src.Type().AssignableTo(dst.Elem().Type()) {
+		return fmt.Errorf("msgpack: cannot decode %T into %T", decoded, v)
+	}
+	dst.Elem().Set(src)
+	return nil
+}
+
+func appendMsgpack(b []byte, v interface{}) ([]byte, error) {
+	var err error
+	switch v := v.(type) {
+	case nil:
+		return append(b, 0xc0), nil
+	case bool:
+		if v {
+			return append(b, 0xc3), nil
+		}
+		return append(b, 0xc2), nil
+	case int:
+		if v >= -32 && v < 128 {
+			return append(b, byte(int8(v))), nil
+		}
+		return appendUint64(append(b, 0xd3), uint64(v)), nil
+	case float64:
+		return appendUint64(append(b, 0xcb), math.Float64bits(v)), nil
+	case string:
+		if len(v) < 32 {
+			return append(append(b, 0xa0|byte(len(v))), v...), nil
+		}
+		return append(appendUint16(append(b, 0xda), uint16(len(v))), v...), nil
+	case []string:
+		b = append(b, 0x90|byte(len(v)))
+		for _, e := range v {
+			b, _ = appendMsgpack(b, e)
+		}
+		return b, nil
+	case []interface{}:
+		b = append(b, 0x90|byte(len(v)))
+		for _, e := range v {
+			if b, err = appendMsgpack(b, e); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case map[string]interface{}:
+		b = append(b, 0x80|byte(len(v)))
+		for k, e := range v {
+			b, _ = appendMsgpack(b, k)
+			if b, err = appendMsgpack(b, e); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("msgpack: cannot encode %T", v)
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	var buf [2]byte
+	binary.BigEndian.PutUint16(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func readMsgpack(b []byte) (interface{}, []byte, error) {
+	if len(b) == 0 {
+		return nil, nil, errors.New("msgpack: unexpected end of data")
+	}
+	c, b := b[0], b[1:]
+	switch {
+	case c < 0x80 || c >= 0xe0:
+		return int8(c), b, nil
+	case c == 0xc0:
+		return nil, b, nil
+	case c == 0xc2 || c == 0xc3:
+		return c == 0xc3, b, nil
+	case c == 0xd3 && len(b) >= 8:
+		return int64(binary.BigEndian.Uint64(b)), b[8:], nil
+	case c == 0xcb && len(b) >= 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), b[8:], nil
+	case c&0xe0 == 0xa0:
+		return readMsgpackString(b, int(c&0x1f))
+	case c == 0xda && len(b) >= 2:
+		return readMsgpackString(b[2:], int(binary.BigEndian.Uint16(b)))
+	case c&0xf0 == 0x90:
+		a := make([]interface{}, c&0x0f)
+		for i := range a {
+			var err error
+			if a[i], b, err = readMsgpack(b); err != nil {
+				return nil, nil, err
+			}
+		}
+		return a, b, nil
+	case c&0xf0 == 0x80:
+		m := make(map[string]interface{}, c&0x0f)
+		for i := 0; i < int(c&0x0f); i++ {
+			k, rest, err := readMsgpack(b)
+			if err != nil {
+				return nil, nil, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, nil, fmt.Errorf("msgpack: map key %v is not a string", k)
+			}
+			if m[key], b, err = readMsgpack(rest); err != nil {
+				return nil, nil, err
+			}
+		}
+		return m, b, nil
+	}
+	return nil, nil, fmt.Errorf("msgpack: unsupported or truncated type 0x%x", c)
+}
+
+func readMsgpackString(b []byte, n int) (interface{}, []byte, error) {
+	if len(b) < n {
+		return nil, nil, errors.New("msgpack: unexpected end of data")
+	}
+	return string(b[:n]), b[n:], nil
+}
+
+func TestMsgpackSerializer(t *testing.T) {
+	tests := []struct {
+		name   string
+		values map[interface{}]interface{}
+		want   map[interface{}]interface{}
+	}{
+		{"empty", map[interface{}]interface{}{}, map[interface{}]interface{}{}},
+		{"small integer", map[interface{}]interface{}{"n": 3}, map[interface{}]interface{}{"n": 3}},
+		{"large integer", map[interface{}]interface{}{"n": 1 << 40}, map[interface{}]interface{}{"n": 1 << 40}},
+		{"negative integer", map[interface{}]interface{}{"n": -1000}, map[interface{}]interface{}{"n": -1000}},
+		{"float", map[interface{}]interface{}{"f": 1.5}, map[interface{}]interface{}{"f": 1.5}},
+		{"string and bool", map[interface{}]interface{}{"user": "alice", "admin": true}, map[interface{}]interface{}{"user": "alice", "admin": true}},
+		{"nested", map[interface{}]interface{}{"roles": []interface{}{"admin", 7}, "prefs": map[string]interface{}{"size": 12}},
+			map[interface{}]interface{}{"roles": []interface{}{"admin", 7}, "prefs": map[string]interface{}{"size": 12}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sz := MsgpackSerializer{Codec: miniMsgpackCodec{}}
+			data, err := sz.Serialize(tt.values)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got map[interface{}]interface{}
+			if err := sz.Deserialize(data, &got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("round trip = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
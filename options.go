@@ -0,0 +1,769 @@
+package mongostore
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// Option configures optional MongoStore behaviour. Options are passed to
+// NewMongoStoreWithOptions.
+type Option func(*MongoStore)
+
+// WithStrictErase makes Save return mongo.ErrNoDocuments when a session is
+// deleted (Options.MaxAge < 0) but its document no longer exists, e.g. when a
+// user logs out twice in a row. By default, erasing an absent session is
+// treated as a success. The session cookie is cleared in both cases.
+func WithStrictErase(strict bool) Option {
+	return func(s *MongoStore) {
+		s.strictErase = strict
+	}
+}
+
+// WithLogger sets the Logger used by the store. A nil Logger disables
+// logging, which is the default.
+func WithLogger(l Logger) Option {
+	return func(s *MongoStore) {
+		if l == nil {
+			l = nopLogger{}
+		}
+		s.logger = l
+	}
+}
+
+// WithValueMigration sets a function called with the decoded values of every
+// loaded session, allowing old value shapes to be upcast in place. It reports
+// whether it changed the values; since Save always rewrites the session data,
+// migrated values are persisted the next time the session is saved.
+func WithValueMigration(migrate func(values map[interface{}]interface{}) (changed bool)) Option {
+	return func(s *MongoStore) {
+		s.migrateValues = migrate
+	}
+}
+
+// WithIPCapture records the client IP address returned by resolve in the
+// documents of saved sessions, in both a readable (ipAddress) and a binary
+// (ip) form, to allow revoking sessions by address range. RemoteAddrIP can be
+// used when the application is not behind a proxy.
+func WithIPCapture(resolve func(r *http.Request) string) Option {
+	return func(s *MongoStore) {
+		s.resolveIP = resolve
+	}
+}
+
+// WithInsecureNoKeys allows a store to operate without any key pair: cookies
+// and session data are then merely serialized, and neither signed nor
+// encrypted. It must only be used in tests.
+func WithInsecureNoKeys() Option {
+	return func(s *MongoStore) {
+		if len(s.Codecs) == 0 {
+			s.Codecs = []securecookie.Codec{insecureCodec{}}
+		}
+	}
+}
+
+// WithExpiryJitter randomizes the stored expiry date (expiresAt) of every
+// saved session within ±jitter, so that sessions created in a burst do not
+// all expire at the same time. See WithExpiryFloor to bound the shortest
+// lifetime.
+func WithExpiryJitter(jitter time.Duration) Option {
+	return func(s *MongoStore) {
+		s.expiryJitter = jitter
+	}
+}
+
+// WithExpiryFloor sets the minimum lifetime of a session once the expiry
+// jitter has been applied.
+func WithExpiryFloor(floor time.Duration) Option {
+	return func(s *MongoStore) {
+		s.expiryFloor = floor
+	}
+}
+
+// WithRegenerateOnChange protects against session fixation by giving a loaded
+// session a new ID when the value stored under key changes before it is
+// saved, e.g. when an "authenticated" flag flips to true on login.
+//
+// The session is written under its new ID and the document stored under the
+// old ID is deleted in a single transaction, so that concurrent requests see
+// either the old or the new document, never both nor neither. If the
+// rotation fails, Save returns an error and leaves the session under its old
+// ID. Transactions require a replica set or a sharded cluster: on a
+// standalone server, saves regenerating an ID fail.
+func WithRegenerateOnChange(key interface{}) Option {
+	return func(s *MongoStore) {
+		s.regenerateKey = key
+	}
+}
+
+// WithAnalyticsReadPreference sets the read preference of read-only analytics
+// queries such as SessionsPerDay, e.g. to run them on secondaries.
+func WithAnalyticsReadPreference(rp *readpref.ReadPref) Option {
+	return func(s *MongoStore) {
+		s.analyticsReadPref = rp
+	}
+}
+
+// WithDocumentDecorator sets a function called when a session is saved, after
+// the store set its own fields, to add application-defined fields to the
+// session document (e.g. a region tag) that the application can query. The
+// request is nil when the session is not saved through Save.
+//
+// Fields managed by the store are reserved: the decorator cannot overwrite
+// them, and attempts to do so are ignored.
+func WithDocumentDecorator(decorate func(r *http.Request, session *sessions.Session, doc bson.M)) Option {
+	return func(s *MongoStore) {
+		s.decorate = decorate
+	}
+}
+
+// WithCookieOptionsResolver sets a function returning the cookie attributes
+// (Path, Domain, Secure, HttpOnly, SameSite) to use when Save issues a cookie
+// in response to r, e.g. SameSite=None for pages embedded in third-party
+// iframes. The shared store options are never mutated. When the resolver
+// returns nil, the session options are used. The cookie Max-Age always
+// follows the session options.
+func WithCookieOptionsResolver(resolve func(r *http.Request) *sessions.Options) Option {
+	return func(s *MongoStore) {
+		s.resolveCookieOptions = resolve
+	}
+}
+
+// WithSerializer sets the serializer used by the store codecs to encode
+// session IDs and values, e.g. JSONSerializer. It only applies to codecs that
+// are *securecookie.SecureCookie instances.
+func WithSerializer(sz securecookie.Serializer) Option {
+	return func(s *MongoStore) {
+		s.valueSerializer = sz
+		s.installSerializer()
+	}
+}
+
+// WithMaxSessionsPerUser limits the number of sessions a user can have. When
+// a session holding a user ID (see DefaultUserIDKey) is saved, the least
+// recently modified sessions of that user beyond the limit are deleted. The
+// enforcement is best-effort: concurrent logins may briefly exceed the limit.
+func WithMaxSessionsPerUser(n int) Option {
+	return func(s *MongoStore) {
+		s.maxSessionsPerUser = n
+	}
+}
+
+// WithIDIndexHint makes the queries loading and saving sessions explicitly
+// hint the _id index. MongoDB already uses it for such queries; the hint
+// only makes the intent explicit and fails loudly if the index is somehow
+// unusable. Hinting updates requires MongoDB 4.2 or later.
+func WithIDIndexHint(hint bool) Option {
+	return func(s *MongoStore) {
+		s.idIndexHint = hint
+	}
+}
+
+// WithUserIDIndexHint makes the administrative queries on user IDs, such as
+// ListSessionsForUser, hint the userID index (UserIDIndexName), so that they
+// never fall back to a collection scan. The index must exist.
+func WithUserIDIndexHint(hint bool) Option {
+	return func(s *MongoStore) {
+		s.userIDIndexHint = hint
+	}
+}
+
+// WithCompression enables the gzip compression of session values whose
+// serialized form reaches the compression threshold (see
+// WithCompressionThreshold). Compressed documents are flagged with a
+// compressed field, so that documents stored with or without compression can
+// always be loaded.
+func WithCompression(enabled bool) Option {
+	return func(s *MongoStore) {
+		s.compression = enabled
+	}
+}
+
+// WithCompressionThreshold sets the size, in bytes, of serialized session
+// values below which compression is skipped, since compressing small
+// payloads wastes CPU and may even inflate them. It defaults to
+// DefaultCompressionThreshold.
+func WithCompressionThreshold(bytes int) Option {
+	return func(s *MongoStore) {
+		s.compressionThreshold = bytes
+	}
+}
+
+// WithHooks sets the hooks called by the store.
+func WithHooks(h Hooks) Option {
+	return func(s *MongoStore) {
+		s.hooks = h
+	}
+}
+
+// WithIndexTimeout bounds the duration of index creation by EnsureIndexes,
+// independently of the timeouts of other operations.
+func WithIndexTimeout(d time.Duration) Option {
+	return func(s *MongoStore) {
+		s.indexTimeout = d
+	}
+}
+
+// WithCodecMaxAge sets the maximum age, in seconds, of the timestamps signed
+// by the securecookie codecs, independently of the cookie Max-Age set by
+// Options.MaxAge. A longer codec maximum age keeps the signed session ID
+// valid while the server-side expiry (expiresAt) governs when the session
+// actually ends. Calling MaxAge afterwards does not override it.
+func WithCodecMaxAge(seconds int) Option {
+	return func(s *MongoStore) {
+		s.codecMaxAge = seconds
+		s.applyCodecMaxAge()
+	}
+}
+
+// WithCodecTimeFunc sets the function returning the current Unix time, in
+// seconds, against which the timestamps signed by the securecookie codecs are
+// checked, e.g. so that tests can advance time past the codec maximum age
+// (see WithCodecMaxAge) without sleeping. The codecs themselves then skip
+// the check, and the store makes it after they authenticate a value: cookies
+// and session data past the maximum age are rejected as expired (see
+// IsExpired). Values are still signed with the system time.
+//
+// It only affects the validity of cookies and session data, not the expiry
+// date of session documents nor their TTL index, which follow the MongoDB
+// server clock.
+func WithCodecTimeFunc(now func() int64) Option {
+	return func(s *MongoStore) {
+		s.codecNow = now
+		s.applyCodecMaxAge()
+	}
+}
+
+// WithSharedCache sets a cache consulted before MongoDB when loading
+// sessions. Loaded documents are cached for ttl.
+//
+// Writes are write-invalidate: the document is first written to (or deleted
+// from) MongoDB, then removed from the cache, so that the next load reads it
+// from MongoDB. A load racing with a write may still cache the previous
+// version of a document until ttl elapses. Bulk deletions, such as
+// DeleteSessionsByIPPrefix, do not invalidate cached entries either, so ttl
+// should be kept short.
+func WithSharedCache(cache CacheStore, ttl time.Duration) Option {
+	return func(s *MongoStore) {
+		s.cache = cache
+		s.cacheTTL = ttl
+	}
+}
+
+// WithClockSkewAllowance pads the expiry checks made with the application
+// clock (on load and by GarbageCollect) by d. Sessions are kept up to d
+// longer rather than being expired early when application servers and the
+// database disagree on the time.
+func WithClockSkewAllowance(d time.Duration) Option {
+	return func(s *MongoStore) {
+		s.clockSkew = d
+	}
+}
+
+// WithChunking splits the data of sessions larger than chunkSize bytes
+// across several chunk documents, stored in the session collection and
+// referenced by the session document, which is useful for sessions
+// exceeding per-field limits. Chunks are reassembled transparently when the
+// session is loaded, and deleted along with it. Saves write a new
+// generation of chunks before updating the session document to reference
+// it, and only then delete the previous generation, so that the session can
+// be loaded throughout; EnsureIndexes creates the index chunks are looked up
+// with.
+func WithChunking(chunkSize int) Option {
+	return func(s *MongoStore) {
+		s.chunkSize = chunkSize
+	}
+}
+
+// WithAbsoluteTimeout makes sessions expire d after their creation, however
+// active they are. The Max-Age of the cookies issued by Save is capped to the
+// remaining lifetime of the session, so that browsers drop the cookie when
+// the server starts rejecting the session.
+func WithAbsoluteTimeout(d time.Duration) Option {
+	return func(s *MongoStore) {
+		s.absoluteTimeout = d
+	}
+}
+
+// WithPrimaryKeyField stores session IDs in the given document field instead
+// of _id, for collections whose _id follows another convention. Sessions are
+// then looked up and upserted on that field, while MongoDB generates the _id
+// of new documents. Such lookups need their own index: EnsureIndexes creates
+// a unique index on the field, which must exist before the store is used
+// under load.
+func WithPrimaryKeyField(name string) Option {
+	return func(s *MongoStore) {
+		if name == "_id" {
+			name = ""
+		}
+		s.primaryKeyField = name
+	}
+}
+
+// WithDuplicateCleanup makes loading a session delete the stale documents
+// left under the same ID by older versions of this package, keeping only the
+// most recently modified one. It costs an extra delete per load, and is
+// meant to heal affected collections during normal traffic; RepairIDs does
+// the same in one pass.
+func WithDuplicateCleanup(enabled bool) Option {
+	return func(s *MongoStore) {
+		s.cleanDuplicates = enabled
+	}
+}
+
+// WithStringKeysOnly sets how JSONSerializer handles session values with a
+// non-string key: if only is true, the default, serializing them fails;
+// otherwise their keys are converted to strings with fmt.Sprint, and are
+// decoded as strings. It overrides the CoerceKeys field of the serializer
+// set with WithSerializer, whichever option comes first; without it, that
+// field applies. ExportValues follows the same policy, unless it is set
+// separately with WithExportKeyCoercion.
+func WithStringKeysOnly(only bool) Option {
+	return func(s *MongoStore) {
+		s.coerceKeys = !only
+		s.keyPolicySet = true
+		s.installSerializer()
+	}
+}
+
+// WithExportKeyCoercion sets whether ExportValues and ExportSafeValues convert
+// non-string keys to strings with fmt.Sprint, rather than skipping their
+// values, independently of how values are serialized. Without it, they
+// convert keys only with WithStringKeysOnly(false).
+func WithExportKeyCoercion(enabled bool) Option {
+	return func(s *MongoStore) {
+		s.exportCoerceKeys = enabled
+		s.exportKeyPolicySet = true
+	}
+}
+
+// WithIDEncoding sets the encoding of session IDs in session cookies. Changing
+// it invalidates the cookies of existing sessions.
+func WithIDEncoding(enc IDEncoding) Option {
+	return func(s *MongoStore) {
+		s.idEncoding = enc
+	}
+}
+
+// WithCorrelationIDExtractor sets a function returning the correlation ID of
+// the request a context belongs to, e.g. a trace ID. Non-empty correlation IDs
+// are appended to the messages logged on behalf of the request, and set as
+// the comment of the query loading its session.
+func WithCorrelationIDExtractor(extract func(ctx context.Context) string) Option {
+	return func(s *MongoStore) {
+		s.correlationID = extract
+	}
+}
+
+// WithAlwaysSetCookie sets whether Save sets the session cookie even when the
+// request already carries it. Enable it to extend the cookie expiry on every
+// save, e.g. for sliding expiration, or when the codecs maximum age would
+// otherwise invalidate long-lived cookies.
+func WithAlwaysSetCookie(always bool) Option {
+	return func(s *MongoStore) {
+		s.alwaysSetCookie = always
+	}
+}
+
+// WithUserIDKey sets the session.Values key holding the ID of the user a
+// session belongs to, DefaultUserIDKey by default, e.g. "sub" for sessions
+// populated from OpenID Connect claims.
+func WithUserIDKey(key interface{}) Option {
+	return func(s *MongoStore) {
+		s.userIDKey = key
+	}
+}
+
+// WithMaxSessionSize sets the maximum size, in bytes, of serialized (and
+// possibly compressed) session values, above which Save returns an error
+// wrapping ErrSessionTooLarge instead of the generic error of the codecs.
+// Encoding adds about a third to the serialized size: to match the
+// MaxLength of securecookie codecs, 4096 bytes by default, set it about a
+// quarter lower. A size of 0, the default, disables the check.
+func WithMaxSessionSize(bytes int) Option {
+	return func(s *MongoStore) {
+		s.maxSessionSize = bytes
+	}
+}
+
+// WithCausalConsistency sets whether CausalContext starts causally consistent
+// MongoDB sessions, so that the store reads its own writes within a request.
+func WithCausalConsistency(enabled bool) Option {
+	return func(s *MongoStore) {
+		s.causalConsistency = enabled
+	}
+}
+
+// WithUniquePerUserAndName sets whether a user has at most one session with a
+// given name, e.g. a single "web" session: saving a session of a user deletes
+// their other sessions with the same name, so that a new login replaces the
+// previous one. EnsureIndexes then creates a unique index enforcing it, which
+// fails if the collection already holds such duplicates.
+func WithUniquePerUserAndName(unique bool) Option {
+	return func(s *MongoStore) {
+		s.uniquePerUserAndName = unique
+	}
+}
+
+// WithBinaryData stores session data as BSON binary rather than as text,
+// which saves the overhead of its base64 encoding, and compresses session
+// values reaching the compression threshold (see WithCompressionThreshold)
+// with c, which supersedes WithCompression. The compression is recorded in
+// the binary subtype, so that documents stored as text, or with another
+// compression, can always be loaded. Binary data cannot be chunked (see
+// WithChunking).
+func WithBinaryData(c Compression) Option {
+	return func(s *MongoStore) {
+		s.binaryData = true
+		s.binaryCompression = c
+	}
+}
+
+// WithBSONValues stores session values as a values subdocument, rather than
+// as data encoded by the codecs, so that they can be read and queried in the
+// database, e.g. with GetValue. Such values are neither signed nor encrypted:
+// only enable it if the database is trusted. Keys must be strings (see
+// WithStringKeysOnly), and values are loaded as decoded from BSON, except
+// that integers are loaded as int when they fit, dates as time.Time, arrays
+// as []interface{} and documents as map[string]interface{}. Sessions stored
+// in either form can always be loaded.
+func WithBSONValues(enabled bool) Option {
+	return func(s *MongoStore) {
+		s.bsonValues = enabled
+	}
+}
+
+// WithEncryptedKeys sets session.Values keys whose values are encoded by the
+// codecs in the values subdocument stored with WithBSONValues, so that
+// sensitive values, e.g. tokens, are protected while the others remain
+// readable and queryable. Values are encrypted if the codecs have block keys,
+// and only signed otherwise. Keys are stored as strings, so they must be
+// strings to be decrypted when loaded.
+func WithEncryptedKeys(keys ...interface{}) Option {
+	return func(s *MongoStore) {
+		if s.encryptedKeys == nil {
+			s.encryptedKeys = make(map[interface{}]bool, len(keys))
+		}
+		for _, key := range keys {
+			s.encryptedKeys[key] = true
+		}
+	}
+}
+
+// WithMissingModifiedAtAllowed sets whether session documents without a
+// modification date, e.g. written by other tools, are loaded. By default,
+// loading them fails with ErrInvalidModificationDate.
+func WithMissingModifiedAtAllowed(allowed bool) Option {
+	return func(s *MongoStore) {
+		s.allowMissingModifiedAt = allowed
+	}
+}
+
+// WithWriteBuffer buffers session saves, and writes them in batches with a
+// single bulk write once maxOps saves (or 100 if maxOps is not positive) are
+// buffered, or maxDelay after the first buffered save if maxDelay is
+// positive, or on Flush and Close. Successive saves of a session are
+// coalesced. It trades durability for throughput: buffered saves are lost
+// if the process crashes, and failed flushes are logged but not retried.
+//
+// Loading a session with a buffered save flushes the buffer first, but
+// other reads, e.g. ListSessionsForUser, do not see buffered saves.
+// SaveIfVersion, and saves of chunked sessions, of regenerated sessions or
+// involving other sessions of the user (see WithMaxSessionsPerUser and
+// WithUniquePerUserAndName) are not buffered. SaveResult.Created reports
+// whether a buffered session was new.
+func WithWriteBuffer(maxOps int, maxDelay time.Duration) Option {
+	return func(s *MongoStore) {
+		if maxOps <= 0 {
+			maxOps = defaultBufferSize
+		}
+		s.writeBuffer = &writeBuffer{maxOps: maxOps, maxDelay: maxDelay}
+	}
+}
+
+// WithArchiveCollection copies sessions to the collection c before they are
+// deleted by erase, i.e. when saved with a negative MaxAge, or by
+// GarbageCollect, e.g. for later analysis. Archived documents get an
+// archivedAt date and an archiveReason field, "erased" or "expired". Chunks
+// (see WithChunking) are not archived. Archiving is best-effort: failures
+// are logged and reported to the OnArchiveError hook, and do not prevent
+// the deletion.
+func WithArchiveCollection(c *mongo.Collection) Option {
+	return func(s *MongoStore) {
+		s.archiveCollection = c
+	}
+}
+
+// WithPerNameMaxAge sets the MaxAge, in seconds, of the sessions with the
+// given names, e.g. to expire short-lived csrf sessions well before auth
+// sessions. It is the default MaxAge of their Options, which sets both their
+// cookie Max-Age and their expiry date (expiresAt), and thus when the TTL
+// index deletes them. Sessions with other names keep the store MaxAge. A
+// MaxAge longer than the store one requires a codec maximum age at least as
+// long (see WithCodecMaxAge), or the cookies would be rejected before the
+// sessions expire.
+func WithPerNameMaxAge(ages map[string]int) Option {
+	return func(s *MongoStore) {
+		s.perNameMaxAge = make(map[string]int, len(ages))
+		for name, maxAge := range ages {
+			s.perNameMaxAge[name] = maxAge
+		}
+	}
+}
+
+// WithRedactedKeys sets session.Values keys whose values are sensitive, e.g.
+// tokens, and must be masked in output meant for humans: they are replaced
+// by RedactedValue in ExportSafeValues. The store never logs session values,
+// and storage is unaffected.
+func WithRedactedKeys(keys ...interface{}) Option {
+	return func(s *MongoStore) {
+		if s.redactedKeys == nil {
+			s.redactedKeys = make(map[interface{}]bool, len(keys))
+		}
+		for _, key := range keys {
+			s.redactedKeys[key] = true
+		}
+	}
+}
+
+// WithLoadFilter sets a function returning a filter that session documents
+// must also match to be loaded, saved or erased, e.g. {"tenant": id} with
+// the tenant of ctx in a multi-tenant collection, so that a valid session ID
+// from one tenant never resolves to a session with another. Fields compared
+// for equality are set on the documents created by saves, and saving a
+// session with the ID of another tenant's session fails with a duplicate key
+// error instead of overwriting it. Lease, GetValue and RawData are scoped
+// too. The shared cache (see WithSharedCache) is not read when loading
+// sessions, and the administrative methods, e.g. DeleteByIDs, are not scoped.
+func WithLoadFilter(filter func(ctx context.Context) bson.M) Option {
+	return func(s *MongoStore) {
+		s.loadFilter = filter
+	}
+}
+
+// WithRecoveryCodecs adds codecs created from keyPairs, like the store ones,
+// that only decode cookies and session data, after the store codecs failed:
+// they never encode anything. It is a break-glass measure to read existing
+// sessions with a backup of lost keys while new cookies and data are
+// encoded with the new keys. Remove the recovery codecs as soon as possible:
+// whoever holds their keys, e.g. whoever leaked them, can forge sessions the
+// store accepts, and sessions keep being decoded with them until saved. The
+// recovery codecs get the maximum age and serializer of the store codecs.
+func WithRecoveryCodecs(keyPairs ...[]byte) Option {
+	return func(s *MongoStore) {
+		s.recoveryCodecs = securecookie.CodecsFromPairs(keyPairs...)
+		s.applyCodecMaxAge()
+		s.installSerializer()
+	}
+}
+
+// WithOnEncodeError sets a function called with the session name and the
+// error when the values of a session cannot be encoded on save, e.g. because
+// the serializer does not support the type of a value, or because the session
+// is too large (see WithMaxSessionSize), before Save returns the error. It
+// lets applications log such failures and degrade gracefully, e.g. by
+// dropping the offending value on their next save. The error identifies the
+// session, and wraps the encoding error.
+func WithOnEncodeError(hook func(name string, err error)) Option {
+	return func(s *MongoStore) {
+		s.onEncodeError = hook
+	}
+}
+
+// WithNoResurrection sets whether saving a loaded session whose document was
+// deleted since it was loaded, e.g. by a logout in a concurrent request,
+// fails with ErrSessionDeleted rather than creating the document again,
+// which would resurrect the logged out session. Only new sessions, and
+// sessions given a new ID (see WithRegenerateOnChange), create documents.
+// Saves of loaded sessions are then not buffered (see WithWriteBuffer).
+func WithNoResurrection(enabled bool) Option {
+	return func(s *MongoStore) {
+		s.noResurrection = enabled
+	}
+}
+
+// WithCreationMetadata sets a function returning fields to add to session
+// documents when saves create them, e.g. the origin service or the signup
+// campaign: they are set with $setOnInsert, and never updated afterwards.
+// Fields managed by the store and fields set by the document decorator (see
+// WithDocumentDecorator) cannot be set, and are skipped with a warning.
+// Sessions saved without a request, e.g. by SaveIfVersion, get no metadata.
+func WithCreationMetadata(metadata func(r *http.Request) bson.M) Option {
+	return func(s *MongoStore) {
+		s.creationMetadata = metadata
+	}
+}
+
+// WithAdminOperationTimeout bounds the long-running maintenance methods,
+// GarbageCollect, MigrateSerialization, Backfill, RepairIDs and UpdateTTL,
+// to d when they are called with a context without deadline, e.g.
+// context.Background(), so that they cannot run forever. A deadline of the
+// context is always respected, even if it is later than d.
+func WithAdminOperationTimeout(d time.Duration) Option {
+	return func(s *MongoStore) {
+		s.adminTimeout = d
+	}
+}
+
+// WithIDGenerator sets the function generating the document IDs, and thus
+// the session IDs, of new sessions, e.g. RandomObjectID. By default, IDs are
+// generated by primitive.NewObjectID: they are 96 bits long, but mostly
+// predictable, and it is the cookie signature that keeps clients from
+// forging them. Saving a new session fails if the generator fails, and with
+// ErrWeakSessionID if it returns NilObjectID.
+func WithIDGenerator(gen func() (primitive.ObjectID, error)) Option {
+	return func(s *MongoStore) {
+		s.idGenerator = gen
+	}
+}
+
+// WithMinIDEntropy sets the minimum number of bits of entropy that session
+// IDs must be able to carry, e.g. for compliance. Session IDs are
+// ObjectIDs of 96 bits: Validate, and thus NewMongoStoreWithOptions, return
+// ErrWeakSessionID if more are required. Use WithIDGenerator(RandomObjectID)
+// for IDs whose 96 bits are all random. The IDs generated for stores
+// configured with WithStringIDs have 128 random bits.
+func WithMinIDEntropy(bits int) Option {
+	return func(s *MongoStore) {
+		s.minIDEntropy = bits
+	}
+}
+
+// WithExpiryGrace sets a grace period during which sessions past their
+// expiry date are still loaded, marked as expiring (see IsExpiring), e.g. to
+// trigger a silent refresh rather than logging the user out. The absolute
+// timeout (see WithAbsoluteTimeout) has no grace period. The TTL index
+// created by EnsureIndexes may delete expired sessions before the end of the
+// grace period, which should therefore be kept short.
+func WithExpiryGrace(d time.Duration) Option {
+	return func(s *MongoStore) {
+		s.expiryGrace = d
+	}
+}
+
+// WithTimestampAsUnixMillis stores the dates of session documents, i.e.
+// createdAt, modifiedAt, dataModifiedAt and expiresAt, as int64 Unix
+// milliseconds instead of BSON dates, e.g. for other services reading the
+// collection in languages with awkward BSON date support. Documents stored
+// with either form can be loaded, but queries, e.g. GarbageCollect, only
+// match documents stored with the configured form: use it from the start,
+// or rewrite existing documents.
+//
+// MongoDB TTL indexes only apply to BSON dates: expired sessions are
+// therefore no longer deleted by the index created by EnsureIndexes, and
+// GarbageCollect must be run instead, e.g. with StartGC. SessionsPerDay
+// requires MongoDB 4.0 in this mode.
+func WithTimestampAsUnixMillis(enabled bool) Option {
+	return func(s *MongoStore) {
+		s.unixMillis = enabled
+	}
+}
+
+// WithConcurrencyLimit limits the number of session loads, saves and erases
+// running concurrently to n, e.g. to protect the cluster during load spikes.
+// Operations beyond the limit wait for a running one to finish, or for their
+// context to be done, in which case they fail with its error. Stats reports
+// the number of operations in progress. A non-positive n means no limit.
+func WithConcurrencyLimit(n int) Option {
+	return func(s *MongoStore) {
+		s.slots = nil
+		if n > 0 {
+			s.slots = make(chan struct{}, n)
+		}
+	}
+}
+
+// WithPartitionedCookie sets whether session cookies are issued with the
+// Partitioned attribute (CHIPS), so that browsers keep them when the
+// application is embedded in third-party sites, in storage partitioned by
+// top-level site. Partitioned cookies must be Secure with SameSite=None:
+// Validate checks the store options.
+func WithPartitionedCookie(partitioned bool) Option {
+	return func(s *MongoStore) {
+		s.partitionedCookie = partitioned
+	}
+}
+
+// WithMinCreatedAt invalidates the sessions created before t, e.g. to log
+// every user out after a security incident: they load as new sessions, saved
+// under a new ID as if they did not exist, and their documents are left for
+// the TTL index or GarbageCollect to delete. Documents without a creation
+// date, written by older versions of this package, are invalidated too unless
+// Backfill set it. A zero t invalidates no session.
+func WithMinCreatedAt(t time.Time) Option {
+	return func(s *MongoStore) {
+		s.minCreatedAt = t
+	}
+}
+
+// WithLoadValidator sets a function checking the invariants of the sessions
+// loaded from the collection, e.g. that a required value is present. Sessions
+// it returns an error for are treated as corrupted: Get and New return a new
+// session with a new ID, without error, and Lease and FindByRefreshToken
+// return ErrSessionNotFound. Their documents are kept, unless the store was
+// configured with WithDeleteInvalidSessions.
+func WithLoadValidator(validate func(session *sessions.Session) error) Option {
+	return func(s *MongoStore) {
+		s.loadValidator = validate
+	}
+}
+
+// WithDeleteInvalidSessions sets whether the sessions rejected by the
+// validator set with WithLoadValidator are deleted when Get or New load
+// them, rather than left for the TTL index or GarbageCollect to delete.
+func WithDeleteInvalidSessions(enabled bool) Option {
+	return func(s *MongoStore) {
+		s.deleteInvalid = enabled
+	}
+}
+
+// WithRequestReadConcern sets the read concern of the MongoDB sessions
+// started by WithRequestSession. It defaults to majority, without which
+// reads are not guaranteed to be monotonic.
+func WithRequestReadConcern(rc *readconcern.ReadConcern) Option {
+	return func(s *MongoStore) {
+		s.requestConcern = rc
+	}
+}
+
+// WithIndexCollation sets the collation of the indexes on userID created by
+// EnsureIndexes, including the unique index on userID and name of
+// WithUniquePerUserAndName, and of the per-user queries:
+// ListSessionsForUser, DeleteSessionsForUser, SessionCountsByUser, and the
+// queries of WithMaxSessionsPerUser and WithUniquePerUserAndName. For
+// instance, with a collation of strength 2, user IDs that are emails match
+// regardless of case, and sessions are unique per user and name regardless
+// of case. DistinctNames compares names exactly.
+//
+// Queries only use an index with the same collation: the collation must not
+// change once the indexes are created, or they must be dropped and created
+// again by EnsureIndexes, which never modifies existing indexes. Queries
+// hinted with WithUserIDIndexHint fail if the collations differ.
+func WithIndexCollation(collation *options.Collation) Option {
+	return func(s *MongoStore) {
+		s.indexCollation = collation
+	}
+}
+
+// WithStringIDs keys session documents by their session ID, an arbitrary
+// non-empty string, rather than by an ObjectID, e.g. to keep the sessions of
+// a system whose session IDs are 32-character hexadecimal tokens: such
+// documents load, and sessions whose ID is set before their first save are
+// stored under it. New sessions get 32 random hexadecimal digits, i.e. 128
+// bits, and WithIDEncoding does not apply. Documents keyed by an ObjectID
+// still load, their session ID being its hexadecimal string. It cannot be
+// combined with WithIDGenerator, and RepairIDs does not apply.
+func WithStringIDs(enabled bool) Option {
+	return func(s *MongoStore) {
+		s.stringIDs = enabled
+	}
+}
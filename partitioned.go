@@ -0,0 +1,12 @@
+//go:build go1.23
+// +build go1.23
+
+package mongostore
+
+import "net/http"
+
+// setPartitionedCookie sets the cookie c with the Partitioned attribute.
+func setPartitionedCookie(w http.ResponseWriter, c *http.Cookie) {
+	c.Partitioned = true
+	http.SetCookie(w, c)
+}
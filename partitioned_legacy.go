@@ -0,0 +1,14 @@
+//go:build !go1.23
+// +build !go1.23
+
+package mongostore
+
+import "net/http"
+
+// setPartitionedCookie sets the cookie c with the Partitioned attribute,
+// which http.Cookie only supports as of Go 1.23.
+func setPartitionedCookie(w http.ResponseWriter, c *http.Cookie) {
+	if v := c.String(); v != "" {
+		w.Header().Add("Set-Cookie", v+"; Partitioned")
+	}
+}
@@ -0,0 +1,67 @@
+package mongostore
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/sessions"
+)
+
+func TestPartitionedCookieValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		secure   bool
+		sameSite http.SameSite
+		wantErr  bool
+	}{
+		{"Secure with SameSite=None", true, http.SameSiteNoneMode, false},
+		{"not Secure", false, http.SameSiteNoneMode, true},
+		{"SameSite=Lax", true, http.SameSiteLaxMode, true},
+		{"default SameSite", true, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := &sessions.Options{Path: "/", MaxAge: 3600, Secure: tt.secure, SameSite: tt.sameSite}
+			_, err := NewMongoStoreWithOptions(newUnitStore(t).collection, opts, testKeyPairs, WithPartitionedCookie(true))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewMongoStoreWithOptions() = %v, want error: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPartitionedCookie(t *testing.T) {
+	tests := []struct {
+		name        string
+		partitioned bool
+	}{
+		{"partitioned", true},
+		{"not partitioned", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := &sessions.Options{Path: "/", MaxAge: 3600, Secure: true, SameSite: http.SameSiteNoneMode}
+			s, err := NewMongoStoreWithOptions(newUnitStore(t).collection, opts, testKeyPairs, WithPartitionedCookie(tt.partitioned))
+			if err != nil {
+				t.Fatal(err)
+			}
+			w := httptest.NewRecorder()
+			s.setCookie(w, sessions.NewCookie("test", "value", s.sessionOptions("test")))
+			headers := w.Header().Values("Set-Cookie")
+			if len(headers) != 1 {
+				t.Fatalf("Set-Cookie headers %q, want one", headers)
+			}
+			header := headers[0]
+			if got := strings.Contains(header, "; Partitioned"); got != tt.partitioned {
+				t.Fatalf("Set-Cookie: %s, want Partitioned: %v", header, tt.partitioned)
+			}
+			for _, attr := range []string{"test=value", "; Secure", "; SameSite=None"} {
+				if !strings.Contains(header, attr) {
+					t.Fatalf("Set-Cookie: %s, want %q", header, attr)
+				}
+			}
+		})
+	}
+}
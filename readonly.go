@@ -0,0 +1,21 @@
+package mongostore
+
+import "context"
+
+// readOnlyKey is the context key marking read-only requests.
+type readOnlyKey struct{}
+
+// WithReadOnly returns a copy of ctx marking the request it belongs to as
+// read-only: saving a session with a request carrying it neither writes to
+// the database nor sets a cookie, e.g. so that middleware saving sessions
+// unconditionally does not cause writes on read-heavy endpoints. Sessions are
+// not erased either.
+func WithReadOnly(ctx context.Context) context.Context {
+	return context.WithValue(ctx, readOnlyKey{}, true)
+}
+
+// isReadOnly reports whether ctx was marked with WithReadOnly.
+func isReadOnly(ctx context.Context) bool {
+	readOnly, _ := ctx.Value(readOnlyKey{}).(bool)
+	return readOnly
+}
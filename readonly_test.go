@@ -0,0 +1,52 @@
+package mongostore
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestReadOnly(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(session *sessions.Session)
+	}{
+		{"unchanged session", func(*sessions.Session) {}},
+		{"changed values", func(session *sessions.Session) { session.Values["user"] = "bob" }},
+		{"erased session", func(session *sessions.Session) { session.Options.MaxAge = -1 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := newCommandRecorder("update")
+			s, err := NewMongoStoreWithOptions(newTestCollection(t, options.Client().SetMonitor(recorder.monitor())), nil, testKeyPairs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			session := sessions.NewSession(s, "test")
+			session.Options = s.sessionOptions(session.Name())
+			session.Values["user"] = "alice"
+			value := saveSession(t, s, session)
+			loaded := loadSession(t, s, "test", value)
+			tt.change(loaded)
+			recorder.reset()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r = r.WithContext(WithReadOnly(r.Context()))
+			w := httptest.NewRecorder()
+			if err := s.Save(r, w, loaded); err != nil {
+				t.Fatal(err)
+			}
+			if cmds := recorder.commands(); len(cmds) != 0 {
+				t.Fatalf("read-only save sent %d update commands", len(cmds))
+			}
+			if cookie := w.Header().Get("Set-Cookie"); cookie != "" {
+				t.Fatalf("read-only save set cookie %q", cookie)
+			}
+			if again := loadSession(t, s, "test", value); again.IsNew || again.Values["user"] != "alice" {
+				t.Fatalf("stored session (new: %v) with %v, want it unchanged", again.IsNew, again.Values)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package mongostore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+
+	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RefreshTokenIndexName is the name of the index on refreshTokenHash created
+// by EnsureIndexes.
+const RefreshTokenIndexName = "refreshTokenHash_1"
+
+// RefreshTokenHash returns the hash of a refresh token, as stored in the
+// refreshTokenHash field of session documents for FindByRefreshToken.
+// Applications set the field with a document decorator (see
+// WithDocumentDecorator), so that tokens are never stored in clear.
+func RefreshTokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// FindByRefreshToken loads the session whose refreshTokenHash field is the
+// hash of token (see RefreshTokenHash), e.g. to refresh a session from a
+// refresh token rather than from its cookie. The session is not added to the
+// registry, and is matched against the load filter set with WithLoadFilter.
+//
+// It returns ErrSessionNotFound if there is no such session, or if it
+// expired.
+func (s *MongoStore) FindByRefreshToken(ctx context.Context, token string) (*sessions.Session, error) {
+	if s.collection == nil {
+		return nil, ErrNoCollection
+	}
+	if token == "" {
+		return nil, ErrSessionNotFound
+	}
+	filter := s.scopeFilter(ctx, bson.M{"refreshTokenHash": RefreshTokenHash(token)})
+	opts := options.FindOne().SetSort(bson.D{{Key: "modifiedAt", Value: -1}})
+	var doc Session
+	if err := s.decodeResult(s.collection.FindOne(ctx, filter, opts), &doc); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrSessionNotFound
+		}
+		return nil, err
+	}
+	if err := s.assembleChunks(ctx, &doc); err != nil {
+		return nil, err
+	}
+	session := sessions.NewSession(s, doc.Name)
+	session.Options = s.sessionOptions(doc.Name)
+	session.ID = s.sessionID(s.documentID(&doc))
+	if err := s.loadDocument(ctx, session, &doc); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrSessionNotFound
+		}
+		return nil, err
+	}
+	session.IsNew = false
+	return session, nil
+}
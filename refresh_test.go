@@ -0,0 +1,92 @@
+package mongostore
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestFindByRefreshToken(t *testing.T) {
+	const token = "r3fr3sh-t0k3n"
+	tests := []struct {
+		name    string
+		token   string
+		expired bool
+		wantErr error
+	}{
+		{"matching token", token, false, nil},
+		{"non-matching token", "other-token", false, ErrSessionNotFound},
+		{"empty token", "", false, ErrSessionNotFound},
+		{"expired session", token, true, ErrSessionNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := newTestStore(t, WithDocumentDecorator(func(r *http.Request, session *sessions.Session, doc bson.M) {
+				if token, ok := session.Values["refreshToken"].(string); ok {
+					doc["refreshTokenHash"] = RefreshTokenHash(token)
+				}
+			}))
+			if err := s.EnsureIndexes(ctx); err != nil {
+				t.Fatal(err)
+			}
+			other := sessions.NewSession(s, "test")
+			other.Options = s.sessionOptions(other.Name())
+			other.Values["refreshToken"] = "other-user-token-unused"
+			saveSession(t, s, other)
+			session := sessions.NewSession(s, "auth")
+			session.Options = s.sessionOptions(session.Name())
+			session.Values["user"] = "alice"
+			session.Values["refreshToken"] = token
+			saveSession(t, s, session)
+			if tt.expired {
+				docID, err := s.docID(session.ID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				update := bson.M{"$set": bson.M{"expiresAt": s.timestamp(time.Now().Add(-time.Hour))}}
+				if _, err := s.collection.UpdateOne(ctx, s.idFilter(docID), update); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			found, err := s.FindByRefreshToken(ctx, tt.token)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("FindByRefreshToken() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if found.ID != session.ID || found.Name() != "auth" || found.IsNew || found.Values["user"] != "alice" {
+				t.Fatalf("FindByRefreshToken() = session %q named %q (new: %v) with values %v, want session %q", found.ID, found.Name(), found.IsNew, found.Values, session.ID)
+			}
+		})
+	}
+}
+
+func TestRefreshTokenHash(t *testing.T) {
+	tests := []struct {
+		name  string
+		a, b  string
+		equal bool
+	}{
+		{"same token", "token", "token", true},
+		{"different tokens", "token", "token2", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := RefreshTokenHash(tt.a), RefreshTokenHash(tt.b)
+			if (a == b) != tt.equal {
+				t.Fatalf("RefreshTokenHash(%q) = %s, RefreshTokenHash(%q) = %s, want equal: %v", tt.a, a, tt.b, b, tt.equal)
+			}
+			if len(a) != 64 || a == tt.a {
+				t.Fatalf("RefreshTokenHash(%q) = %q, want a SHA-256 hexadecimal digest", tt.a, a)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package mongostore
+
+import (
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RepairIDs rewrites the session documents whose _id is stored as a
+// hexadecimal string instead of an ObjectID, as written by older versions of
+// this package, and returns the number of repaired documents.
+//
+// When a document with the matching ObjectID already exists, the most
+// recently modified of the two is kept. Documents whose string _id is not a
+// valid ObjectID are left untouched. It returns an error for stores
+// configured with WithStringIDs, whose string keys need no repair.
+func (s *MongoStore) RepairIDs(ctx context.Context) (repaired int64, err error) {
+	if s.collection == nil {
+		return 0, ErrNoCollection
+	}
+	if s.stringIDs {
+		return 0, errors.New("mongostore: RepairIDs does not apply to string IDs")
+	}
+	ctx, cancel := s.adminContext(ctx)
+	defer cancel()
+	key := s.keyField()
+	cur, err := s.collection.Find(ctx, bson.M{key: bson.M{"$type": "string"}})
+	if err != nil {
+		return 0, err
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		var doc bson.M
+		if err := cur.Decode(&doc); err != nil {
+			return repaired, err
+		}
+		strID, _ := doc[key].(string)
+		objID, err := primitive.ObjectIDFromHex(strID)
+		if err != nil {
+			s.log(ctx).Warnf("mongostore: cannot repair session %q: %v", strID, err)
+			continue
+		}
+		if err := s.repairID(ctx, strID, objID, doc); err != nil {
+			return repaired, err
+		}
+		repaired++
+	}
+	return repaired, cur.Err()
+}
+
+// repairID moves the document doc stored under strID to objID, unless a more
+// recent document already exists under objID.
+func (s *MongoStore) repairID(ctx context.Context, strID string, objID primitive.ObjectID, doc bson.M) error {
+	var existing Session
+	key := s.keyField()
+	err := s.decodeResult(s.collection.FindOne(ctx, bson.M{key: objID}), &existing)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return err
+	}
+	var modifiedAt primitive.DateTime
+	switch v := doc["modifiedAt"].(type) {
+	case primitive.DateTime:
+		modifiedAt = v
+	case int64:
+		// Stored with WithTimestampAsUnixMillis.
+		modifiedAt = primitive.DateTime(v)
+	}
+	if err != nil || existing.ModifiedAt.Before(modifiedAt.Time()) {
+		delete(doc, "_id")
+		delete(doc, key)
+		opts := options.Update().SetUpsert(true)
+		if _, err := s.collection.UpdateOne(ctx, bson.M{key: objID}, bson.M{"$set": doc}, opts); err != nil {
+			return err
+		}
+	}
+	_, err = s.collection.DeleteOne(ctx, bson.M{key: strID})
+	return err
+}
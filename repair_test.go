@@ -0,0 +1,72 @@
+package mongostore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestRepairIDs(t *testing.T) {
+	ctx := context.Background()
+	old := time.Now().Add(-time.Hour).Truncate(time.Millisecond)
+	recent := time.Now().Truncate(time.Millisecond)
+	tests := []struct {
+		name         string
+		stringID     string
+		stringData   time.Time
+		objectIDData time.Time // zero if there is no ObjectID document
+		wantRepaired int64
+		wantData     string
+	}{
+		{"string ID only", "", recent, time.Time{}, 1, "string"},
+		{"string ID more recent", "", recent, old, 1, "string"},
+		{"ObjectID more recent", "", old, recent, 1, "objectID"},
+		{"invalid string ID", "not an ObjectID", recent, time.Time{}, 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore(t)
+			objID := primitive.NewObjectID()
+			strID := tt.stringID
+			if strID == "" {
+				strID = objID.Hex()
+			}
+			docs := []interface{}{bson.M{"_id": strID, "name": "test", "data": "string", "modifiedAt": tt.stringData}}
+			if !tt.objectIDData.IsZero() {
+				docs = append(docs, bson.M{"_id": objID, "name": "test", "data": "objectID", "modifiedAt": tt.objectIDData})
+			}
+			if _, err := s.collection.InsertMany(ctx, docs); err != nil {
+				t.Fatal(err)
+			}
+			repaired, err := s.RepairIDs(ctx)
+			if err != nil || repaired != tt.wantRepaired {
+				t.Fatalf("RepairIDs() = %d, %v, want %d", repaired, err, tt.wantRepaired)
+			}
+			if tt.wantRepaired == 0 {
+				if n, err := s.collection.CountDocuments(ctx, bson.M{"_id": strID}); err != nil || n != 1 {
+					t.Fatalf("unrepairable document count = %d, %v, want 1", n, err)
+				}
+				return
+			}
+			if n, err := s.collection.CountDocuments(ctx, bson.M{"_id": strID}); err != nil || n != 0 {
+				t.Fatalf("string ID document count = %d, %v, want 0", n, err)
+			}
+			var doc Session
+			if err := s.decodeResult(s.collection.FindOne(ctx, bson.M{"_id": objID}), &doc); err != nil {
+				t.Fatal(err)
+			}
+			if doc.Data != tt.wantData {
+				t.Fatalf("kept the %q document, want %q", doc.Data, tt.wantData)
+			}
+		})
+	}
+}
+
+func TestRepairIDsStringIDs(t *testing.T) {
+	if _, err := newUnitStore(t, WithStringIDs(true)).RepairIDs(context.Background()); err == nil {
+		t.Fatal("RepairIDs() succeeded with string IDs")
+	}
+}
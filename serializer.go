@@ -0,0 +1,202 @@
+package mongostore
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/gorilla/securecookie"
+)
+
+// JSONSerializer is a securecookie.Serializer encoding session values as
+// JSON. It is usually faster and allocates less than the default gob
+// encoding. Install it with WithSerializer.
+//
+// JSON objects only have string keys: by default, serializing values with a
+// non-string key fails. With CoerceKeys, such keys are converted to strings
+// with fmt.Sprint, and are therefore decoded as strings. Integers round-trip
+// as int when they fit, other numbers as float64, and nested objects as
+// map[string]interface{}.
+//
+// Data that is not JSON is decoded as gob, so that cookies and sessions
+// encoded before switching serializers remain readable; MigrateSerialization
+// rewrites stored sessions as JSON.
+type JSONSerializer struct {
+	// CoerceKeys converts non-string keys to strings instead of failing.
+	CoerceKeys bool
+}
+
+var jsonBufferPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// Serialize encodes src as JSON.
+func (j JSONSerializer) Serialize(src interface{}) ([]byte, error) {
+	if values, ok := src.(map[interface{}]interface{}); ok {
+		m, err := stringKeyed(values, j.CoerceKeys)
+		if err != nil {
+			return nil, err
+		}
+		src = m
+	}
+
+	buf := jsonBufferPool.Get().(*bytes.Buffer)
+	defer jsonBufferPool.Put(buf)
+	buf.Reset()
+	if err := json.NewEncoder(buf).Encode(src); err != nil {
+		return nil, err
+	}
+	// Drop the newline written by Encode.
+	b := make([]byte, buf.Len()-1)
+	copy(b, buf.Bytes())
+	return b, nil
+}
+
+// Deserialize decodes the JSON data src into dst.
+func (JSONSerializer) Deserialize(src []byte, dst interface{}) error {
+	if !isJSON(src) {
+		return securecookie.GobEncoder{}.Deserialize(src, dst)
+	}
+	values, ok := dst.(*map[interface{}]interface{})
+	if !ok {
+		return json.Unmarshal(src, dst)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(src))
+	dec.UseNumber()
+	var m map[string]interface{}
+	if err := dec.Decode(&m); err != nil {
+		return err
+	}
+	if *values == nil {
+		*values = make(map[interface{}]interface{}, len(m))
+	}
+	for k, v := range m {
+		(*values)[k] = fromJSON(v)
+	}
+	return nil
+}
+
+// installSerializer applies the store serializer to the store codecs. The
+// key policy set with WithStringKeysOnly, if any, overrides the one the
+// serializer was configured with.
+func (s *MongoStore) installSerializer() {
+	switch sz := s.valueSerializer.(type) {
+	case nil:
+		return
+	case JSONSerializer:
+		if s.keyPolicySet {
+			sz.CoerceKeys = s.coerceKeys
+		}
+		s.valueSerializer = sz
+	case *JSONSerializer:
+		if sz != nil {
+			c := *sz
+			if s.keyPolicySet {
+				c.CoerceKeys = s.coerceKeys
+			}
+			s.valueSerializer = c
+		}
+	case MsgpackSerializer:
+		if s.keyPolicySet {
+			sz.CoerceKeys = s.coerceKeys
+		}
+		s.valueSerializer = sz
+	case *MsgpackSerializer:
+		if sz != nil {
+			c := *sz
+			if s.keyPolicySet {
+				c.CoerceKeys = s.coerceKeys
+			}
+			s.valueSerializer = c
+		}
+	}
+	for _, codec := range s.decodeCodecs() {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			sc.SetSerializer(s.valueSerializer)
+		}
+	}
+}
+
+// isJSON reports whether b looks like a JSON object or string, as produced by
+// JSONSerializer, rather than gob data.
+func isJSON(b []byte) bool {
+	b = bytes.TrimLeft(b, " \t\r\n")
+	return len(b) > 0 && (b[0] == '{' || b[0] == '"') && json.Valid(b)
+}
+
+// serializerName returns the name recorded in session documents to identify
+// the serializer sz.
+func serializerName(sz securecookie.Serializer) string {
+	switch sz.(type) {
+	case securecookie.GobEncoder, *securecookie.GobEncoder:
+		return "gob"
+	case JSONSerializer, *JSONSerializer:
+		return "json"
+	case MsgpackSerializer, *MsgpackSerializer:
+		return "msgpack"
+	}
+	return fmt.Sprintf("%T", sz)
+}
+
+// checkSerializer returns ErrSerializationConflict if the data of doc was
+// written with a serializer the store cannot read. JSONSerializer reads gob
+// data; other serializers only read their own.
+func (s *MongoStore) checkSerializer(doc *Session) error {
+	written := doc.Serializer
+	if written == "" {
+		written = "gob"
+	}
+	current := serializerName(s.serializer())
+	if written == current || (written == "gob" && current == "json") {
+		return nil
+	}
+	return fmt.Errorf("%w: session %s was written with serializer %s, the store uses %s", ErrSerializationConflict, s.sessionID(s.documentID(doc)), written, current)
+}
+
+// stringKeyed converts session values to a map that encoding/json supports,
+// recursively. Non-string keys are converted with fmt.Sprint if coerce is
+// true, and rejected otherwise.
+func stringKeyed(values map[interface{}]interface{}, coerce bool) (map[string]interface{}, error) {
+	m := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		key, ok := k.(string)
+		if !ok {
+			if !coerce {
+				return nil, fmt.Errorf("mongostore: cannot serialize non-string key %v (%T)", k, k)
+			}
+			key = fmt.Sprint(k)
+		}
+		if nested, ok := v.(map[interface{}]interface{}); ok {
+			var err error
+			if v, err = stringKeyed(nested, coerce); err != nil {
+				return nil, err
+			}
+		}
+		m[key] = v
+	}
+	return m, nil
+}
+
+// fromJSON converts the numbers of a value decoded with UseNumber to int or
+// float64, recursively.
+func fromJSON(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil && int64(int(i)) == i {
+			return int(i)
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = fromJSON(e)
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = fromJSON(e)
+		}
+	}
+	return v
+}
@@ -0,0 +1,174 @@
+package mongostore
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/gorilla/securecookie"
+)
+
+// jsonMsgpackCodec stands in for a MessagePack library in tests.
+type jsonMsgpackCodec struct{}
+
+func (jsonMsgpackCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonMsgpackCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+func TestSerializerKeyPolicy(t *testing.T) {
+	clean := map[interface{}]interface{}{"user": "alice"}
+	intKeyed := map[interface{}]interface{}{"user": "alice", 42: "answer"}
+	msgpack := MsgpackSerializer{Codec: jsonMsgpackCodec{}}
+	coercingMsgpack := MsgpackSerializer{Codec: jsonMsgpackCodec{}, CoerceKeys: true}
+	tests := []struct {
+		name    string
+		opts    []Option
+		values  map[interface{}]interface{}
+		wantErr bool
+	}{
+		{"JSON clean map", []Option{WithSerializer(JSONSerializer{})}, clean, false},
+		{"JSON int-key map", []Option{WithSerializer(JSONSerializer{})}, intKeyed, true},
+		{"coercing JSON clean map", []Option{WithSerializer(JSONSerializer{CoerceKeys: true})}, clean, false},
+		{"coercing JSON int-key map", []Option{WithSerializer(JSONSerializer{CoerceKeys: true})}, intKeyed, false},
+		{"coercing JSON pointer int-key map", []Option{WithSerializer(&JSONSerializer{CoerceKeys: true})}, intKeyed, false},
+		{"coercing JSON with string keys only", []Option{WithSerializer(JSONSerializer{CoerceKeys: true}), WithStringKeysOnly(true)}, intKeyed, true},
+		{"string keys only before coercing JSON", []Option{WithStringKeysOnly(true), WithSerializer(JSONSerializer{CoerceKeys: true})}, intKeyed, true},
+		{"JSON without string keys only", []Option{WithStringKeysOnly(false), WithSerializer(JSONSerializer{})}, intKeyed, false},
+		{"msgpack clean map", []Option{WithSerializer(msgpack)}, clean, false},
+		{"msgpack int-key map", []Option{WithSerializer(msgpack)}, intKeyed, true},
+		{"coercing msgpack int-key map", []Option{WithSerializer(coercingMsgpack)}, intKeyed, false},
+		{"coercing msgpack pointer int-key map", []Option{WithSerializer(&coercingMsgpack)}, intKeyed, false},
+		{"coercing msgpack with string keys only", []Option{WithSerializer(coercingMsgpack), WithStringKeysOnly(true)}, intKeyed, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newUnitStore(t, tt.opts...)
+			encoded, err := securecookie.EncodeMulti("test", tt.values, s.Codecs...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("EncodeMulti() error = %v, want error: %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			var decoded map[interface{}]interface{}
+			if err := s.decodeMulti("test", encoded, &decoded); err != nil {
+				t.Fatal(err)
+			}
+			if len(decoded) != len(tt.values) || decoded["user"] != "alice" {
+				t.Fatalf("decoded %v, want %v", decoded, tt.values)
+			}
+		})
+	}
+}
+
+// benchmarkValues returns session values typical of a logged-in user, of
+// types gob encodes without registration.
+func benchmarkValues() map[interface{}]interface{} {
+	return map[interface{}]interface{}{
+		"user":      "alice",
+		"userID":    42,
+		"roles":     []string{"admin", "editor"},
+		"csrf":      "0123456789abcdef0123456789abcdef",
+		"loggedIn":  true,
+		"lastSeen":  1577836800.5,
+		"theme":     "dark",
+		"cartItems": 3,
+	}
+}
+
+// benchmarkSerializers are the serializers compared by the benchmarks.
+var benchmarkSerializers = []struct {
+	name       string
+	serializer securecookie.Serializer
+}{
+	{"gob", securecookie.GobEncoder{}},
+	{"JSON", JSONSerializer{}},
+	{"msgpack", MsgpackSerializer{Codec: miniMsgpackCodec{}}},
+}
+
+func BenchmarkSerialize(b *testing.B) {
+	values := benchmarkValues()
+	for _, bb := range benchmarkSerializers {
+		b.Run(bb.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := bb.serializer.Serialize(values); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkDeserialize(b *testing.B) {
+	for _, bb := range benchmarkSerializers {
+		b.Run(bb.name, func(b *testing.B) {
+			data, err := bb.serializer.Serialize(benchmarkValues())
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				var values map[interface{}]interface{}
+				if err := bb.serializer.Deserialize(data, &values); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestSerializationConflict(t *testing.T) {
+	tests := []struct {
+		name     string
+		keyPairs [][]byte
+		opts     []Option
+		wantErr  bool
+	}{
+		{"default serializer", testKeyPairs, nil, false},
+		{"JSON serializer", testKeyPairs, []Option{WithSerializer(JSONSerializer{})}, false},
+		{"insecure codec", nil, []Option{WithInsecureNoKeys()}, false},
+		{"JSON serializer with an insecure codec", nil, []Option{WithInsecureNoKeys(), WithSerializer(JSONSerializer{})}, true},
+		{"msgpack serializer with an insecure codec", nil, []Option{WithInsecureNoKeys(), WithSerializer(MsgpackSerializer{Codec: miniMsgpackCodec{}})}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewMongoStore(newUnitStore(t).collection, nil, tt.keyPairs...)
+			for _, opt := range tt.opts {
+				opt(s)
+			}
+			err := s.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() = %v, want error: %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrSerializationConflict) {
+				t.Fatalf("Validate() = %v, want ErrSerializationConflict", err)
+			}
+		})
+	}
+}
+
+func TestCheckSerializer(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       []Option
+		serializer string
+		wantErr    bool
+	}{
+		{"gob data read with gob", nil, "", false},
+		{"JSON data read with gob", nil, "json", true},
+		{"gob data read with JSON", []Option{WithSerializer(JSONSerializer{})}, "", false},
+		{"JSON data read with JSON", []Option{WithSerializer(JSONSerializer{})}, "json", false},
+		{"msgpack data read with JSON", []Option{WithSerializer(JSONSerializer{})}, "msgpack", true},
+		{"gob data read with msgpack", []Option{WithSerializer(MsgpackSerializer{Codec: miniMsgpackCodec{}})}, "gob", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newUnitStore(t, tt.opts...)
+			err := s.checkSerializer(&Session{Serializer: tt.serializer})
+			if (err != nil) != tt.wantErr || (err != nil && !errors.Is(err, ErrSerializationConflict)) {
+				t.Fatalf("checkSerializer() = %v, want ErrSerializationConflict: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
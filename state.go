@@ -0,0 +1,141 @@
+package mongostore
+
+import (
+	"reflect"
+	"runtime"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/gorilla/sessions"
+)
+
+// states holds the bookkeeping the store needs between loading and saving a
+// session, keyed by the address of the session. It is kept outside
+// session.Values, which applications may replace or range over, and is
+// never persisted. The address does not keep the session alive: the state
+// is dropped once the session is garbage collected.
+var states = struct {
+	sync.Mutex
+	m map[uintptr]*sessionState
+}{m: make(map[uintptr]*sessionState)}
+
+// sessionState holds what the store knows about a loaded session.
+type sessionState struct {
+	// version is the version of the session document, incremented by each
+	// save.
+	version int
+
+	// createdAt is the creation date of the session document.
+	createdAt time.Time
+
+	// chunked reports whether the session document is chunked.
+	chunked bool
+
+	// created reports whether the last save created the session document.
+	created bool
+
+	// cookieID is the session ID carried by the cookie the session was
+	// loaded from, if any.
+	cookieID string
+
+	// stored holds the data of the session document when it was loaded or
+	// last written, or is nil if unknown.
+	stored *Session
+
+	// expiring reports whether the session was loaded past its expiry
+	// date, within the grace period set with WithExpiryGrace.
+	expiring bool
+
+	// watched is the value of the key set by WithRegenerateOnChange when the
+	// session was loaded or last written, if hasWatched.
+	watched    interface{}
+	hasWatched bool
+}
+
+// IsExpiring reports whether session was loaded although it expired, within
+// the grace period set with WithExpiryGrace. Applications should then
+// refresh it, e.g. authenticate the user again silently, and save it, which
+// extends its expiry date.
+func IsExpiring(session *sessions.Session) bool {
+	st := loadedState(session)
+	return st != nil && st.expiring
+}
+
+// stateOf returns the state of session, creating it if needed.
+func stateOf(session *sessions.Session) *sessionState {
+	key := uintptr(unsafe.Pointer(session))
+	states.Lock()
+	defer states.Unlock()
+	if st, ok := states.m[key]; ok {
+		return st
+	}
+	st := &sessionState{}
+	states.m[key] = st
+	runtime.SetFinalizer(session, forgetState)
+	return st
+}
+
+// forgetState drops the state of session, once it is garbage collected.
+func forgetState(session *sessions.Session) {
+	states.Lock()
+	delete(states.m, uintptr(unsafe.Pointer(session)))
+	states.Unlock()
+}
+
+// resetState clears the state of session, once it was turned into a new
+// session.
+func resetState(session *sessions.Session) {
+	if st := loadedState(session); st != nil {
+		*st = sessionState{}
+	}
+}
+
+// loadedState returns the state of session, or nil if it has none.
+func loadedState(session *sessions.Session) *sessionState {
+	states.Lock()
+	defer states.Unlock()
+	return states.m[uintptr(unsafe.Pointer(session))]
+}
+
+// valuesUnchanged reports whether values are the values stored in the
+// document of session, whose state is st, which then only needs to be
+// touched. Data whose
+// codec timestamp is past half the codec maximum age is rewritten, so that
+// it does not expire while the session is kept alive.
+func (s *MongoStore) valuesUnchanged(session *sessions.Session, st *sessionState, values map[interface{}]interface{}, now time.Time) bool {
+	if st.stored == nil || st.chunked || st.stored.Serializer != serializerName(s.serializer()) {
+		return false
+	}
+	if maxAge := s.codecAge(); maxAge > 0 && now.Sub(st.stored.DataModifiedAt) > time.Duration(maxAge)*time.Second/2 {
+		return false
+	}
+	stored := sessions.NewSession(s, session.Name())
+	if err := s.decodeData(stored, st.stored); err != nil {
+		return false
+	}
+	return reflect.DeepEqual(stored.Values, values)
+}
+
+// watchedValueChanged reports whether the value watched by
+// WithRegenerateOnChange changed since session was loaded. A session that is
+// not new but whose loaded value is unknown is deemed changed.
+func (s *MongoStore) watchedValueChanged(session *sessions.Session) bool {
+	if s.regenerateKey == nil {
+		return false
+	}
+	st := loadedState(session)
+	if st == nil || !st.hasWatched {
+		return !session.IsNew
+	}
+	return !reflect.DeepEqual(st.watched, session.Values[s.regenerateKey])
+}
+
+// watch records the value watched by WithRegenerateOnChange in the state st
+// of session.
+func (s *MongoStore) watch(session *sessions.Session, st *sessionState) {
+	if s.regenerateKey != nil {
+		st.watched = session.Values[s.regenerateKey]
+		st.hasWatched = true
+	}
+}
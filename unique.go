@@ -0,0 +1,48 @@
+package mongostore
+
+import (
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// UserIDNameIndexName is the name of the unique index on userID and name
+// created by EnsureIndexes for stores configured with
+// WithUniquePerUserAndName.
+const UserIDNameIndexName = "userID_1_name_1"
+
+// duplicateKeyCode is the code of MongoDB duplicate key errors.
+const duplicateKeyCode = 11000
+
+// deleteOtherUserSessions deletes the sessions of user with the given name,
+// other than the session document id (see WithUniquePerUserAndName).
+func (s *MongoStore) deleteOtherUserSessions(ctx context.Context, user, name string, id interface{}) error {
+	filter := bson.M{"userID": user, "name": name, s.keyField(): bson.M{"$ne": id}}
+	opts := options.Find().SetProjection(bson.M{s.keyField(): 1}).SetCollation(s.indexCollation)
+	ids, err := s.findIDs(ctx, filter, opts)
+	if err != nil {
+		return err
+	}
+	deleted, err := s.deleteDocuments(ctx, ids)
+	if deleted > 0 {
+		s.log(ctx).Debugf("mongostore: replaced %d %q sessions of user %q", deleted, name, user)
+	}
+	return err
+}
+
+// isDuplicateKeyError reports whether err is a MongoDB duplicate key error.
+func isDuplicateKeyError(err error) bool {
+	var we mongo.WriteException
+	if errors.As(err, &we) {
+		for _, e := range we.WriteErrors {
+			if e.Code == duplicateKeyCode {
+				return true
+			}
+		}
+	}
+	var ce mongo.CommandError
+	return errors.As(err, &ce) && ce.Code == duplicateKeyCode
+}
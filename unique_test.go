@@ -0,0 +1,132 @@
+package mongostore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestUniquePerUserAndName(t *testing.T) {
+	type login struct{ user, name string }
+	tests := []struct {
+		name   string
+		logins []login
+		want   int64
+	}{
+		{"second login replaces the first", []login{{"alice", "web"}, {"alice", "web"}}, 1},
+		{"other session name", []login{{"alice", "web"}, {"alice", "mobile"}}, 2},
+		{"other user", []login{{"alice", "web"}, {"bob", "web"}}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := newTestStore(t, WithUniquePerUserAndName(true))
+			if err := s.EnsureIndexes(ctx); err != nil {
+				t.Fatal(err)
+			}
+			var last string
+			for _, l := range tt.logins {
+				session := sessions.NewSession(s, l.name)
+				session.Options = s.sessionOptions(session.Name())
+				session.Values[DefaultUserIDKey] = l.user
+				saveSession(t, s, session)
+				last = session.ID
+			}
+			if n, err := s.collection.CountDocuments(ctx, bson.M{}); err != nil || n != tt.want {
+				t.Fatalf("%d documents, %v, want %d", n, err, tt.want)
+			}
+			objID, err := s.docID(last)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n, err := s.collection.CountDocuments(ctx, s.idFilter(objID)); err != nil || n != 1 {
+				t.Fatalf("last saved session not stored: %d documents, %v", n, err)
+			}
+		})
+	}
+}
+
+func TestUniquePerUserAndNameConcurrentLogins(t *testing.T) {
+	for _, logins := range []int{2, 4, 8} {
+		t.Run(fmt.Sprint(logins, " logins"), func(t *testing.T) {
+			ctx := context.Background()
+			s := newTestStore(t, WithUniquePerUserAndName(true))
+			if err := s.EnsureIndexes(ctx); err != nil {
+				t.Fatal(err)
+			}
+			var (
+				wg    sync.WaitGroup
+				mu    sync.Mutex
+				saved = make(map[string]bool)
+			)
+			start := make(chan struct{})
+			for i := 0; i < logins; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					session := sessions.NewSession(s, "web")
+					session.Options = s.sessionOptions(session.Name())
+					session.Values[DefaultUserIDKey] = "alice"
+					<-start
+					_, err := s.SaveWithResult(httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRecorder(), session)
+					mu.Lock()
+					defer mu.Unlock()
+					switch {
+					case err == nil:
+						saved[session.ID] = true
+					case !isDuplicateKeyError(err):
+						// Losing a race twice in a row is reported as a
+						// duplicate key error; anything else is a bug.
+						t.Errorf("Save() = %v", err)
+					}
+				}()
+			}
+			close(start)
+			wg.Wait()
+			var docs []Session
+			cur, err := s.collection.Find(ctx, bson.M{"userID": "alice", "name": "web"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := cur.All(ctx, &docs); err != nil {
+				t.Fatal(err)
+			}
+			if len(docs) != 1 {
+				t.Fatalf("%d web sessions of the user, want 1", len(docs))
+			}
+			if id := s.sessionID(s.documentID(&docs[0])); !saved[id] {
+				t.Fatalf("remaining session %s was not saved successfully", id)
+			}
+		})
+	}
+}
+
+func TestIsDuplicateKeyError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"other error", errors.New("boom"), false},
+		{"duplicate key write error", mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: duplicateKeyCode}}}, true},
+		{"other write error", mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: 2}}}, false},
+		{"duplicate key command error", mongo.CommandError{Code: duplicateKeyCode}, true},
+		{"wrapped duplicate key error", fmt.Errorf("save: %w", mongo.CommandError{Code: duplicateKeyCode}), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isDuplicateKeyError(tt.err); got != tt.want {
+				t.Fatalf("isDuplicateKeyError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
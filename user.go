@@ -0,0 +1,52 @@
+package mongostore
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DefaultUserIDKey is the default session.Values key holding the ID of the
+// user a session belongs to (see WithUserIDKey). When present, its value is
+// stored in the userID field of the session document.
+const DefaultUserIDKey = "userID"
+
+// userID returns the user ID of values, or an empty string.
+func (s *MongoStore) userID(values map[interface{}]interface{}) string {
+	key := s.userIDKey
+	if key == nil {
+		key = DefaultUserIDKey
+	}
+	switch v := values[key].(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// evictUserSessions deletes the least recently modified sessions of user so
+// that at most s.maxSessionsPerUser remain. It is best-effort: concurrent
+// saves may briefly exceed the limit, and errors are only logged.
+func (s *MongoStore) evictUserSessions(ctx context.Context, user string) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "modifiedAt", Value: -1}}).
+		SetSkip(int64(s.maxSessionsPerUser)).
+		SetProjection(bson.M{s.keyField(): 1}).
+		SetCollation(s.indexCollation)
+	ids, err := s.findIDs(ctx, bson.M{"userID": user}, opts)
+	if err != nil {
+		s.log(ctx).Warnf("mongostore: could not list sessions of user %q: %v", user, err)
+		return
+	}
+	deleted, err := s.deleteDocuments(ctx, ids)
+	if err != nil {
+		s.log(ctx).Warnf("mongostore: could not evict sessions of user %q: %v", user, err)
+		return
+	}
+	s.log(ctx).Debugf("mongostore: evicted %d sessions of user %q", deleted, user)
+}
@@ -0,0 +1,108 @@
+package mongostore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestMaxSessionsPerUser(t *testing.T) {
+	tests := []struct {
+		name  string
+		max   int
+		saved int
+		want  int
+	}{
+		{"no limit", 0, 4, 4},
+		{"below the limit", 3, 2, 2},
+		{"at the limit", 3, 3, 3},
+		{"over the limit", 2, 5, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := newTestStore(t, WithMaxSessionsPerUser(tt.max))
+			other := sessions.NewSession(s, "test")
+			other.Options = s.sessionOptions(other.Name())
+			other.Values[DefaultUserIDKey] = "bob"
+			saveSession(t, s, other)
+			ids := make([]string, tt.saved)
+			for i := range ids {
+				// Keep the modification dates of the sessions apart.
+				time.Sleep(5 * time.Millisecond)
+				session := sessions.NewSession(s, "test")
+				session.Options = s.sessionOptions(session.Name())
+				session.Values[DefaultUserIDKey] = "alice"
+				saveSession(t, s, session)
+				ids[i] = session.ID
+			}
+			for i, id := range ids {
+				docID, err := s.docID(id)
+				if err != nil {
+					t.Fatal(err)
+				}
+				n, err := s.collection.CountDocuments(ctx, s.idFilter(docID))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if kept := i >= tt.saved-tt.want; (n == 1) != kept {
+					t.Errorf("session %d of %d: %d documents, want kept: %v", i+1, tt.saved, n, kept)
+				}
+			}
+			n, err := s.collection.CountDocuments(ctx, bson.M{"userID": "bob"})
+			if err != nil || n != 1 {
+				t.Fatalf("%d sessions of another user left, %v, want 1", n, err)
+			}
+		})
+	}
+}
+
+func TestUserIDKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   []Option
+		key    interface{}
+		wantID string
+	}{
+		{"default key", nil, DefaultUserIDKey, "alice"},
+		{"custom key", []Option{WithUserIDKey("sub")}, "sub", "alice"},
+		{"non-string custom key", []Option{WithUserIDKey(42)}, 42, "alice"},
+		{"default key with a custom key set", []Option{WithUserIDKey("sub")}, DefaultUserIDKey, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := newTestStore(t, tt.opts...)
+			session := sessions.NewSession(s, "test")
+			session.Options = s.sessionOptions(session.Name())
+			session.Values[tt.key] = "alice"
+			saveSession(t, s, session)
+			n, err := s.collection.CountDocuments(ctx, bson.M{"userID": bson.M{"$exists": true}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (n == 1) != (tt.wantID != "") {
+				t.Fatalf("%d documents with a userID field, want one: %v", n, tt.wantID != "")
+			}
+			metas, err := s.ListSessionsForUser(ctx, "alice")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantID == "" {
+				if len(metas) != 0 {
+					t.Fatalf("ListSessionsForUser() = %v, want no session", metas)
+				}
+				return
+			}
+			if len(metas) != 1 || metas[0].ID != session.ID || metas[0].UserID != tt.wantID {
+				t.Fatalf("ListSessionsForUser() = %v, want session %s", metas, session.ID)
+			}
+			if deleted, err := s.DeleteSessionsForUser(ctx, "alice"); err != nil || deleted != 1 {
+				t.Fatalf("DeleteSessionsForUser() = %d, %v, want 1", deleted, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,342 @@
+package mongostore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ExportValues returns a copy of the values of session keyed by strings,
+// e.g. to pass them to a template or an API. Values with a non-string key
+// are skipped, unless the store was configured with
+// WithExportKeyCoercion(true), in which case their keys are converted to
+// strings with fmt.Sprint, like JSONSerializer does. Without that option,
+// the policy set with WithStringKeysOnly applies. The copy is shallow, and
+// storage is not accessed.
+func (s *MongoStore) ExportValues(session *sessions.Session) map[string]interface{} {
+	coerce := s.coerceExportedKeys()
+	m := make(map[string]interface{}, len(session.Values))
+	for k, v := range session.Values {
+		key, ok := k.(string)
+		if !ok {
+			if !coerce {
+				continue
+			}
+			key = fmt.Sprint(k)
+		}
+		m[key] = v
+	}
+	return m
+}
+
+// coerceExportedKeys reports whether ExportValues converts non-string keys
+// to strings (see WithExportKeyCoercion).
+func (s *MongoStore) coerceExportedKeys() bool {
+	if s.exportKeyPolicySet {
+		return s.exportCoerceKeys
+	}
+	return s.coerceKeys
+}
+
+// RedactedValue replaces the values of the keys set by WithRedactedKeys in
+// the output of ExportSafeValues.
+const RedactedValue = "***"
+
+// ExportSafeValues is like ExportValues, but the values of the keys set by
+// WithRedactedKeys are replaced by RedactedValue, so that the copy can be
+// shown to humans, e.g. logged or displayed in an administration console,
+// without leaking secrets.
+func (s *MongoStore) ExportSafeValues(session *sessions.Session) map[string]interface{} {
+	m := s.ExportValues(session)
+	coerce := s.coerceExportedKeys()
+	for k := range s.redactedKeys {
+		key, ok := k.(string)
+		if !ok {
+			if !coerce {
+				continue
+			}
+			key = fmt.Sprint(k)
+		}
+		if _, ok := m[key]; ok {
+			m[key] = RedactedValue
+		}
+	}
+	return m
+}
+
+// GetValue returns the value with the given key of the session with the
+// given ID, and whether it exists, reading only that value from the
+// database. The key can be a dotted path to a nested value. It requires
+// sessions stored with WithBSONValues: values encoded by the codecs, including
+// values encrypted with WithEncryptedKeys, can only be read by loading the
+// whole session.
+//
+// It returns ErrSessionNotFound if there is no such session.
+func (s *MongoStore) GetValue(ctx context.Context, id string, key string) (interface{}, bool, error) {
+	if s.collection == nil {
+		return nil, false, ErrNoCollection
+	}
+	if err := s.checkValueKey("GetValue", key); err != nil {
+		return nil, false, err
+	}
+	objID, err := s.docID(id)
+	if err != nil {
+		return nil, false, ErrSessionNotFound
+	}
+	opts := options.FindOne().
+		SetProjection(bson.M{"values." + key: 1, "expiresAt": 1}).
+		SetSort(bson.D{{Key: "modifiedAt", Value: -1}})
+	raw, err := s.collection.FindOne(ctx, s.scopeFilter(ctx, s.idFilter(objID)), opts).DecodeBytes()
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, false, ErrSessionNotFound
+		}
+		return nil, false, err
+	}
+	if expiresAt, ok := rawTimestamp(raw.Lookup("expiresAt")); ok && expiresAt.Before(s.expiryCutoff(time.Now())) {
+		return nil, false, ErrSessionNotFound
+	}
+	rv, err := raw.LookupErr(append([]string{"values"}, strings.Split(key, ".")...)...)
+	if err != nil {
+		return nil, false, nil
+	}
+	var v interface{}
+	if err := rv.Unmarshal(&v); err != nil {
+		return nil, false, err
+	}
+	return fromBSON(v), true, nil
+}
+
+// FindByValue returns the metadata of the live sessions whose value with the
+// given key equals value, most recently modified first, e.g. to find the
+// sessions of administrators with FindByValue(ctx, "role", "admin"). The key
+// can be a dotted path to a nested value. Like GetValue, it requires
+// sessions stored with WithBSONValues, and values encrypted with
+// WithEncryptedKeys cannot be queried. Queries scan the collection unless the
+// key is indexed: see EnsureValueIndex.
+func (s *MongoStore) FindByValue(ctx context.Context, key string, value interface{}) ([]SessionMeta, error) {
+	if s.collection == nil {
+		return nil, ErrNoCollection
+	}
+	if err := s.checkValueKey("FindByValue", key); err != nil {
+		return nil, err
+	}
+	filter := bson.M{
+		"values." + key: value,
+		"$or": bson.A{
+			bson.M{"expiresAt": bson.M{"$exists": false}},
+			bson.M{"expiresAt": bson.M{"$gte": s.timestamp(s.expiryCutoff(time.Now()))}},
+		},
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "modifiedAt", Value: -1}}).
+		SetProjection(metaProjection)
+	return s.findMeta(ctx, filter, opts)
+}
+
+// IncrementValue atomically adds delta to the numeric value with the given
+// key of the session with the given ID, and returns the new value, e.g. for
+// rate counters, which loading, incrementing and saving the session would
+// make racy. The key can be a dotted path to a nested value; a missing value
+// is set to delta. Like GetValue, it requires sessions stored with
+// WithBSONValues, and fails without changing the value if it is not an
+// integer, e.g. a float, or is encrypted with WithEncryptedKeys.
+//
+// Saves write all the values of a session: a save of the session loaded
+// before the increment overwrites it. The increment bumps the session
+// version, so that SaveIfVersion detects this. It returns ErrSessionNotFound
+// if there is no such session, or if it expired.
+func (s *MongoStore) IncrementValue(ctx context.Context, id string, key string, delta int64) (int64, error) {
+	if s.collection == nil {
+		return 0, ErrNoCollection
+	}
+	if err := s.checkValueKey("IncrementValue", key); err != nil {
+		return 0, err
+	}
+	objID, err := s.docID(id)
+	if err != nil {
+		return 0, ErrSessionNotFound
+	}
+	if s.writeBuffer.has(objID) {
+		// Do not increment an outdated value.
+		if err := s.Flush(ctx); err != nil {
+			return 0, err
+		}
+	}
+	now := time.Now()
+	filter := s.idFilter(objID)
+	filter["$or"] = bson.A{
+		bson.M{"expiresAt": bson.M{"$exists": false}},
+		bson.M{"expiresAt": bson.M{"$gte": s.timestamp(s.expiryCutoff(now))}},
+	}
+	filter = s.scopeFilter(ctx, filter)
+	update := bson.M{
+		"$inc": bson.M{"values." + key: delta, "version": 1},
+		"$set": bson.M{"dataModifiedAt": s.timestamp(now)},
+	}
+	opts := options.FindOneAndUpdate().
+		SetProjection(bson.M{"values." + key: 1}).
+		SetSort(bson.D{{Key: "modifiedAt", Value: -1}}).
+		SetReturnDocument(options.After)
+	// Only integers are incremented: $inc would keep doubles, which cannot
+	// be returned without truncation.
+	integer := bson.M{"$or": bson.A{
+		bson.M{"values." + key: bson.M{"$exists": false}},
+		bson.M{"values." + key: bson.M{"$type": bson.A{"int", "long"}}},
+	}}
+	raw, err := s.collection.FindOneAndUpdate(ctx, bson.M{"$and": bson.A{filter, integer}}, update, opts).DecodeBytes()
+	if err != nil {
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			return 0, err
+		}
+		n, err := s.collection.CountDocuments(ctx, filter, options.Count().SetLimit(1))
+		if err != nil {
+			return 0, err
+		}
+		if n == 0 {
+			return 0, ErrSessionNotFound
+		}
+		return 0, fmt.Errorf("mongostore: value %q of session %s is not an integer", key, id)
+	}
+	s.cacheInvalidate(ctx, id)
+	rv := raw.Lookup(append([]string{"values"}, strings.Split(key, ".")...)...)
+	if n, ok := rv.Int32OK(); ok {
+		return int64(n), nil
+	}
+	return rv.Int64(), nil
+}
+
+// EnsureValueIndex creates an index on the value with the given key of
+// sessions stored with WithBSONValues, if it does not exist, so that
+// FindByValue queries on it do not scan the collection. Only index keys that
+// are frequently queried: each index slows down saves.
+func (s *MongoStore) EnsureValueIndex(ctx context.Context, key string) error {
+	if s.collection == nil {
+		return ErrNoCollection
+	}
+	if err := s.checkValueKey("EnsureValueIndex", key); err != nil {
+		return err
+	}
+	model := mongo.IndexModel{
+		Keys:    bson.D{{Key: "values." + key, Value: 1}},
+		Options: options.Index().SetSparse(true),
+	}
+	_, err := s.collection.Indexes().CreateOne(ctx, model)
+	return err
+}
+
+// checkValueKey returns an error if the values cannot be queried by key in
+// the method with the given name.
+func (s *MongoStore) checkValueKey(method, key string) error {
+	if !s.bsonValues {
+		return fmt.Errorf("mongostore: %s requires WithBSONValues", method)
+	}
+	if key == "" || strings.HasPrefix(key, "$") {
+		return fmt.Errorf("mongostore: invalid value key %q", key)
+	}
+	if s.encryptedKeys[strings.SplitN(key, ".", 2)[0]] {
+		return fmt.Errorf("mongostore: value %q is encrypted and cannot be queried", key)
+	}
+	return nil
+}
+
+// decodeValues decodes the values subdocument raw into the values of
+// session, decrypting encrypted values.
+func (s *MongoStore) decodeValues(session *sessions.Session, raw bson.Raw) error {
+	var m map[string]interface{}
+	if err := bson.Unmarshal(raw, &m); err != nil {
+		return err
+	}
+	for k, v := range m {
+		v, err := s.decryptValue(k, fromBSON(v))
+		if err != nil {
+			return err
+		}
+		session.Values[k] = v
+	}
+	return nil
+}
+
+// encryptValues returns a copy of values whose values with a key set with
+// WithEncryptedKeys are encoded by the codecs, or values itself if there are
+// no such keys.
+func (s *MongoStore) encryptValues(values map[interface{}]interface{}) (map[interface{}]interface{}, error) {
+	if len(s.encryptedKeys) == 0 {
+		return values, nil
+	}
+	encrypted := make(map[interface{}]interface{}, len(values))
+	for k, v := range values {
+		if s.encryptedKeys[k] {
+			// Values are wrapped in a map, which every serializer supports,
+			// and bound to their key by the codecs.
+			data, err := securecookie.EncodeMulti(fmt.Sprint(k), map[interface{}]interface{}{k: v}, s.Codecs...)
+			if err != nil {
+				return nil, fmt.Errorf("mongostore: cannot encrypt value %v: %w", k, err)
+			}
+			v = data
+		}
+		encrypted[k] = v
+	}
+	return encrypted, nil
+}
+
+// decryptValue returns the value v stored with the given key, decoding it if
+// the key was set with WithEncryptedKeys.
+func (s *MongoStore) decryptValue(key string, v interface{}) (interface{}, error) {
+	data, ok := v.(string)
+	if !ok || !s.encryptedKeys[key] {
+		return v, nil
+	}
+	var m map[interface{}]interface{}
+	if err := s.decodeMulti(key, data, &m); err != nil {
+		return nil, fmt.Errorf("mongostore: cannot decrypt value %s: %w", key, err)
+	}
+	return m[key], nil
+}
+
+// fromBSON converts a value decoded from BSON to the types session values
+// are loaded as (see WithBSONValues), recursively.
+func fromBSON(v interface{}) interface{} {
+	switch v := v.(type) {
+	case int32:
+		return int(v)
+	case int64:
+		if int64(int(v)) == v {
+			return int(v)
+		}
+	case primitive.DateTime:
+		return time.Unix(0, int64(v)*int64(time.Millisecond))
+	case primitive.A:
+		a := make([]interface{}, len(v))
+		for i, e := range v {
+			a[i] = fromBSON(e)
+		}
+		return a
+	case primitive.D:
+		m := make(map[string]interface{}, len(v))
+		for _, e := range v {
+			m[e.Key] = fromBSON(e.Value)
+		}
+		return m
+	case primitive.M:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[k] = fromBSON(e)
+		}
+		return m
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = fromBSON(e)
+		}
+	}
+	return v
+}
@@ -0,0 +1,395 @@
+package mongostore
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestExportValues(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []Option
+		want     map[string]interface{}
+		wantSafe map[string]interface{}
+	}{
+		{"default", nil,
+			map[string]interface{}{"user": "alice"},
+			map[string]interface{}{"user": "alice"}},
+		{"string keys only", []Option{WithStringKeysOnly(false)},
+			map[string]interface{}{"user": "alice", "42": "answer"},
+			map[string]interface{}{"user": "alice", "42": RedactedValue}},
+		{"export key coercion", []Option{WithExportKeyCoercion(true)},
+			map[string]interface{}{"user": "alice", "42": "answer"},
+			map[string]interface{}{"user": "alice", "42": RedactedValue}},
+		{"export key coercion disabled", []Option{WithStringKeysOnly(false), WithExportKeyCoercion(false)},
+			map[string]interface{}{"user": "alice"},
+			map[string]interface{}{"user": "alice"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newUnitStore(t, append([]Option{WithRedactedKeys(42)}, tt.opts...)...)
+			session := sessions.NewSession(s, "test")
+			session.Values["user"] = "alice"
+			session.Values[42] = "answer"
+			if got := s.ExportValues(session); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ExportValues() = %v, want %v", got, tt.want)
+			}
+			if got := s.ExportSafeValues(session); !reflect.DeepEqual(got, tt.wantSafe) {
+				t.Errorf("ExportSafeValues() = %v, want %v", got, tt.wantSafe)
+			}
+		})
+	}
+}
+
+func TestExportSafeValues(t *testing.T) {
+	values := map[interface{}]interface{}{"user": "alice", "token": "s3cr3t", "password": "hunter2"}
+	tests := []struct {
+		name string
+		opts []Option
+		want map[string]interface{}
+	}{
+		{"no redacted key", nil,
+			map[string]interface{}{"user": "alice", "token": "s3cr3t", "password": "hunter2"}},
+		{"one redacted key", []Option{WithRedactedKeys("token")},
+			map[string]interface{}{"user": "alice", "token": RedactedValue, "password": "hunter2"}},
+		{"redacted keys", []Option{WithRedactedKeys("token", "password")},
+			map[string]interface{}{"user": "alice", "token": RedactedValue, "password": RedactedValue}},
+		{"redacted keys set twice", []Option{WithRedactedKeys("token"), WithRedactedKeys("password")},
+			map[string]interface{}{"user": "alice", "token": RedactedValue, "password": RedactedValue}},
+		{"absent redacted key", []Option{WithRedactedKeys("apiKey")},
+			map[string]interface{}{"user": "alice", "token": "s3cr3t", "password": "hunter2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newUnitStore(t, tt.opts...)
+			session := sessions.NewSession(s, "test")
+			for k, v := range values {
+				session.Values[k] = v
+			}
+			if got := s.ExportSafeValues(session); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ExportSafeValues() = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(session.Values, values) {
+				t.Errorf("session values = %v after ExportSafeValues, want %v", session.Values, values)
+			}
+			if got := s.ExportValues(session); got["token"] != "s3cr3t" {
+				t.Errorf("ExportValues() = %v, want unredacted values", got)
+			}
+		})
+	}
+}
+
+func TestGetValue(t *testing.T) {
+	tests := []struct {
+		name    string
+		id      func(id string) string
+		key     string
+		want    interface{}
+		wantOK  bool
+		wantErr error
+	}{
+		{"present value", nil, "flag", true, true, nil},
+		{"nested value", nil, "prefs.theme", "dark", true, nil},
+		{"absent value", nil, "missing", nil, false, nil},
+		{"absent nested value", nil, "prefs.missing", nil, false, nil},
+		{"missing session", func(string) string { return primitive.NewObjectID().Hex() }, "flag", nil, false, ErrSessionNotFound},
+		{"malformed ID", func(string) string { return "not an ID" }, "flag", nil, false, ErrSessionNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore(t, WithBSONValues(true))
+			session := sessions.NewSession(s, "test")
+			session.Options = s.sessionOptions(session.Name())
+			session.Values["flag"] = true
+			session.Values["user"] = "alice"
+			session.Values["prefs"] = map[string]interface{}{"theme": "dark"}
+			saveSession(t, s, session)
+			id := session.ID
+			if tt.id != nil {
+				id = tt.id(id)
+			}
+			got, ok, err := s.GetValue(context.Background(), id, tt.key)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("GetValue() error = %v, want %v", err, tt.wantErr)
+			}
+			if ok != tt.wantOK || got != tt.want {
+				t.Fatalf("GetValue() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestFindByValue(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t, WithBSONValues(true))
+	if err := s.EnsureValueIndex(ctx, "prefs.team"); err != nil {
+		t.Fatal(err)
+	}
+	users := make(map[string]string)
+	for _, u := range []struct {
+		user, role, team string
+		expired          bool
+	}{
+		{"alice", "admin", "core", false},
+		{"bob", "user", "core", false},
+		{"carol", "admin", "web", false},
+		{"dave", "admin", "core", true},
+	} {
+		session := sessions.NewSession(s, "test")
+		session.Options = s.sessionOptions(session.Name())
+		session.Values["user"] = u.user
+		session.Values["role"] = u.role
+		session.Values["prefs"] = map[string]interface{}{"team": u.team}
+		saveSession(t, s, session)
+		users[session.ID] = u.user
+		if u.expired {
+			docID, err := s.docID(session.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			update := bson.M{"$set": bson.M{"expiresAt": s.timestamp(time.Now().Add(-time.Hour))}}
+			if _, err := s.collection.UpdateOne(ctx, s.idFilter(docID), update); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	tests := []struct {
+		name  string
+		key   string
+		value interface{}
+		want  []string
+	}{
+		{"top-level value", "role", "admin", []string{"alice", "carol"}},
+		{"nested value", "prefs.team", "core", []string{"alice", "bob"}},
+		{"no match", "role", "owner", nil},
+		{"absent key", "missing", "admin", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metas, err := s.FindByValue(ctx, tt.key, tt.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, meta := range metas {
+				got = append(got, users[meta.ID])
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("FindByValue(%q, %v) = %v, want %v", tt.key, tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckValueKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []Option
+		key     string
+		wantErr bool
+	}{
+		{"BSON values", []Option{WithBSONValues(true)}, "role", false},
+		{"nested key", []Option{WithBSONValues(true)}, "prefs.team", false},
+		{"without BSON values", nil, "role", true},
+		{"empty key", []Option{WithBSONValues(true)}, "", true},
+		{"operator", []Option{WithBSONValues(true)}, "$where", true},
+		{"encrypted key", []Option{WithBSONValues(true), WithEncryptedKeys("prefs")}, "prefs.team", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newUnitStore(t, tt.opts...)
+			if err := s.checkValueKey("FindByValue", tt.key); (err != nil) != tt.wantErr {
+				t.Fatalf("checkValueKey(%q) = %v, want error: %v", tt.key, err, tt.wantErr)
+			}
+			if _, err := s.FindByValue(context.Background(), tt.key, "admin"); tt.wantErr && err == nil {
+				t.Fatalf("FindByValue(%q) succeeded", tt.key)
+			}
+			if _, _, err := s.GetValue(context.Background(), "5f8f8c44b54764421b7156c9", tt.key); tt.wantErr && err == nil {
+				t.Fatalf("GetValue(%q) succeeded", tt.key)
+			}
+		})
+	}
+}
+
+func TestIncrementValue(t *testing.T) {
+	tests := []struct {
+		name     string
+		id       func(id string) string
+		key      string
+		delta    int64
+		want     int64
+		wantErr  bool
+		notFound bool
+	}{
+		{"absent value", nil, "hits", 1, 1, false, false},
+		{"present value", nil, "count", 2, 7, false, false},
+		{"negative delta", nil, "count", -3, 2, false, false},
+		{"nested value", nil, "rate.minute", 10, 13, false, false},
+		{"not a number", nil, "user", 1, 0, true, false},
+		{"float value", nil, "ratio", 1, 0, true, false},
+		{"missing session", func(string) string { return primitive.NewObjectID().Hex() }, "count", 1, 0, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore(t, WithBSONValues(true))
+			session := sessions.NewSession(s, "test")
+			session.Options = s.sessionOptions(session.Name())
+			session.Values["user"] = "alice"
+			session.Values["count"] = 5
+			session.Values["ratio"] = 1.5
+			session.Values["rate"] = map[string]interface{}{"minute": 3}
+			saveSession(t, s, session)
+			id := session.ID
+			if tt.id != nil {
+				id = tt.id(id)
+			}
+			got, err := s.IncrementValue(context.Background(), id, tt.key, tt.delta)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Fatalf("IncrementValue() = %d, %v, want %d, error: %v", got, err, tt.want, tt.wantErr)
+			}
+			if errors.Is(err, ErrSessionNotFound) != tt.notFound {
+				t.Fatalf("IncrementValue() error = %v, want ErrSessionNotFound: %v", err, tt.notFound)
+			}
+			if tt.notFound {
+				return
+			}
+			if tt.wantErr {
+				if v, _, err := s.GetValue(context.Background(), id, tt.key); err != nil || v != session.Values[tt.key] {
+					t.Fatalf("stored value %v, %v, want %v", v, err, session.Values[tt.key])
+				}
+				return
+			}
+			stored, ok, err := s.GetValue(context.Background(), id, tt.key)
+			if err != nil || !ok || toInt64(t, stored) != tt.want {
+				t.Fatalf("stored value %v, %v, %v, want %d", stored, ok, err, tt.want)
+			}
+		})
+	}
+}
+
+// toInt64 returns the integer n, as decoded from BSON.
+func toInt64(t *testing.T, n interface{}) int64 {
+	t.Helper()
+	switch n := n.(type) {
+	case int:
+		return int64(n)
+	case int32:
+		return int64(n)
+	case int64:
+		return n
+	}
+	t.Fatalf("%v (%T) is not an integer", n, n)
+	return 0
+}
+
+func TestIncrementValueConcurrent(t *testing.T) {
+	const workers, increments = 10, 20
+	s := newTestStore(t, WithBSONValues(true))
+	session := sessions.NewSession(s, "test")
+	session.Options = s.sessionOptions(session.Name())
+	saveSession(t, s, session)
+	results := make(chan int64, workers*increments)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < increments; j++ {
+				n, err := s.IncrementValue(context.Background(), session.ID, "hits", 1)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				results <- n
+			}
+		}()
+	}
+	wg.Wait()
+	close(results)
+	seen := make(map[int64]bool)
+	for n := range results {
+		if seen[n] {
+			t.Fatalf("increment returned %d twice", n)
+		}
+		seen[n] = true
+	}
+	got, _, err := s.GetValue(context.Background(), session.ID, "hits")
+	if err != nil || toInt64(t, got) != workers*increments {
+		t.Fatalf("hits = %v, %v, want %d", got, err, workers*increments)
+	}
+}
+
+func TestGetValueRequiresBSONValues(t *testing.T) {
+	s := newUnitStore(t)
+	if _, _, err := s.GetValue(context.Background(), primitive.NewObjectID().Hex(), "flag"); err == nil {
+		t.Fatal("GetValue() succeeded without WithBSONValues")
+	}
+}
+
+func TestEncryptedKeys(t *testing.T) {
+	hashKey := []byte("0123456789abcdef0123456789abcdef")
+	blockKey := []byte("0123456789abcdef")
+	tests := []struct {
+		name     string
+		keyPairs [][]byte
+	}{
+		{"signed values", [][]byte{hashKey}},
+		{"encrypted values", [][]byte{hashKey, blockKey}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s, err := NewMongoStoreWithOptions(newTestCollection(t), nil, tt.keyPairs,
+				WithBSONValues(true), WithEncryptedKeys("token"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			session := sessions.NewSession(s, "test")
+			session.Options = s.sessionOptions(session.Name())
+			session.Values["name"] = "Alice"
+			session.Values["token"] = "s3cr3t"
+			value := saveSession(t, s, session)
+
+			var stored struct {
+				Values map[string]interface{} `bson:"values"`
+			}
+			if err := s.collection.FindOne(ctx, bson.M{}).Decode(&stored); err != nil {
+				t.Fatal(err)
+			}
+			if stored.Values["name"] != "Alice" {
+				t.Fatalf("stored name = %v, want it in plaintext", stored.Values["name"])
+			}
+			token, ok := stored.Values["token"].(string)
+			if !ok || token == "s3cr3t" {
+				t.Fatalf("stored token = %v, want it encoded", stored.Values["token"])
+			}
+			if len(tt.keyPairs) > 1 {
+				// Unlike signed values, encrypted ones do not carry the
+				// plaintext.
+				if raw, err := base64.URLEncoding.DecodeString(token); err == nil && bytes.Contains(raw, []byte("s3cr3t")) {
+					t.Fatal("stored token is not encrypted")
+				}
+			}
+
+			loaded := loadSession(t, s, "test", value)
+			if loaded.Values["token"] != "s3cr3t" || loaded.Values["name"] != "Alice" {
+				t.Fatalf("loaded values %v", loaded.Values)
+			}
+			if got, _, err := s.GetValue(ctx, session.ID, "token"); err == nil {
+				t.Fatalf("GetValue() = %v for an encrypted value, want an error", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package mongostore
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Version returns the version of session, i.e. the number of times its
+// document was saved, as of its last load or save by this store. It returns 0
+// for sessions that were never loaded nor saved.
+func (s *MongoStore) Version(session *sessions.Session) int {
+	if st := loadedState(session); st != nil {
+		return st.version
+	}
+	return 0
+}
+
+// SaveIfVersion saves session only if its stored version is still
+// expectedVersion, typically the Version a client last saw. This allows
+// clients to detect concurrent modifications and resolve them explicitly.
+//
+// It returns ErrVersionMismatch if the stored version differs or the session
+// no longer exists, and ErrSessionNotFound if the session has no ID, i.e. was
+// never stored. Unlike Save, it never creates the session document nor
+// changes the session ID, and it does not issue a cookie.
+func (s *MongoStore) SaveIfVersion(ctx context.Context, session *sessions.Session, expectedVersion int) error {
+	if session.ID == "" {
+		return ErrSessionNotFound
+	}
+	cond := bson.M{"version": expectedVersion}
+	if expectedVersion == 0 {
+		cond = bson.M{"version": bson.M{"$in": bson.A{0, nil}}}
+	}
+	release, err := s.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	err = s.write(ctx, nil, session, cond, nil)
+	if errors.Is(err, errConditionFailed) {
+		return ErrVersionMismatch
+	}
+	return err
+}
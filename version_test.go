@@ -0,0 +1,86 @@
+package mongostore
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/gorilla/sessions"
+)
+
+func TestSaveIfVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		offset  int
+		erased  bool
+		wantErr error
+	}{
+		{"current version", 0, false, nil},
+		{"stale version", -1, false, ErrVersionMismatch},
+		{"future version", 1, false, ErrVersionMismatch},
+		{"erased session", 0, true, ErrVersionMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := newTestStore(t)
+			session := sessions.NewSession(s, "test")
+			session.Options = s.sessionOptions(session.Name())
+			session.Values["n"] = 1
+			value := saveSession(t, s, session)
+			loaded := loadSession(t, s, "test", value)
+			loaded.Values["n"] = 2
+			saveSession(t, s, loaded)
+			// The client last saw the session after the second save.
+			client := loadSession(t, s, "test", value)
+			version := s.Version(client)
+			if version == 0 {
+				t.Fatal("loaded session has no version")
+			}
+			if tt.erased {
+				if err := s.eraseID(ctx, client.ID); err != nil {
+					t.Fatal(err)
+				}
+			}
+			client.Values["n"] = 3
+			if err := s.SaveIfVersion(ctx, client, version+tt.offset); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("SaveIfVersion(%d) = %v, want %v", version+tt.offset, err, tt.wantErr)
+			}
+			if tt.erased {
+				return
+			}
+			want := 2
+			if tt.wantErr == nil {
+				want = 3
+			}
+			if got := loadSession(t, s, "test", value); got.Values["n"] != want {
+				t.Fatalf("stored n = %v, want %d", got.Values["n"], want)
+			}
+		})
+	}
+}
+
+func TestSaveIfVersionNewSession(t *testing.T) {
+	tests := []struct {
+		name    string
+		version int
+	}{
+		{"version 0", 0},
+		{"version 1", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The client is not connected: the session must be rejected
+			// before any write.
+			s := newUnitStore(t)
+			session := sessions.NewSession(s, "test")
+			session.Options = s.sessionOptions(session.Name())
+			if err := s.SaveIfVersion(context.Background(), session, tt.version); !errors.Is(err, ErrSessionNotFound) {
+				t.Fatalf("SaveIfVersion(%d) = %v, want ErrSessionNotFound", tt.version, err)
+			}
+			if session.ID != "" {
+				t.Fatalf("SaveIfVersion() set session ID %q", session.ID)
+			}
+		})
+	}
+}